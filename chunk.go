@@ -0,0 +1,345 @@
+/*
+file splits large maps into a grid of smaller 'chunk' maps stitched together
+by a Tiled .world file.
+*/
+package tile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ChunkConfig includes settings for splitting a map into chunks
+type ChunkConfig struct {
+	// size of each chunk in tiles
+	ChunkWidth  int
+	ChunkHeight int
+
+	// in pixels, for an InfiniteMap 0 means use the map's tile size
+	TileWidth  uint
+	TileHeight uint
+
+	// Prefix of output chunk files, written as <prefix>.<x>.<y>.tmx where (x,y)
+	// is the chunk coordinate. The world file is <prefix>.world
+	Prefix string
+
+	// Workers is the number of chunks to write in parallel
+	Workers int
+}
+
+// DefaultChunkConfig returns a chunk config with default settings.
+func DefaultChunkConfig() *ChunkConfig {
+	return &ChunkConfig{
+		ChunkWidth:  64,
+		ChunkHeight: 64,
+		Prefix:      "chunk",
+		Workers:     4,
+	}
+}
+
+// chunkSource is something we can cut chunks out of
+type chunkSource interface {
+	// extent returns the smallest & largest (x,y) that may have tiles set
+	extent() (x0, y0, x1, y1 int, ok bool, err error)
+
+	// palette returns every src that may be used in the map
+	palette() ([]string, error)
+
+	// region returns the rectangle (x0,y0,x1,y1) as a map
+	region(cfg *ChunkConfig, x0, y0, x1, y1 int) (*Map, error)
+}
+
+// worldFile is the Tiled .world JSON format
+// see doc.mapeditor.org/en/stable/manual/worlds/
+type worldFile struct {
+	Maps                 []*worldMap `json:"maps"`
+	OnlyShowAdjacentMaps bool        `json:"onlyShowAdjacentMaps"`
+	Type                 string      `json:"type"`
+}
+
+// worldMap is a single map placed in a .world file (units are pixels)
+type worldMap struct {
+	FileName string `json:"fileName"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+// WriteChunks splits the map into chunks, writing one .tmx per chunk that has
+// tiles set & a .world file that places them all, into `dir`.
+// See writeChunks.
+func (m *Map) WriteChunks(dir string, cfg *ChunkConfig) (int, error) {
+	if cfg == nil {
+		cfg = DefaultChunkConfig()
+	}
+	mcfg := *cfg
+	mcfg.TileWidth = uint(m.TileWidth)
+	mcfg.TileHeight = uint(m.TileHeight)
+
+	// workers read the map in parallel, so the layer index can't be rebuilt
+	// lazily (see layer)
+	m.indexLayers()
+	return writeChunks(m, dir, &mcfg)
+}
+
+// WriteChunks walks all set tiles of the infinite map in chunks, writing one
+// .tmx per chunk that has tiles set & a .world file that places them all,
+// into `dir`. See writeChunks.
+func (i *InfiniteMap) WriteChunks(dir string, cfg *ChunkConfig) (int, error) {
+	if cfg == nil {
+		cfg = DefaultChunkConfig()
+	}
+	icfg := *cfg
+	if icfg.TileWidth == 0 || icfg.TileHeight == 0 {
+		w, h, err := i.TileSize()
+		if err != nil {
+			return 0, err
+		}
+		icfg.TileWidth, icfg.TileHeight = w, h
+	}
+	return writeChunks(i, dir, &icfg)
+}
+
+// writeChunks cuts chunks out of the given source & writes them to `dir`.
+// Every chunk map gives the same tile ID to the same src (so tileset references
+// are consistent between chunks) but includes only the tiles it uses.
+// Returns the number of chunk maps written.
+func writeChunks(s chunkSource, dir string, cfg *ChunkConfig) (int, error) {
+	if cfg.ChunkWidth < 1 || cfg.ChunkHeight < 1 {
+		return 0, fmt.Errorf("invalid chunk size %dx%d", cfg.ChunkWidth, cfg.ChunkHeight)
+	}
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	srcs, err := s.palette()
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(srcs)
+	ids := map[string]uint{}
+	for index, src := range srcs {
+		ids[src] = uint(index + 1)
+	}
+
+	x0, y0, x1, y1, ok, err := s.extent()
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	type chunk struct{ x, y int }
+	work := make(chan chunk)
+
+	var (
+		lock     sync.Mutex
+		firstErr error
+	)
+	world := &worldFile{Maps: []*worldMap{}, Type: "world"}
+	fail := func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range work {
+				cx0 := c.x * cfg.ChunkWidth
+				cy0 := c.y * cfg.ChunkHeight
+
+				m, err := s.region(cfg, cx0, cy0, cx0+cfg.ChunkWidth, cy0+cfg.ChunkHeight)
+				if err != nil {
+					fail(err)
+					continue
+				}
+
+				out := m.withPalette(ids)
+				if out == nil {
+					continue // empty chunk
+				}
+
+				fname := fmt.Sprintf("%s.%d.%d.tmx", cfg.Prefix, c.x, c.y)
+				err = out.WriteFile(filepath.Join(dir, fname))
+				if err != nil {
+					fail(err)
+					continue
+				}
+
+				lock.Lock()
+				world.Maps = append(world.Maps, &worldMap{
+					FileName: fname,
+					X:        cx0 * int(cfg.TileWidth),
+					Y:        cy0 * int(cfg.TileHeight),
+					Width:    cfg.ChunkWidth * int(cfg.TileWidth),
+					Height:   cfg.ChunkHeight * int(cfg.TileHeight),
+				})
+				lock.Unlock()
+			}
+		}()
+	}
+
+	for cy := floorDiv(y0, cfg.ChunkHeight); cy <= floorDiv(y1, cfg.ChunkHeight); cy++ {
+		for cx := floorDiv(x0, cfg.ChunkWidth); cx <= floorDiv(x1, cfg.ChunkWidth); cx++ {
+			work <- chunk{cx, cy}
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return len(world.Maps), firstErr
+	}
+
+	sort.Slice(world.Maps, func(i, j int) bool {
+		if world.Maps[i].Y == world.Maps[j].Y {
+			return world.Maps[i].X < world.Maps[j].X
+		}
+		return world.Maps[i].Y < world.Maps[j].Y
+	})
+	data, err := json.MarshalIndent(world, "", "  ")
+	if err != nil {
+		return len(world.Maps), err
+	}
+
+	return len(world.Maps), ioutil.WriteFile(filepath.Join(dir, cfg.Prefix+".world"), data, 0644)
+}
+
+// withPalette returns a copy of the map where each tile takes it's ID from the
+// given src => ID palette. Only tiles that are used are included in the
+// tileset. If no tiles are set, nil is returned.
+func (m *Map) withPalette(ids map[string]uint) *Map {
+	out := New(&Config{
+		MapWidth:   uint(m.Width),
+		MapHeight:  uint(m.Height),
+		TileWidth:  uint(m.TileWidth),
+		TileHeight: uint(m.TileHeight),
+	})
+	out.RootProperties = m.RootProperties
+	out.ImageLayers = m.ImageLayers
+
+	ts := out.Tilesets[0]
+	used := 0
+	for _, z := range m.ZLevels() {
+		for y := 0; y < m.Height; y++ {
+			for x := 0; x < m.Width; x++ {
+				src, _ := m.At(x, y, z)
+				if src == "" {
+					continue
+				}
+
+				_, ok := ts.tileBySrc[src]
+				if !ok {
+					props, _ := m.Properties(src)
+					t := &Tile{
+						ID:         ids[src],
+						Image:      &Image{Source: src, Width: m.TileWidth, Height: m.TileHeight},
+						Properties: props.toList(),
+					}
+					ts.Tiles = append(ts.Tiles, t)
+					ts.tileByID[ts.FirstGID+t.ID] = t
+					ts.tileBySrc[src] = t
+					if t.ID >= out.nextID {
+						out.nextID = t.ID + 1
+					}
+				}
+
+				out.Set(x, y, z, src)
+				used++
+			}
+		}
+	}
+	if used == 0 {
+		return nil
+	}
+
+	sort.Slice(ts.Tiles, func(i, j int) bool { return ts.Tiles[i].ID < ts.Tiles[j].ID })
+	return out
+}
+
+// extent of a Map is the whole map
+func (m *Map) extent() (x0, y0, x1, y1 int, ok bool, err error) {
+	return 0, 0, m.Width - 1, m.Height - 1, m.Width > 0 && m.Height > 0, nil
+}
+
+// palette of a Map is the srcs of all tiles in it's tilesets
+func (m *Map) palette() ([]string, error) {
+	srcs := []string{}
+	for _, ts := range m.Tilesets {
+		for _, t := range ts.Tiles {
+			if t.Image == nil || t.Image.Source == "" {
+				continue
+			}
+			srcs = append(srcs, t.Image.Source)
+		}
+	}
+	return srcs, nil
+}
+
+// region copies the rectangle (x0,y0,x1,y1) of the map into a new map.
+// Tiles outside of the map are left unset.
+func (m *Map) region(cfg *ChunkConfig, x0, y0, x1, y1 int) (*Map, error) {
+	out := New(&Config{
+		MapWidth:   uint(x1 - x0),
+		MapHeight:  uint(y1 - y0),
+		TileWidth:  uint(m.TileWidth),
+		TileHeight: uint(m.TileHeight),
+	})
+	out.RootProperties = m.RootProperties
+
+	for _, z := range m.ZLevels() {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				if x < 0 || y < 0 || x >= m.Width || y >= m.Height {
+					continue
+				}
+				src, _ := m.At(x, y, z)
+				if src == "" {
+					continue
+				}
+				out.Set(x-x0, y-y0, z, src)
+
+				props, _ := m.Properties(src)
+				out.SetProperties(src, props)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// palette of an InfiniteMap is every distinct src set
+func (i *InfiniteMap) palette() ([]string, error) {
+	_, bySrc, err := i.storage.Counts()
+	if err != nil {
+		return nil, err
+	}
+
+	srcs := []string{}
+	for src := range bySrc {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+	return srcs, nil
+}
+
+// region of an InfiniteMap is a Map of the rectangle (x0,y0,x1,y1)
+func (i *InfiniteMap) region(cfg *ChunkConfig, x0, y0, x1, y1 int) (*Map, error) {
+	return i.mapWithSize(cfg.TileWidth, cfg.TileHeight, x0, y0, x1, y1)
+}
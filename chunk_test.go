@@ -0,0 +1,85 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteChunks(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+			dir := t.TempDir()
+
+			inf.Set(-1, 0, 0, "grass.png")
+			inf.Set(1, 1, 0, "mushroom.png")
+			inf.Set(9, 1, 10, "grass.png")
+
+			cfg := DefaultChunkConfig()
+			cfg.ChunkWidth = 4
+			cfg.ChunkHeight = 4
+			out := filepath.Join(dir, "out")
+
+			n, err := inf.WriteChunks(out, cfg)
+
+			assert.Nil(t, err)
+			assert.Equal(t, 3, n) // (-1,0) (0,0) (2,0) .. (1,0) is empty
+
+			data, err := ioutil.ReadFile(filepath.Join(out, "chunk.world"))
+			assert.Nil(t, err)
+			world := &worldFile{}
+			assert.Nil(t, json.Unmarshal(data, world))
+			assert.Equal(t, 3, len(world.Maps))
+			assert.Equal(t, "chunk.-1.0.tmx", world.Maps[0].FileName)
+			assert.Equal(t, -128, world.Maps[0].X)
+
+			first, err := Open(filepath.Join(out, "chunk.-1.0.tmx"))
+			assert.Nil(t, err)
+			last, err := Open(filepath.Join(out, "chunk.2.0.tmx"))
+			assert.Nil(t, err)
+
+			// the same src has the same ID in both chunks
+			assert.Equal(t, first.Tilesets[0].Tiles[0].Image.Source, last.Tilesets[0].Tiles[0].Image.Source)
+			assert.Equal(t, first.Tilesets[0].Tiles[0].ID, last.Tilesets[0].Tiles[0].ID)
+		})
+	}
+}
+
+func TestMapWriteChunks(t *testing.T) {
+	m := New(&Config{MapWidth: 8, MapHeight: 8, TileWidth: 16, TileHeight: 16})
+	m.Set(7, 7, 0, "grass.png")
+
+	cfg := DefaultChunkConfig()
+	cfg.ChunkWidth = 4
+	cfg.ChunkHeight = 4
+
+	n, err := m.WriteChunks(t.TempDir(), cfg)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestMapWriteChunksWorkers(t *testing.T) {
+	m := New(&Config{MapWidth: 16, MapHeight: 16, TileWidth: 16, TileHeight: 16})
+	for x := 0; x < 16; x++ {
+		m.Set(x, x, 0, "grass.png")
+	}
+
+	// layers added directly aren't indexed until read
+	l := &TileLayer{Name: "1", Width: 16, Height: 16, decodedTiles: m.TileLayers[0].decodedTiles}
+	m.TileLayers = append(m.TileLayers, l)
+
+	cfg := DefaultChunkConfig()
+	cfg.ChunkWidth = 2
+	cfg.ChunkHeight = 2
+	cfg.Workers = 8
+
+	n, err := m.WriteChunks(t.TempDir(), cfg)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 8, n)
+}
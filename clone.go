@@ -0,0 +1,465 @@
+/* file adds copying & comparing maps. */
+package tile
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiffKind is what a Difference between two maps is in
+type DiffKind int
+
+const (
+	// DiffMap is a map level setting (Key is eg. "width" or "properties")
+	DiffMap DiffKind = iota
+
+	// DiffTileset is a tile src in one map but not the other, or the image
+	// of a single image tileset (Key is it's name)
+	DiffTileset
+
+	// DiffProperties is the properties of the tile src Key
+	DiffProperties
+
+	// DiffLayer is a tile layer in one map but not the other (Key is "")
+	// or a change to it's properties (Key is "properties")
+	DiffLayer
+
+	// DiffImageLayer is an image layer (Key is it's name) added, removed or changed
+	DiffImageLayer
+
+	// DiffCell is the tile src at (X,Y) on Layer. Flipped tiles have their
+	// flip flags written after the src, eg. "tree.png|flip=hv" (see flipNames)
+	DiffCell
+)
+
+// flipSep separates a tile src from it's flip flags in a DiffCell
+const flipSep = "|flip="
+
+// flipNames are the letters written for each of Tiled's flip flags; flipped
+// horizontally, vertically, diagonally & rotated (hexagonal maps)
+var flipNames = []struct {
+	flag uint
+	name rune
+}{{0x80000000, 'h'}, {0x40000000, 'v'}, {0x20000000, 'd'}, {0x10000000, 'r'}}
+
+// Difference is a single difference between two maps, see Map.Diff
+type Difference struct {
+	Kind  DiffKind `json:"kind"`
+	Layer string   `json:"layer,omitempty"` // tile layer name (DiffLayer & DiffCell)
+	X     int      `json:"x,omitempty"`     // DiffCell only
+	Y     int      `json:"y,omitempty"`
+	Key   string   `json:"key,omitempty"`
+
+	// Old & New are the values in our map & the other map, "" for not set
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// diffKinds are the names of each DiffKind as written in patches
+var diffKinds = []string{"map", "tileset", "properties", "layer", "imagelayer", "cell"}
+
+// MarshalText returns the name of the kind
+func (k DiffKind) MarshalText() ([]byte, error) {
+	if k < 0 || int(k) >= len(diffKinds) {
+		return nil, fmt.Errorf("unknown diff kind %d", k)
+	}
+	return []byte(diffKinds[k]), nil
+}
+
+// UnmarshalText reads a kind from it's name
+func (k *DiffKind) UnmarshalText(text []byte) error {
+	for i, name := range diffKinds {
+		if name == string(text) {
+			*k = DiffKind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown diff kind %s", text)
+}
+
+// String returns a readable description of the difference
+func (d Difference) String() string {
+	what := ""
+	switch d.Kind {
+	case DiffMap:
+		what = "map " + d.Key
+	case DiffTileset:
+		what = "tileset " + d.Key
+	case DiffProperties:
+		what = "properties of " + d.Key
+	case DiffLayer:
+		what = "layer " + d.Layer
+		if d.Key != "" {
+			what += " " + d.Key
+		}
+	case DiffImageLayer:
+		what = "image layer " + d.Key
+	case DiffCell:
+		what = fmt.Sprintf("cell (%d,%d) on layer %s", d.X, d.Y, d.Layer)
+	}
+	return fmt.Sprintf("%s: %q -> %q", what, d.Old, d.New)
+}
+
+// Clone returns a deep copy of the map, sharing nothing with it.
+// Subscribers of the map are not copied.
+func (m *Map) Clone() *Map {
+	out := &Map{
+		XMLName:        m.XMLName,
+		Orientation:    m.Orientation,
+		Width:          m.Width,
+		Height:         m.Height,
+		TileWidth:      m.TileWidth,
+		TileHeight:     m.TileHeight,
+		ExtraAttrs:     cloneAttrs(m.ExtraAttrs),
+		Extra:          cloneRaw(m.Extra),
+		RootProperties: cloneProperties(m.RootProperties),
+		Tilesets:       make([]*Tileset, len(m.Tilesets)),
+		ImageLayers:    make([]*ImageLayer, len(m.ImageLayers)),
+		TileLayers:     make([]*TileLayer, len(m.TileLayers)),
+		layers:         m.layers,
+		images:         m.images,
+	}
+
+	for i, ts := range m.Tilesets {
+		out.Tilesets[i] = cloneTileset(ts)
+	}
+
+	for i, l := range m.ImageLayers {
+		cp := *l
+		cp.Image = cloneImage(l.Image)
+		cp.ExtraAttrs = cloneAttrs(l.ExtraAttrs)
+		cp.Extra = cloneRaw(l.Extra)
+		out.ImageLayers[i] = &cp
+	}
+
+	for i, tl := range m.TileLayers {
+		cp := *tl
+		cp.Properties = cloneProperties(tl.Properties)
+		cp.Data.RawData = append([]byte{}, tl.Data.RawData...)
+		cp.Data.ExtraAttrs = cloneAttrs(tl.Data.ExtraAttrs)
+		cp.ExtraAttrs = cloneAttrs(tl.ExtraAttrs)
+		cp.Extra = cloneRaw(tl.Extra)
+		cp.decodedTiles = tl.decodedTiles.clone()
+		out.TileLayers[i] = &cp
+	}
+
+	// rebuild caches (by gid, by src, by z-level) for the copies
+	out.index()
+	return out
+}
+
+// cloneProperties returns a copy of a list of properties
+func cloneProperties(in []*Property) []*Property {
+	if in == nil {
+		return nil
+	}
+	out := make([]*Property, len(in))
+	for i, p := range in {
+		cp := *p
+		cp.ExtraAttrs = cloneAttrs(p.ExtraAttrs)
+		out[i] = &cp
+	}
+	return out
+}
+
+// cloneImage returns a copy of an image (or nil)
+func cloneImage(in *Image) *Image {
+	if in == nil {
+		return nil
+	}
+	cp := *in
+	cp.ExtraAttrs = cloneAttrs(in.ExtraAttrs)
+	return &cp
+}
+
+// cloneAttrs returns a copy of a list of XML attributes
+func cloneAttrs(in []xml.Attr) []xml.Attr {
+	if in == nil {
+		return nil
+	}
+	return append([]xml.Attr{}, in...)
+}
+
+// cloneRaw returns a copy of elements we don't use
+func cloneRaw(in []*RawXML) []*RawXML {
+	if in == nil {
+		return nil
+	}
+	out := make([]*RawXML, len(in))
+	for i, e := range in {
+		out[i] = &RawXML{XMLName: e.XMLName, Attrs: cloneAttrs(e.Attrs), Inner: append([]byte{}, e.Inner...), imageLayers: e.imageLayers, tileLayers: e.tileLayers}
+	}
+	return out
+}
+
+// Equal returns if both maps hold the same tiles, properties, layers &
+// tilesets (see Diff).
+func (m *Map) Equal(o *Map) bool {
+	return len(m.Diff(o)) == 0
+}
+
+// Diff returns the differences between our map & `o`, where Old is our
+// value & New is the value in `o`.
+//
+// Maps are compared by what they hold rather than how it's written, so tile
+// gids, tileset layout & the order of layers & properties don't matter.
+// Tile layers are matched by name, image layers by name & tiles by their
+// image src.
+func (m *Map) Diff(o *Map) []Difference {
+	diffs := []Difference{}
+
+	// map level settings
+	for _, d := range []Difference{
+		{Key: "orientation", Old: m.Orientation, New: o.Orientation},
+		{Key: "width", Old: strconv.Itoa(m.Width), New: strconv.Itoa(o.Width)},
+		{Key: "height", Old: strconv.Itoa(m.Height), New: strconv.Itoa(o.Height)},
+		{Key: "tilewidth", Old: strconv.Itoa(m.TileWidth), New: strconv.Itoa(o.TileWidth)},
+		{Key: "tileheight", Old: strconv.Itoa(m.TileHeight), New: strconv.Itoa(o.TileHeight)},
+		{Key: "properties", Old: changeProperties(m.MapProperties()), New: changeProperties(o.MapProperties())},
+	} {
+		if d.Old != d.New {
+			d.Kind = DiffMap
+			diffs = append(diffs, d)
+		}
+	}
+
+	// single image tilesets
+	ourSets, theirSets := m.imageTilesets(), o.imageTilesets()
+	for _, name := range unionKeys(ourSets, theirSets) {
+		if ourSets[name] != theirSets[name] {
+			diffs = append(diffs, Difference{Kind: DiffTileset, Key: name, Old: ourSets[name], New: theirSets[name]})
+		}
+	}
+
+	// tile srcs & their properties
+	ours, theirs := m.tileProperties(), o.tileProperties()
+	for _, src := range unionKeys(ours, theirs) {
+		was, inOurs := ours[src]
+		now, inTheirs := theirs[src]
+		switch {
+		case !inOurs:
+			diffs = append(diffs, Difference{Kind: DiffTileset, Key: src, New: src})
+		case !inTheirs:
+			diffs = append(diffs, Difference{Kind: DiffTileset, Key: src, Old: src})
+		case was != now:
+			diffs = append(diffs, Difference{Kind: DiffProperties, Key: src, Old: was, New: now})
+		}
+	}
+
+	// image layers
+	ourImages, theirImages := m.imageLayers(), o.imageLayers()
+	for _, name := range unionKeys(ourImages, theirImages) {
+		if ourImages[name] != theirImages[name] {
+			diffs = append(diffs, Difference{Kind: DiffImageLayer, Key: name, Old: ourImages[name], New: theirImages[name]})
+		}
+	}
+
+	// tile layers, in the order of our map then any only in `o`
+	names := []string{}
+	seen := map[string]bool{}
+	for _, tl := range append(append([]*TileLayer{}, m.TileLayers...), o.TileLayers...) {
+		if !seen[tl.Name] {
+			seen[tl.Name] = true
+			names = append(names, tl.Name)
+		}
+	}
+	for _, name := range names {
+		a, b := m.namedLayer(name), o.namedLayer(name)
+		switch {
+		case a == nil:
+			diffs = append(diffs, Difference{Kind: DiffLayer, Layer: name, New: name})
+		case b == nil:
+			diffs = append(diffs, Difference{Kind: DiffLayer, Layer: name, Old: name})
+		default:
+			was := changeProperties(newPropertiesFromList(a.Properties))
+			now := changeProperties(newPropertiesFromList(b.Properties))
+			if was != now {
+				diffs = append(diffs, Difference{Kind: DiffLayer, Layer: name, Key: "properties", Old: was, New: now})
+			}
+		}
+		diffs = append(diffs, diffCells(name, m, a, o, b)...)
+	}
+
+	return diffs
+}
+
+// diffCells returns the cells that differ between layer `a` of `m` & layer
+// `b` of `o`, where either layer may be nil
+func diffCells(name string, m *Map, a *TileLayer, o *Map, b *TileLayer) []Difference {
+	diffs := []Difference{}
+
+	var ours, theirs *layerTiles
+	if a != nil {
+		ours = a.decodedTiles
+	}
+	if b != nil {
+		theirs = b.decodedTiles
+	}
+
+	// walk both layers in (y, x) order
+	i, gidA := ours.next(0)
+	j, gidB := theirs.next(0)
+	for i >= 0 || j >= 0 {
+		var xa, ya, xb, yb int
+		if i >= 0 {
+			xa, ya = i%m.Width, i/m.Width
+		}
+		if j >= 0 {
+			xb, yb = j%o.Width, j/o.Width
+		}
+
+		switch {
+		case j < 0 || (i >= 0 && (ya < yb || (ya == yb && xa < xb))):
+			diffs = append(diffs, Difference{Kind: DiffCell, Layer: name, X: xa, Y: ya, Old: m.gidSrc(gidA)})
+			i, gidA = ours.next(i + 1)
+		case i < 0 || ya > yb || xa > xb:
+			diffs = append(diffs, Difference{Kind: DiffCell, Layer: name, X: xb, Y: yb, New: o.gidSrc(gidB)})
+			j, gidB = theirs.next(j + 1)
+		default:
+			if was, now := m.gidSrc(gidA), o.gidSrc(gidB); was != now {
+				diffs = append(diffs, Difference{Kind: DiffCell, Layer: name, X: xa, Y: ya, Old: was, New: now})
+			}
+			i, gidA = ours.next(i + 1)
+			j, gidB = theirs.next(j + 1)
+		}
+	}
+
+	return diffs
+}
+
+// gidSrc returns the image src of a gid (& it's flip flags), or it's number
+// if it has none
+func (m *Map) gidSrc(gid uint) string {
+	t := m.tileByGID(gid)
+	if t == nil || t.Image == nil || t.Image.Source == "" {
+		return "#" + strconv.FormatUint(uint64(gid), 10)
+	}
+	return t.Image.Source + flipSuffix(gid)
+}
+
+// flipSuffix returns the flip flags of a gid as written after a src (eg.
+// "|flip=hv"), or "" if it has none
+func flipSuffix(gid uint) string {
+	if gid&gidFlipFlags == 0 {
+		return ""
+	}
+
+	flips := ""
+	for _, f := range flipNames {
+		if gid&f.flag != 0 {
+			flips += string(f.name)
+		}
+	}
+	return flipSep + flips
+}
+
+// splitFlips returns a src written by gidSrc without it's flip flags, & the flags
+func splitFlips(src string) (string, uint) {
+	i := strings.LastIndex(src, flipSep)
+	if i < 0 {
+		return src, 0
+	}
+
+	flags := uint(0)
+	for _, c := range src[i+len(flipSep):] {
+		found := false
+		for _, f := range flipNames {
+			if c == f.name {
+				flags |= f.flag
+				found = true
+			}
+		}
+		if !found {
+			// not flip flags after all
+			return src, 0
+		}
+	}
+	return src[:i], flags
+}
+
+// cloneTileset returns a deep copy of `ts`, it's caches must be rebuilt with index()
+func cloneTileset(ts *Tileset) *Tileset {
+	cp := *ts
+	cp.Properties = cloneProperties(ts.Properties)
+	cp.Image = cloneImage(ts.Image)
+	cp.ExtraAttrs = cloneAttrs(ts.ExtraAttrs)
+	cp.Extra = cloneRaw(ts.Extra)
+	cp.Tiles = make([]*Tile, len(ts.Tiles))
+	for j, t := range ts.Tiles {
+		cp.Tiles[j] = &Tile{
+			ID:         t.ID,
+			Image:      cloneImage(t.Image),
+			Properties: cloneProperties(t.Properties),
+			ExtraAttrs: cloneAttrs(t.ExtraAttrs),
+			Extra:      cloneRaw(t.Extra),
+		}
+	}
+	return &cp
+}
+
+// namedLayer returns the first tile layer with the given name (or nil)
+func (m *Map) namedLayer(name string) *TileLayer {
+	for _, tl := range m.TileLayers {
+		if tl.Name == name {
+			return tl
+		}
+	}
+	return nil
+}
+
+// imageTilesets returns the image of each single image tileset by name
+func (m *Map) imageTilesets() map[string]string {
+	out := map[string]string{}
+	for _, ts := range m.Tilesets {
+		if ts.Image != nil {
+			out[ts.Name] = ts.Image.Source
+		}
+	}
+	return out
+}
+
+// tileProperties returns the encoded properties of every tile src in our tilesets
+func (m *Map) tileProperties() map[string]string {
+	out := map[string]string{}
+	for _, ts := range m.Tilesets {
+		for _, t := range ts.Tiles {
+			if t.Image == nil || t.Image.Source == "" {
+				continue
+			}
+			if _, ok := out[t.Image.Source]; !ok {
+				out[t.Image.Source] = changeProperties(newPropertiesFromList(t.Properties))
+			}
+		}
+	}
+	return out
+}
+
+// imageLayers returns a description of each image layer by name
+func (m *Map) imageLayers() map[string]string {
+	out := map[string]string{}
+	for _, l := range m.ImageLayers {
+		if _, ok := out[l.Name]; ok || l.Image == nil {
+			continue
+		}
+		out[l.Name] = fmt.Sprintf("%s %dx%d at (%d,%d)", l.Image.Source, l.Image.Width, l.Image.Height, l.OffsetX, l.OffsetY)
+	}
+	return out
+}
+
+// unionKeys returns the keys of both maps, sorted
+func unionKeys(a, b map[string]string) []string {
+	keys := []string{}
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
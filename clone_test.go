@@ -0,0 +1,124 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"bytes"
+	"testing"
+)
+
+func TestMapClone(t *testing.T) {
+	m := testHouse()
+	cp := m.Clone()
+	assert.True(t, m.Equal(cp))
+
+	// changing the copy leaves the original alone
+	assert.Nil(t, cp.Set(5, 5, 2, "door.png"))
+	props := NewProperties()
+	props.SetInt("hp", 3)
+	assert.Nil(t, cp.SetProperties("wall.png", props))
+	cp.ImageLayers[0].Image.Source = "night.png"
+
+	src, _ := m.At(5, 5, 2)
+	assert.Equal(t, "wall.png", src)
+	_, gid := m.tileBySrc("door.png")
+	assert.Equal(t, uint(0), gid)
+	props, _ = m.Properties("wall.png")
+	_, ok := props.Int("hp")
+	assert.False(t, ok)
+	assert.Equal(t, "bg.png", m.ImageLayers[0].Image.Source)
+	assert.False(t, m.Equal(cp))
+
+	sparse := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 40, MapHeight: 40, Layers: LayersSparse})
+	sparse.Set(33, 20, 0, "grass.png")
+	cp = sparse.Clone()
+	cp.Set(33, 20, 0, "rock.png")
+	src, _ = sparse.At(33, 20, 0)
+	assert.Equal(t, "grass.png", src)
+}
+
+func TestMapDiff(t *testing.T) {
+	a := testHouse()
+
+	// the same tiles set in another order (so with other gids & layer order) are equal
+	b := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 10, MapHeight: 10})
+	b.SetProperties("unused.png", NewProperties())
+	for x := 6; x >= 4; x-- {
+		b.Set(x, 4, 3, "roof.png")
+		for y := 4; y < 7; y++ {
+			b.Set(x, y, 2, "wall.png")
+		}
+	}
+	for x := 0; x < 10; x++ {
+		b.Set(x, 9, 0, "grass.png")
+	}
+	assert.Equal(t, "3", b.TileLayers[0].Name)
+	props := NewProperties()
+	props.SetBool("blocking", true)
+	b.SetProperties("wall.png", props)
+	b.SetBackground("bg.png")
+	assert.Equal(t, []Difference{}, a.Diff(b))
+
+	b.Set(5, 5, 2, "door.png")
+	b.Set(0, 0, 2, "bird.png")
+	b.Set(4, 9, 0, "")
+	b.Set(1, 1, 7, "cloud.png")
+	b.SetProperties("grass.png", props)
+	b.SetMapProperties(props)
+
+	diffs := a.Diff(b)
+	descriptions := []string{}
+	for _, d := range diffs {
+		descriptions = append(descriptions, d.String())
+	}
+	assert.Equal(t, []string{
+		`map properties: "" -> "{\"I\":{},\"S\":{},\"B\":{\"blocking\":true}}"`,
+		`tileset bird.png: "" -> "bird.png"`,
+		`tileset cloud.png: "" -> "cloud.png"`,
+		`tileset door.png: "" -> "door.png"`,
+		`properties of grass.png: "" -> "{\"I\":{},\"S\":{},\"B\":{\"blocking\":true}}"`,
+		`cell (4,9) on layer 0: "grass.png" -> ""`,
+		`cell (0,0) on layer 2: "" -> "bird.png"`,
+		`cell (5,5) on layer 2: "wall.png" -> "door.png"`,
+		`layer 7: "" -> "7"`,
+		`cell (1,1) on layer 7: "" -> "cloud.png"`,
+	}, descriptions)
+	assert.Equal(t, DiffCell, diffs[5].Kind)
+	assert.Equal(t, "0", diffs[5].Layer)
+}
+
+func TestMapDiffFlipped(t *testing.T) {
+	a := testHouse()
+	b := a.Clone()
+
+	// gid 1 vs 2147483649, the same tile flipped horizontally
+	l := b.layer(0)
+	index := 9*b.Width + 2
+	l.decodedTiles.set(index, l.decodedTiles.get(index)|0x80000000)
+	assert.False(t, a.Equal(b))
+	assert.Equal(t, []Difference{
+		{Kind: DiffCell, Layer: "0", X: 2, Y: 9, Old: "grass.png", New: "grass.png|flip=h"},
+	}, a.Diff(b))
+
+	// flips are kept by patches
+	assert.Nil(t, a.ApplyPatch(NewPatch(a, b), 0, 0, 0, nil))
+	assert.True(t, a.Equal(b))
+	assert.Equal(t, uint(0x80000000), a.layer(0).decodedTiles.get(index)&gidFlipFlags)
+
+	src, flags := splitFlips("grass.png|flip=hvd")
+	assert.Equal(t, "grass.png", src)
+	assert.Equal(t, uint(0xE0000000), flags)
+	src, flags = splitFlips("a|flip=x.png")
+	assert.Equal(t, "a|flip=x.png", src)
+	assert.Equal(t, uint(0), flags)
+}
+
+func TestMapEqualEncoded(t *testing.T) {
+	m := testHouse()
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, m.Encode(buf))
+	decoded, err := Decode(buf)
+	assert.Nil(t, err)
+	assert.True(t, m.Equal(decoded), m.Diff(decoded))
+}
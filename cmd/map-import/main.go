@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/voidshard/tile"
+)
+
+const desc = `Imports a .tmx map (eg. a hand built area made in Tiled) into an 'infinite map' database file.
+
+Tile layers named after an int are written to that z-level, other layers are written to their position
+in the map (0 for the first tile layer) unless given with --layer-z. All z-levels are then offset by --z.`
+
+var cli struct {
+	// what to import & where to
+	Input   string `short:"i" help:"input .tmx map (required)"`
+	Output  string `short:"o" help:"infinite map database file to import into, created if it doesn't exist (required)"`
+	Storage string `default:"sqlite" enum:"sqlite,bolt" help:"storage backend of the database file (sqlite or bolt)"`
+
+	// where the top left corner of the map is placed
+	X int `default:"0" help:"x coord to place the top left corner of the map at"`
+	Y int `default:"0" help:"y coord to place the top left corner of the map at"`
+	Z int `default:"0" help:"offset added to the z-level of every layer"`
+
+	// z-levels for named layers
+	LayerZ map[string]int `short:"l" help:"z-level for a layer by name (eg. --layer-z Ground=0)"`
+
+	NoOverwrite bool `help:"fail (writing nothing) if any tile in the map is already set"`
+	NoMetadata  bool `help:"don't write map properties, tile size or image layers"`
+
+	// name the area the map covers
+	Region string `help:"record the area the map is imported to as a named region"`
+}
+
+func main() {
+	kong.Parse(&cli, kong.Name("map-import"), kong.Description(desc))
+
+	if cli.Input == "" || cli.Output == "" {
+		panic("both --input and --output are required")
+	}
+
+	m, err := tile.Open(cli.Input)
+	if err != nil {
+		panic(err)
+	}
+
+	inf, err := openInfiniteMap()
+	if err != nil {
+		panic(err)
+	}
+
+	cfg := tile.DefaultImportConfig()
+	for name, z := range cli.LayerZ {
+		cfg.LayerZ[name] = z
+	}
+	cfg.Overwrite = !cli.NoOverwrite
+	cfg.Metadata = !cli.NoMetadata
+	cfg.Region = cli.Region
+
+	err = inf.Import(m, cli.X, cli.Y, cli.Z, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("imported %s into %s at (%d,%d,%d)\n", cli.Input, cli.Output, cli.X, cli.Y, cli.Z)
+}
+
+// openInfiniteMap opens the output file with the chosen storage backend
+func openInfiniteMap() (*tile.InfiniteMap, error) {
+	if cli.Storage == "bolt" {
+		s, err := tile.OpenBoltStorage(cli.Output)
+		if err != nil {
+			return nil, err
+		}
+		return tile.NewInfiniteMapWithStorage(s), nil
+	}
+	return tile.OpenInfiniteMap(cli.Output)
+}
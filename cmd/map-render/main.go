@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 
 	"github.com/voidshard/tile"
@@ -14,12 +15,13 @@ const desc = `Generates a tmx map from an 'infinite map' database file.`
 
 var cli struct {
 	// where to find input database file
-	Input  string `short:"i" help:"input inifinite map database file (required)"`
-	Output string `short:"o" help:"where to write output .tmx map. Defaults to input + coords + .tmx. Overwrites output file if it exists."`
+	Input   string `short:"i" help:"input inifinite map database file (required)"`
+	Storage string `default:"sqlite" enum:"sqlite,bolt" help:"storage backend the input database file was written with (sqlite or bolt)"`
+	Output  string `short:"o" help:"where to write output .tmx map. Defaults to input + coords + .tmx. Overwrites output file if it exists."`
 
-	// how wide/high each tile image should be in pixels
-	TileWidth  uint `default:"32" help:"width of each tile in px"`
-	TileHeight uint `default:"32" help:"height of each tile in px"`
+	// how wide/high each tile image should be in pixels, 0 uses the tile size stored in the map
+	TileWidth  uint `default:"0" help:"width of each tile in px, defaults to the map's tile size"`
+	TileHeight uint `default:"0" help:"height of each tile in px, defaults to the map's tile size"`
 
 	X0 int `default:"0" help:"x coord of map, top left corner"`
 	Y0 int `default:"0" help:"y coord of map, top left corner"`
@@ -27,39 +29,193 @@ var cli struct {
 	Y1 int `default:"0" help:"y coord of map, bottom right corner"`
 
 	// set properties on map
-	Props map[string]string `short:"p" help:"set props on resulting map"`
+	Props map[string]string `short:"p" help:"set props on resulting map (merged onto those stored in the map)"`
+
+	// render a .png image rather than a .tmx map
+	Png      bool    `help:"write a rendered .png image of the map rather than a .tmx map"`
+	ImageDir string  `help:"directory tile image sources are relative to (png & pyramid modes only)"`
+	Scale    float64 `default:"1" help:"scale rendered image by this factor (png & pyramid modes only)"`
+	Grid     bool    `help:"draw a grid around each tile (png & pyramid modes only)"`
+	Coords   bool    `help:"write tile coordinates on each tile (png & pyramid modes only)"`
+	ZMin     int     `default:"-2147483648" help:"lowest z-level to render (png & pyramid modes only)"`
+	ZMax     int     `default:"2147483647" help:"highest z-level to render (png & pyramid modes only)"`
+
+	// write a slippy map tile pyramid of the whole map
+	Pyramid   string `help:"write a tile pyramid (<dir>/<zoom>/<x>/<y>.png) of the whole map to the given dir"`
+	ChunkSize int    `default:"8" help:"width & height in tiles of each pyramid image at the highest zoom level (pyramid mode only)"`
+	MaxZoom   int    `default:"5" help:"zoom level of full resolution images, lower levels down to 0 are also written (pyramid mode only)"`
+
+	// print what's in the map
+	Info bool `help:"print the bounds, z-levels and tile counts of the map"`
+
+	// split the whole map into chunk .tmx maps & a .world file
+	Chunks      string `help:"write the whole map as chunk .tmx maps plus a Tiled .world file to the given dir"`
+	ChunkWidth  int    `default:"64" help:"width of each chunk in tiles (chunks mode only)"`
+	ChunkHeight int    `default:"64" help:"height of each chunk in tiles (chunks mode only)"`
+	Workers     int    `default:"4" help:"number of chunks to write in parallel (chunks mode only)"`
 }
 
 func main() {
 	kong.Parse(&cli, kong.Name("map-render"), kong.Description(desc))
 
 	if cli.Output == "" {
-		cli.Output = fmt.Sprintf("%s_%d.%d_%d.%d.tmx", cli.Input, cli.X0, cli.Y0, cli.X1, cli.Y1)
+		ext := "tmx"
+		if cli.Png {
+			ext = "png"
+		}
+		cli.Output = fmt.Sprintf("%s_%d.%d_%d.%d.%s", cli.Input, cli.X0, cli.Y0, cli.X1, cli.Y1, ext)
 	}
 
 	if !fileExists(cli.Input) {
 		panic(fmt.Sprintf("input file not found: %s", cli.Input))
 	}
 
-	inf, err := tile.OpenInfiniteMap(cli.Input)
+	inf, err := openInfiniteMap()
 	if err != nil {
 		panic(err)
 	}
 
-	m, err := inf.Map(cli.TileWidth, cli.TileHeight, cli.X0, cli.Y0, cli.X1, cli.Y1)
+	if cli.Info {
+		err = printInfo(inf)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if cli.Chunks != "" {
+		cfg := tile.DefaultChunkConfig()
+		cfg.ChunkWidth = cli.ChunkWidth
+		cfg.ChunkHeight = cli.ChunkHeight
+		cfg.TileWidth = cli.TileWidth
+		cfg.TileHeight = cli.TileHeight
+		cfg.Workers = cli.Workers
+
+		n, err := inf.WriteChunks(cli.Chunks, cfg)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("wrote %d chunks to %s\n", n, cli.Chunks)
+		return
+	}
+
+	if cli.Pyramid != "" {
+		cfg := tile.DefaultPyramidConfig()
+		cfg.TileWidth = cli.TileWidth
+		cfg.TileHeight = cli.TileHeight
+		cfg.ChunkSize = cli.ChunkSize
+		cfg.MaxZoom = cli.MaxZoom
+		cfg.Render = renderConfig()
+
+		n, err := inf.WritePyramid(cli.Pyramid, cfg)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("wrote %d images to %s\n", n, cli.Pyramid)
+		return
+	}
+
+	m, err := inf.MapWithTileSize(cli.TileWidth, cli.TileHeight, cli.X0, cli.Y0, cli.X1, cli.Y1)
 	if err != nil {
 		panic(err)
 	}
 
-	props := parseProps()
+	props := m.MapProperties().Merge(parseProps())
 	m.SetMapProperties(props)
 
-	err = m.WriteFile(cli.Output)
+	if cli.Png {
+		err = m.WritePNG(cli.Output, renderConfig())
+	} else {
+		err = m.WriteFile(cli.Output)
+	}
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Println("wrote %s", cli.Output)
+	fmt.Printf("wrote %s\n", cli.Output)
+}
+
+// openInfiniteMap opens the input file with the chosen storage backend
+func openInfiniteMap() (*tile.InfiniteMap, error) {
+	if cli.Storage == "bolt" {
+		s, err := tile.OpenBoltStorage(cli.Input)
+		if err != nil {
+			return nil, err
+		}
+		return tile.NewInfiniteMapWithStorage(s), nil
+	}
+	return tile.OpenInfiniteMap(cli.Input)
+}
+
+// printInfo writes out the bounds & stats of the given map
+func printInfo(inf *tile.InfiniteMap) error {
+	b, err := inf.Bounds()
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		fmt.Println("map is empty")
+		return nil
+	}
+
+	st, err := inf.Stats()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("bounds: (%d,%d,%d) -> (%d,%d,%d)\n", b.MinX, b.MinY, b.MinZ, b.MaxX, b.MaxY, b.MaxZ)
+	fmt.Printf("render with: --x0 %d --y0 %d --x1 %d --y1 %d\n", b.MinX, b.MinY, b.MaxX+1, b.MaxY+1)
+	fmt.Printf("tiles: %d\n", st.Tiles)
+
+	fmt.Println("tiles by z-level:")
+	levels, err := inf.ZLevels()
+	if err != nil {
+		return err
+	}
+	for _, z := range levels {
+		fmt.Printf("  %d: %d\n", z, st.TilesByZ[z])
+	}
+
+	fmt.Println("tiles by src:")
+	srcs := []string{}
+	for src := range st.TilesBySrc {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+	for _, src := range srcs {
+		fmt.Printf("  %s: %d\n", src, st.TilesBySrc[src])
+	}
+
+	fmt.Println("tiles by property:")
+	keys := []string{}
+	for k := range st.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values := []string{}
+		for v := range st.Properties[k] {
+			values = append(values, v)
+		}
+		sort.Strings(values)
+		for _, v := range values {
+			fmt.Printf("  %s=%s: %d\n", k, v, st.Properties[k][v])
+		}
+	}
+
+	return nil
+}
+
+// renderConfig builds render settings from the cli flags
+func renderConfig() *tile.RenderConfig {
+	cfg := tile.DefaultRenderConfig()
+	cfg.Dir = cli.ImageDir
+	cfg.Scale = cli.Scale
+	cfg.Grid = cli.Grid
+	cfg.Coords = cli.Coords
+	cfg.ZMin = cli.ZMin
+	cfg.ZMax = cli.ZMax
+	return cfg
 }
 
 // fileExists checks if file exists
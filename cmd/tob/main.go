@@ -63,11 +63,14 @@ var cli struct {
 	// don't write anything
 	DryRun bool `help:"print out what you're planning"`
 
+	// write the tmx tob one element per line, so it's easy to diff
+	Indent bool `help:"write the .tmx tob indented (one element per line)"`
+
 	// where the desired object lives (rectangle x0,y0 x1,y1 top-left -> bottom-right)
-	X0 int    `arg default:"0" help:"where to start getting tiles from (x0)"`
-	Y0 int    `arg default:"0" help:"where to start getting tiles from (y0)"`
-	X1 string `arg default:"1t" help:"where to stop getting tiles from (x1). Either an absolute value (pixels) or a 't' value (offset in tiles), defaults to 1 tile width (1t)"`
-	Y1 string `arg default:"1t" help:"where to stop getting tiles from (y1). Either an absolute value (pixels) or a 't' value (offset in tiles), defaults to 1 tile height (1t)"`
+	X0 int    `arg:"" default:"0" help:"where to start getting tiles from (x0)"`
+	Y0 int    `arg:"" default:"0" help:"where to start getting tiles from (y0)"`
+	X1 string `arg:"" default:"1t" help:"where to stop getting tiles from (x1). Either an absolute value (pixels) or a 't' value (offset in tiles), defaults to 1 tile width (1t)"`
+	Y1 string `arg:"" default:"1t" help:"where to stop getting tiles from (y1). Either an absolute value (pixels) or a 't' value (offset in tiles), defaults to 1 tile height (1t)"`
 
 	// set properties on all tiles
 	Props map[string]string `short:"p" help:"set props on resulting tob"`
@@ -85,7 +88,7 @@ var cli struct {
 	ImageOnly bool `help:"only cut out image(s) (no .tmx file needed)"`
 
 	// Rotate output image(s) - we only support square images, so rotations are in increments of 90
-	Rotate int `help:"rotate image in 90 degree increments (90, 180, 270). Image assumed to be square" default="0" enum="0,90,180,270"`
+	Rotate int `help:"rotate image in 90 degree increments (90, 180, 270). Image assumed to be square" default:"0" enum:"0,90,180,270"`
 }
 
 type TileProps struct {
@@ -395,11 +398,11 @@ func main() {
 		fmt.Printf("skipping %s.tmx exists\n", cli.Name)
 		return
 	}
-	f, err := os.OpenFile(fmt.Sprintf("%s.tmx", cli.Name), os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		panic(err)
+	cfg := tile.DefaultEncodeConfig()
+	if cli.Indent {
+		cfg.Indent = "  "
 	}
-	err = m.Encode(f)
+	err = m.WriteFileWithConfig(fmt.Sprintf("%s.tmx", cli.Name), cfg)
 	if err != nil {
 		panic(err)
 	}
@@ -1,6 +1,8 @@
 package tile
 
-import ()
+import (
+	"time"
+)
 
 // Config includes settings for a TileMap
 type Config struct {
@@ -11,6 +13,10 @@ type Config struct {
 	// in pixels
 	TileWidth  uint
 	TileHeight uint
+
+	// Layers picks how tile layers are held in memory, by default
+	// large maps hold only the parts of layers that have tiles set
+	Layers LayerMode
 }
 
 // DefaultConfig returns a map config with default settings.
@@ -22,3 +28,57 @@ func DefaultConfig() *Config {
 		MapHeight:  100,
 	}
 }
+
+// EncodeConfig includes settings for writing maps as .tmx files
+type EncodeConfig struct {
+	// Indent if set writes each element on it's own line, indented by
+	// Indent per level (eg. "  "), which is easier to read & diff
+	Indent string
+}
+
+// DefaultEncodeConfig returns a config that writes maps compactly
+func DefaultEncodeConfig() *EncodeConfig {
+	return &EncodeConfig{}
+}
+
+// DecodeConfig includes settings for reading .tmx files
+type DecodeConfig struct {
+	// Strict checks the map is one we fully understand; orthogonal, with
+	// tile layers the size of the map that only use tiles from it's tilesets,
+	// which all have images. Otherwise anything readable is accepted.
+	Strict bool
+}
+
+// DefaultDecodeConfig returns a config that accepts any readable map
+func DefaultDecodeConfig() *DecodeConfig {
+	return &DecodeConfig{}
+}
+
+// OpenConfig includes settings for opening an infinite map database file
+type OpenConfig struct {
+	// ReadOnly opens the file for reading only, it must already exist.
+	ReadOnly bool
+
+	// MustExist fails if the file doesn't exist (rather than creating it)
+	MustExist bool
+
+	// CreateOnly fails if the file already exists, so we never open
+	// (& write into) another map by mistake.
+	CreateOnly bool
+
+	// JournalMode is the sqlite journal mode (eg. "WAL", "DELETE", "TRUNCATE")
+	// ignored by other storages. WAL allows reading while another writes.
+	JournalMode string
+
+	// BusyTimeout is how long to wait for another process to finish with the file
+	BusyTimeout time.Duration
+}
+
+// DefaultOpenConfig returns an open config with default settings;
+// the file is created if needed & opened for reading & writing.
+func DefaultOpenConfig() *OpenConfig {
+	return &OpenConfig{
+		JournalMode: "WAL",
+		BusyTimeout: 10 * time.Second,
+	}
+}
@@ -0,0 +1,275 @@
+/* file adds cutting regions out of maps & resizing them. */
+package tile
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+)
+
+// Anchor says which part of a map stays put when it's resized
+type Anchor int
+
+const (
+	AnchorTopLeft Anchor = iota
+	AnchorTop
+	AnchorTopRight
+	AnchorLeft
+	AnchorCenter
+	AnchorRight
+	AnchorBottomLeft
+	AnchorBottom
+	AnchorBottomRight
+)
+
+// offset returns where the top left of a width x height map goes when
+// it's resized to w x h
+func (a Anchor) offset(width, height, w, h int) (int, int) {
+	col, row := int(a)%3, int(a)/3
+	return (w - width) * col / 2, (h - height) * row / 2
+}
+
+// Crop returns a new map of the tiles within `r` (in tiles) on every layer.
+// The new map's tilesets hold only the tiles it uses (with their properties).
+func (m *Map) Crop(r image.Rectangle) (*Map, error) {
+	err := m.checkRect(r)
+	if err != nil {
+		return nil, err
+	}
+
+	out := m.emptyRegion(r)
+	gids := map[uint]uint{}
+	for _, tl := range m.TileLayers {
+		m.copyLayer(out, tl, tl.Name, r, gids)
+	}
+
+	for _, l := range m.ImageLayers {
+		il := m.moveImageLayer(l, out.Width, out.Height, -r.Min.X, -r.Min.Y)
+		if il != nil {
+			out.ImageLayers = append(out.ImageLayers, il)
+		}
+	}
+
+	return out, nil
+}
+
+// Extract returns a new map of the tiles within `r` (in tiles) on z-levels
+// z0 to z1 (not including z1) as a standalone tob, that is with it's
+// z-levels re-based so z0 becomes 0. Layers not named after a z-level
+// & image layers are left out.
+func (m *Map) Extract(r image.Rectangle, z0, z1 int) (*Map, error) {
+	err := m.checkRect(r)
+	if err != nil {
+		return nil, err
+	}
+	if z1 <= z0 {
+		return nil, fmt.Errorf("z-levels %d to %d include no z-levels", z0, z1)
+	}
+
+	out := m.emptyRegion(r)
+	gids := map[uint]uint{}
+	for _, tl := range m.TileLayers {
+		z, ok := layerZ(tl.Name)
+		if !ok || z < z0 || z >= z1 || out.layer(z-z0) != nil {
+			continue
+		}
+		m.copyLayer(out, tl, strconv.Itoa(z-z0), r, gids)
+	}
+
+	return out, nil
+}
+
+// Resize grows or shrinks the map to w x h tiles. The anchor says which part
+// of the map stays put, eg. AnchorBottom keeps the bottom middle of the map
+// where it is, adding (or removing) rows at the top & columns equally from
+// both sides. Tiles that end up outside of the map are removed.
+func (m *Map) Resize(w, h int, anchor Anchor) error {
+	if w < 1 || h < 1 {
+		return fmt.Errorf("map size %dx%d is invalid", w, h)
+	}
+	if anchor < AnchorTopLeft || anchor > AnchorBottomRight {
+		return fmt.Errorf("anchor %d is invalid", anchor)
+	}
+
+	dx, dy := anchor.offset(m.Width, m.Height, w, h)
+	for _, tl := range m.TileLayers {
+		tiles := newLayerTiles(w, h, m.layers.sparse(w, h))
+		for index, gid := tl.decodedTiles.next(0); index >= 0; index, gid = tl.decodedTiles.next(index + 1) {
+			x, y := index%m.Width+dx, index/m.Width+dy
+			if x < 0 || x >= w || y < 0 || y >= h {
+				continue
+			}
+			tiles.set(y*w+x, gid)
+		}
+		tl.decodedTiles = tiles
+		tl.Width = w
+		tl.Height = h
+	}
+
+	layers := []*ImageLayer{}
+	for _, l := range m.ImageLayers {
+		il := m.moveImageLayer(l, w, h, dx, dy)
+		if il != nil {
+			layers = append(layers, il)
+		}
+	}
+	m.ImageLayers = layers
+
+	m.Width = w
+	m.Height = h
+	return nil
+}
+
+// checkRect returns an error if `r` isn't a non empty rectangle within the map
+func (m *Map) checkRect(r image.Rectangle) error {
+	if r.Empty() {
+		return fmt.Errorf("rectangle %v is empty", r)
+	}
+	if !r.In(image.Rect(0, 0, m.Width, m.Height)) {
+		return fmt.Errorf("rectangle %v is outside of the %dx%d map", r, m.Width, m.Height)
+	}
+	return nil
+}
+
+// emptyRegion returns an empty map the size of `r` with our map level settings
+func (m *Map) emptyRegion(r image.Rectangle) *Map {
+	out := New(&Config{
+		MapWidth:   uint(r.Dx()),
+		MapHeight:  uint(r.Dy()),
+		TileWidth:  uint(m.TileWidth),
+		TileHeight: uint(m.TileHeight),
+		Layers:     m.layers,
+	})
+	out.Orientation = m.Orientation
+	out.SetMapProperties(m.MapProperties())
+	out.images = m.images
+	return out
+}
+
+// copyLayer copies the tiles of `tl` within `r` into a new layer `name` of
+// `out`. Gids of our map are mapped to gids of `out` via `gids`.
+// Single image tilesets are copied to `out` whole.
+func (m *Map) copyLayer(out *Map, tl *TileLayer, name string, r image.Rectangle, gids map[uint]uint) {
+	l := out.newTilelayer(name)
+	l.Properties = newPropertiesFromList(tl.Properties).toList()
+
+	for index, gid := tl.decodedTiles.next(0); index >= 0; index, gid = tl.decodedTiles.next(index + 1) {
+		x, y := index%m.Width, index/m.Width
+		if !image.Pt(x, y).In(r) {
+			continue
+		}
+
+		to, ok := gids[gid]
+		if !ok {
+			to = m.copyTile(out, gid)
+			if to == 0 {
+				// not in any of our tilesets
+				continue
+			}
+			gids[gid] = to
+		}
+
+		l.decodedTiles.set((y-r.Min.Y)*out.Width+x-r.Min.X, to)
+	}
+}
+
+// copyTile adds the tile `gid` of our map to `out` & returns it's gid there,
+// keeping any flip flags, or 0 if it's not a tile of ours
+func (m *Map) copyTile(out *Map, gid uint) uint {
+	flags := gid & gidFlipFlags
+
+	tile := m.tileByGID(gid)
+	if tile != nil && tile.Image != nil && tile.Image.Source != "" {
+		t, to := out.tileBySrc(tile.Image.Source)
+		if t == nil {
+			_, to = out.newTile(tile.Image.Source)
+		}
+		out.SetProperties(tile.Image.Source, newPropertiesFromList(tile.Properties))
+		return to | flags
+	}
+
+	// tiles of single image tilesets have no src of their own
+	ts, count := m.imageTileset(gid)
+	if ts == nil {
+		return 0
+	}
+	id := gid&^gidFlipFlags - ts.FirstGID
+	return (out.sheetTileset(ts, count).FirstGID + id) | flags
+}
+
+// sheetTileset returns our copy of the single image tileset `ts`, adding one
+// (of `count` tiles) if we don't have it
+func (m *Map) sheetTileset(ts *Tileset, count int) *Tileset {
+	for _, cp := range m.Tilesets {
+		if cp.Name == ts.Name && cp.Image != nil && cp.Image.Source == ts.Image.Source {
+			return cp
+		}
+	}
+
+	cp := cloneTileset(ts)
+	cp.FirstGID = m.nextFirstGID()
+	cp.TileCount = count
+	cp.index()
+	m.Tilesets = append(m.Tilesets, cp)
+	return cp
+}
+
+// imageTileset returns the single image tileset `gid` is a tile of & the
+// number of tiles in it, or nil if it isn't in one
+func (m *Map) imageTileset(gid uint) (*Tileset, int) {
+	gid &^= gidFlipFlags
+
+	var found *Tileset
+	next := uint(0) // first gid of the tileset after `found`, 0 for none
+	for _, ts := range m.Tilesets {
+		if ts.FirstGID <= gid && (found == nil || ts.FirstGID > found.FirstGID) {
+			found = ts
+		}
+	}
+	if found == nil || found.Image == nil {
+		return nil, 0
+	}
+	for _, ts := range m.Tilesets {
+		if ts.FirstGID > found.FirstGID && (next == 0 || ts.FirstGID < next) {
+			next = ts.FirstGID
+		}
+	}
+
+	count := found.TileCount
+	if count == 0 && next > 0 {
+		count = int(next - found.FirstGID)
+	} else if count == 0 && found.TileWidth > 0 && found.TileHeight > 0 {
+		count = (found.Image.Width / found.TileWidth) * (found.Image.Height / found.TileHeight)
+	}
+	if gid-found.FirstGID >= uint(count) {
+		return nil, 0
+	}
+	return found, count
+}
+
+// moveImageLayer returns a copy of `l` moved by (dx,dy) tiles on a map of
+// w x h tiles, or nil if it no longer overlaps the map. Layers that covered
+// our map (eg. the background) are set to cover the new one.
+func (m *Map) moveImageLayer(l *ImageLayer, w, h, dx, dy int) *ImageLayer {
+	if l.Image == nil {
+		return nil
+	}
+
+	out := *l
+	img := *l.Image
+	out.Image = &img
+
+	if l.OffsetX == 0 && l.OffsetY == 0 && img.Width == m.TileWidth*m.Width && img.Height == m.TileHeight*m.Height {
+		img.Width = m.TileWidth * w
+		img.Height = m.TileHeight * h
+		return &out
+	}
+
+	out.OffsetX += dx * m.TileWidth
+	out.OffsetY += dy * m.TileHeight
+	if out.OffsetX >= w*m.TileWidth || out.OffsetY >= h*m.TileHeight ||
+		out.OffsetX+img.Width <= 0 || out.OffsetY+img.Height <= 0 {
+		return nil
+	}
+	return &out
+}
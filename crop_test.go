@@ -0,0 +1,162 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"image"
+	"strings"
+	"testing"
+)
+
+// testHouse returns a 10x10 map with a house at (4,4) to (6,6) on z-levels 2-3
+func testHouse() *Map {
+	m := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 10, MapHeight: 10})
+	m.SetBackground("bg.png")
+	for x := 0; x < 10; x++ {
+		m.Set(x, 9, 0, "grass.png")
+	}
+	for x := 4; x < 7; x++ {
+		for y := 4; y < 7; y++ {
+			m.Set(x, y, 2, "wall.png")
+		}
+		m.Set(x, 4, 3, "roof.png")
+	}
+	props := NewProperties()
+	props.SetBool("blocking", true)
+	m.SetProperties("wall.png", props)
+	m.SetProperties("unused.png", NewProperties())
+	return m
+}
+
+func TestMapCrop(t *testing.T) {
+	m := testHouse()
+
+	out, err := m.Crop(image.Rect(4, 3, 8, 10))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, out.Width)
+	assert.Equal(t, 7, out.Height)
+
+	src, _ := out.At(0, 1, 2)
+	assert.Equal(t, "wall.png", src)
+	src, _ = out.At(2, 1, 3)
+	assert.Equal(t, "roof.png", src)
+	src, _ = out.At(3, 6, 0)
+	assert.Equal(t, "grass.png", src)
+	assert.Equal(t, []int{0, 2, 3}, out.ZLevels())
+
+	// only used tiles are kept, with their properties
+	assert.Equal(t, 3, len(out.Tilesets[0].Tiles))
+	props, _ := out.Properties("wall.png")
+	blocking, _ := props.Bool("blocking")
+	assert.True(t, blocking)
+
+	// the background is made to cover the new map
+	assert.Equal(t, 1, len(out.ImageLayers))
+	assert.Equal(t, 4*32, out.ImageLayers[0].Image.Width)
+
+	_, err = m.Crop(image.Rect(8, 8, 12, 12))
+	assert.NotNil(t, err)
+	_, err = m.Crop(image.Rect(2, 2, 2, 5))
+	assert.NotNil(t, err)
+}
+
+func TestMapExtract(t *testing.T) {
+	m := testHouse()
+
+	house, err := m.Extract(image.Rect(4, 4, 7, 7), 1, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, house.Width)
+	assert.Equal(t, []int{1, 2}, house.ZLevels())
+	assert.Equal(t, 0, len(house.ImageLayers))
+
+	src, _ := house.At(0, 0, 2)
+	assert.Equal(t, "roof.png", src)
+
+	// the extracted house can be placed as any other tob
+	fits, _ := m.Fits(0, 0, 0, house)
+	assert.True(t, fits)
+	assert.Nil(t, m.Add(0, 0, 0, house))
+	src, _ = m.At(1, 2, 1)
+	assert.Equal(t, "wall.png", src)
+
+	_, err = m.Extract(image.Rect(4, 4, 7, 7), 3, 3)
+	assert.NotNil(t, err)
+}
+
+func TestMapResize(t *testing.T) {
+	m := testHouse()
+
+	assert.Nil(t, m.Resize(14, 12, AnchorBottom))
+	assert.Equal(t, 14, m.Width)
+	assert.Equal(t, 12, m.Height)
+	src, _ := m.At(6, 6, 2)
+	assert.Equal(t, "wall.png", src)
+	src, _ = m.At(11, 11, 0)
+	assert.Equal(t, "grass.png", src)
+	src, _ = m.At(12, 11, 0)
+	assert.Equal(t, "", src)
+	assert.Equal(t, 14*32, m.ImageLayers[0].Image.Width)
+
+	// shrinking removes tiles that end up outside of the map
+	assert.Nil(t, m.Set(1, 1, 1, "bird.png"))
+	assert.Nil(t, m.Resize(5, 5, AnchorTopLeft))
+	src, _ = m.At(1, 1, 1)
+	assert.Equal(t, "bird.png", src)
+	assert.Equal(t, 1, len(m.cells())) // only the bird is left
+
+	assert.NotNil(t, m.Resize(0, 5, AnchorTopLeft))
+	assert.NotNil(t, m.Resize(5, 5, Anchor(9)))
+}
+
+// imageTilesetMap has a single image tileset, whose tiles have no src of their own
+const imageTilesetMap = `<map orientation="orthogonal" width="4" height="2" tilewidth="32" tileheight="32">
+ <tileset firstgid="1" name="ground" tilewidth="32" tileheight="32">
+  <tile id="0"><image source="grass.png" width="32" height="32"/></tile>
+ </tileset>
+ <tileset firstgid="2" name="terrain" tilewidth="32" tileheight="32" tilecount="4" columns="2">
+  <image source="terrain.png" width="64" height="64"/>
+ </tileset>
+ <layer name="0" width="4" height="2">
+  <data encoding="csv">1,2,3,4,
+5,2147483651,1,0</data>
+ </layer>
+</map>`
+
+func TestMapCropImageTileset(t *testing.T) {
+	m, err := Decode(strings.NewReader(imageTilesetMap))
+	assert.Nil(t, err)
+
+	for name, crop := range map[string]func(r image.Rectangle) (*Map, error){
+		"crop":    m.Crop,
+		"extract": func(r image.Rectangle) (*Map, error) { return m.Extract(r, 0, 1) },
+		"region": func(r image.Rectangle) (*Map, error) {
+			return DecodeRegion(strings.NewReader(imageTilesetMap), r, nil)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			out, err := crop(image.Rect(1, 0, 4, 2))
+			assert.Nil(t, err)
+
+			// the tileset is copied whole, tiles keep their place in it (& their flip flags)
+			var terrain *Tileset
+			for _, ts := range out.Tilesets {
+				if ts.Name == "terrain" {
+					terrain = ts
+				}
+			}
+			assert.NotNil(t, terrain)
+			assert.Equal(t, "terrain.png", terrain.Image.Source)
+			assert.Equal(t, 4, terrain.TileCount)
+
+			first := terrain.FirstGID
+			tiles := out.TileLayers[0].decodedTiles
+			assert.Equal(t, []uint{first, first + 1, first + 2, first + 1 | 0x80000000}, []uint{
+				tiles.get(0), tiles.get(1), tiles.get(2), tiles.get(3),
+			})
+
+			src, _ := out.At(1, 1, 0)
+			assert.Equal(t, "grass.png", src)
+			assert.Nil(t, out.validate())
+		})
+	}
+}
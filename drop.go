@@ -0,0 +1,202 @@
+/* file adds "drop onto" placement; putting an object on top of whatever is below it. */
+package tile
+
+import (
+	"fmt"
+)
+
+// SurfaceProperty is an int property that can be set on a tile src to say
+// how far above the tile's own z-level objects dropped onto it should rest.
+// Tiles without it are treated as 1 (the object sits on the next z-level up).
+// Values below 1 are also treated as 1, so a dropped object never replaces the
+// tile it rests on. Eg. a tall rock with "surface" 3 means objects sit 3 levels
+// above it.
+const SurfaceProperty = "surface"
+
+// footprint returns the distinct (x,y) of every set tile in `o` and the
+// lowest z-level `o` has a tile on. If `o` has no tiles `ok` is false.
+func footprint(o *Map) (columns [][2]int, bottom int, ok bool) {
+	seen := map[[2]int]bool{}
+	for _, c := range o.cells() {
+		if !ok || c.Z < bottom {
+			bottom = c.Z
+		}
+		ok = true
+
+		xy := [2]int{c.X, c.Y}
+		if !seen[xy] {
+			seen[xy] = true
+			columns = append(columns, xy)
+		}
+	}
+	return columns, bottom, ok
+}
+
+// surfaceZ returns the z-level objects dropped onto `src` at `z` rest on
+func surfaceZ(z int, props *Properties) int {
+	if props != nil {
+		height, ok := props.Int(SurfaceProperty)
+		if ok && height > 1 {
+			return z + height
+		}
+	}
+	return z + 1
+}
+
+// dropOffset returns the zoffset that puts an object's `bottom` layer at `rest`.
+// Since a negative zoffset means something else to Add & Fits we never go below 0.
+func dropOffset(rest, bottom int) int {
+	if rest-bottom < 0 {
+		return 0
+	}
+	return rest - bottom
+}
+
+// DropZ returns the zoffset that places the bottom layer of `o` at (x,y) just
+// above the highest tile under any of it's set tiles (see SurfaceProperty).
+// If there is nothing below `o` the bottom layer is placed at z-level 0 (or
+// it's own z-level if higher, the result is never negative).
+// The result can be passed to Fits & Add.
+func (m *Map) DropZ(x, y int, o *Map) (int, error) {
+	columns, bottom, ok := footprint(o)
+	if !ok {
+		return 0, nil
+	}
+
+	levels := m.ZLevels()
+	rest := 0
+	for _, xy := range columns {
+		tx, ty := x+xy[0], y+xy[1]
+		if tx < 0 || tx >= m.Width || ty < 0 || ty >= m.Height {
+			continue
+		}
+
+		for i := len(levels) - 1; i >= 0; i-- {
+			src, _ := m.At(tx, ty, levels[i])
+			if src == "" {
+				continue
+			}
+			props, _ := m.Properties(src)
+			if z := surfaceZ(levels[i], props); z > rest {
+				rest = z
+			}
+			break
+		}
+	}
+
+	return dropOffset(rest, bottom), nil
+}
+
+// Drop adds `o` at (x,y) resting on top of whatever is below it (see DropZ).
+// The zoffset used is returned. If `o` doesn't Fit there (eg. it goes off
+// the map) an error is returned & nothing is added.
+func (m *Map) Drop(x, y int, o *Map) (int, error) {
+	zoffset, err := m.DropZ(x, y, o)
+	if err != nil {
+		return 0, err
+	}
+	err = dropFits(m, x, y, zoffset, o)
+	if err != nil {
+		return 0, err
+	}
+	return zoffset, m.Add(x, y, zoffset, o)
+}
+
+// dropFits returns an error if `o` doesn't fit at (x,y,zoffset)
+func dropFits(f Tileable, x, y, zoffset int, o *Map) error {
+	ok, err := f.Fits(x, y, zoffset, o)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("object doesn't fit at (%d,%d) with zoffset %d", x, y, zoffset)
+	}
+	return nil
+}
+
+// DropZ returns the zoffset that places the bottom layer of `o` at (x,y) just
+// above the highest tile under any of it's set tiles (see Map.DropZ).
+func (i *InfiniteMap) DropZ(x, y int, o *Map) (int, error) {
+	return i.dropZ(i.storage, x, y, o)
+}
+
+// Drop adds `o` at (x,y) resting on top of whatever is below it (see DropZ).
+// The zoffset used is returned.
+func (i *InfiniteMap) Drop(x, y int, o *Map) (int, error) {
+	zoffset := 0
+	err := i.Batch(func(tx *InfiniteTx) error {
+		var err error
+		zoffset, err = tx.Drop(x, y, o)
+		return err
+	})
+	return zoffset, err
+}
+
+// dropZ works out the zoffset for Drop using the given store
+func (i *InfiniteMap) dropZ(s Store, x, y int, o *Map) (int, error) {
+	columns, bottom, ok := footprint(o)
+	if !ok {
+		return 0, nil
+	}
+
+	tiles, err := s.Tiles(x, y, x+o.Width, y+o.Height)
+	if err != nil {
+		return 0, err
+	}
+
+	// tiles are ordered by (x,y,z) so the last we see for each (x,y) is the highest
+	highest := map[[2]int]Cell{}
+	for _, t := range tiles {
+		highest[[2]int{t.X - x, t.Y - y}] = t
+	}
+
+	under := []Cell{}
+	srcs := []string{}
+	seen := map[string]bool{}
+	for _, xy := range columns {
+		t, ok := highest[xy]
+		if !ok {
+			continue
+		}
+		under = append(under, t)
+		if !seen[t.Src] {
+			seen[t.Src] = true
+			srcs = append(srcs, t.Src)
+		}
+	}
+
+	props, err := s.Properties(srcs...)
+	if err != nil {
+		return 0, err
+	}
+
+	rest := 0
+	for _, t := range under {
+		if z := surfaceZ(t.Z, props[t.Src]); z > rest {
+			rest = z
+		}
+	}
+
+	return dropOffset(rest, bottom), nil
+}
+
+// DropZ returns the zoffset that places the bottom layer of `o` at (x,y) just
+// above the highest tile under any of it's set tiles (see Map.DropZ).
+func (t *InfiniteTx) DropZ(x, y int, o *Map) (int, error) {
+	return t.inf.dropZ(t.tx, x, y, o)
+}
+
+// Drop adds `o` at (x,y) resting on top of whatever is below it (see DropZ).
+// The zoffset used is returned. If `o` doesn't Fit there an error is returned
+// & nothing is added.
+func (t *InfiniteTx) Drop(x, y int, o *Map) (int, error) {
+	zoffset, err := t.DropZ(x, y, o)
+	if err != nil {
+		return 0, err
+	}
+	err = dropFits(t, x, y, zoffset, o)
+	if err != nil {
+		return 0, err
+	}
+	return zoffset, t.Add(x, y, zoffset, o)
+}
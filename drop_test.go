@@ -0,0 +1,93 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+// dropper is implemented by Map & InfiniteMap
+type dropper interface {
+	Tileable
+	Drop(x, y int, o *Map) (int, error)
+}
+
+func TestDrop(t *testing.T) {
+	for name, impl := range testTileables(t) {
+		t.Run(name, func(t *testing.T) {
+			d := impl.(dropper)
+
+			// uneven terrain: a hill at (2,1) & a pond at (3,0)
+			for x := 0; x < 4; x++ {
+				for y := 0; y < 4; y++ {
+					assert.Nil(t, d.Set(x, y, 0, "grass.png"))
+				}
+			}
+			assert.Nil(t, d.Set(2, 1, 1, "hill.png"))
+			assert.Nil(t, d.Set(2, 1, 2, "hill.png"))
+			assert.Nil(t, d.Set(3, 0, 1, "water.png"))
+			assert.Nil(t, d.Set(3, 3, 1, "rock.png"))
+
+			water := NewProperties()
+			water.SetInt(SurfaceProperty, 0)
+			assert.Nil(t, d.SetProperties("water.png", water))
+			rock := NewProperties()
+			rock.SetInt(SurfaceProperty, 4)
+			assert.Nil(t, d.SetProperties("rock.png", rock))
+
+			// the tree's trunk is at (1,1) but it's canopy covers the hill
+			zoffset, err := d.Drop(0, 0, testTree())
+			assert.Nil(t, err)
+			assert.Equal(t, 3, zoffset)
+			src, _ := d.At(1, 1, 3)
+			assert.Equal(t, "trunk.png", src)
+
+			// a surface below 1 is treated as 1, so the pond isn't replaced
+			stone := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 1, MapHeight: 1})
+			stone.Set(0, 0, 0, "stone.png")
+			zoffset, err = d.Drop(3, 0, stone)
+			assert.Nil(t, err)
+			assert.Equal(t, 2, zoffset)
+			src, _ = d.At(3, 0, 1)
+			assert.Equal(t, "water.png", src)
+			src, _ = d.At(3, 0, 2)
+			assert.Equal(t, "stone.png", src)
+
+			// tall rock
+			zoffset, _ = d.Drop(3, 3, stone)
+			assert.Equal(t, 5, zoffset)
+
+			// plain ground
+			zoffset, _ = d.Drop(0, 3, stone)
+			assert.Equal(t, 1, zoffset)
+		})
+	}
+}
+
+func TestMapDropDoesntFit(t *testing.T) {
+	m := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 2, MapHeight: 2})
+	m.Set(1, 1, 0, "grass.png")
+
+	// the tree's canopy goes off the map
+	_, err := m.Drop(0, 0, testTree())
+	assert.NotNil(t, err)
+
+	src, _ := m.At(1, 1, 1)
+	assert.Equal(t, "", src)
+	assert.Equal(t, 1, len(m.cells()))
+}
+
+func TestMapStackSparseZLevels(t *testing.T) {
+	m := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 4, MapHeight: 4})
+	m.Set(0, 0, 0, "grass.png")
+	m.Set(0, 0, 10, "dirt.png")
+	m.Set(1, 1, 20, "cloud.png")
+
+	flower := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 1, MapHeight: 1})
+	flower.Set(0, 0, 1, "flower.png")
+
+	assert.Nil(t, m.Add(0, 0, -1, flower))
+
+	src, _ := m.At(0, 0, 11)
+	assert.Equal(t, "flower.png", src)
+}
@@ -0,0 +1,63 @@
+/* file holds errors returned when reading maps. */
+package tile
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnsupportedEncoding is tile data that isn't uncompressed CSV
+	ErrUnsupportedEncoding = errors.New("unsupported tile data encoding")
+
+	// ErrInvalidTileData is tile data that can't be read as a list of gids
+	ErrInvalidTileData = errors.New("invalid tile data")
+
+	// ErrMapSize is a map with no tiles or too many to hold
+	ErrMapSize = errors.New("invalid map size")
+
+	// ErrLayerSize is a tile layer that isn't the size of the map (strict only)
+	ErrLayerSize = errors.New("layer size doesn't match the map")
+
+	// ErrUnknownGID is a tile that isn't in any tileset (strict only)
+	ErrUnknownGID = errors.New("gid not found in any tileset")
+
+	// ErrMissingImage is a tile or tileset without an image (strict only)
+	ErrMissingImage = errors.New("missing image")
+
+	// ErrUnsupportedOrientation is a map that isn't orthogonal (strict only)
+	ErrUnsupportedOrientation = errors.New("unsupported orientation")
+)
+
+// maxMapTiles is the most tiles (width * height) a map read from a file may have
+const maxMapTiles = 1 << 27
+
+// DecodeError is an error reading part of a map, it wraps one of the Err*
+// errors above (see errors.Is) with where it was found
+type DecodeError struct {
+	// Element is where the error is, eg. `layer "Ground"`
+	Element string
+
+	// Detail says what's wrong, eg. "100 tiles for a 10x9 map"
+	Detail string
+
+	Err error
+}
+
+// Error implements error
+func (e *DecodeError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s: %v", e.Element, e.Err)
+	}
+	return fmt.Sprintf("%s: %v: %s", e.Element, e.Err, e.Detail)
+}
+
+// Unwrap returns the Err* error
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError returns a *DecodeError
+func decodeError(err error, element, detail string, args ...interface{}) error {
+	return &DecodeError{Element: element, Detail: fmt.Sprintf(detail, args...), Err: err}
+}
@@ -0,0 +1,57 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecodeStrict(t *testing.T) {
+	strict := &DecodeConfig{Strict: true}
+
+	m, err := DecodeWithConfig(bytes.NewBufferString(tiledMap), strict)
+	assert.Nil(t, err)
+	assert.NotNil(t, m)
+
+	for name, tc := range map[string]struct {
+		from, to string
+		expect   error
+		element  string
+		lenient  bool // if lenient decoding accepts it
+	}{
+		"orientation":    {`orientation="orthogonal"`, `orientation="isometric"`, ErrUnsupportedOrientation, "map", true},
+		"short layer":    {"1,0,\n0,1\n", "1,0,0", ErrLayerSize, `layer "0"`, true},
+		"layer width":    {`name="0" width="2"`, `name="0" width="3"`, ErrLayerSize, `layer "0"`, true},
+		"bad value":      {"0,1\n", "0,1a\n", ErrInvalidTileData, `layer "0"`, true},
+		"big value":      {"0,1\n", "0,99999999999\n", ErrInvalidTileData, `layer "0"`, false},
+		"unknown gid":    {"0,1\n", "0,7\n", ErrUnknownGID, `layer "0" (1,1)`, true},
+		"missing image":  {`source="lamp.png" `, ``, ErrMissingImage, `tileset "things" tile 0`, true},
+		"encoding":       {`encoding="csv"`, `encoding="base64"`, ErrUnsupportedEncoding, `layer "0"`, true},
+		"compression":    {`encoding="csv"`, `encoding="csv" compression="zlib"`, ErrUnsupportedEncoding, `layer "0"`, true},
+		"no width":       {`width="2" height="2" tilewidth`, `width="0" height="2" tilewidth`, ErrMapSize, "map", true},
+		"too many tiles": {`width="2" height="2" tilewidth`, `width="100000" height="100000" tilewidth`, ErrMapSize, "map", false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			in := strings.Replace(tiledMap, tc.from, tc.to, 1)
+			assert.NotEqual(t, tiledMap, in)
+
+			_, err := DecodeWithConfig(bytes.NewBufferString(in), strict)
+			assert.True(t, errors.Is(err, tc.expect), err)
+			decodeErr := &DecodeError{}
+			assert.True(t, errors.As(err, &decodeErr))
+			assert.Equal(t, tc.element, decodeErr.Element)
+
+			_, err = Decode(bytes.NewBufferString(in))
+			assert.Equal(t, tc.lenient, err == nil, err)
+		})
+	}
+
+	// our test data has a <data> element within it's <data>, which lenient
+	// decoding skips over
+	_, err = DecodeWithConfig(bytes.NewBufferString(csvdata), strict)
+	assert.True(t, errors.Is(err, ErrInvalidTileData))
+	assert.Equal(t, `layer "Tile Layer 1": invalid tile data: value 0 "<data encoding=\"csv\">\n1" is not a gid`, err.Error())
+}
@@ -0,0 +1,46 @@
+//go:build go1.18
+// +build go1.18
+
+package tile
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzDecode checks malformed maps are rejected (rather than panicking) &
+// that maps accepted by strict decoding are written out as they were read.
+// Run with `go test -fuzz FuzzDecode`.
+func FuzzDecode(f *testing.F) {
+	f.Add(csvdata)
+	f.Add(tiledMap)
+	f.Add(`<map width="2" height="1"><layer name="0"><data encoding="csv">0,</data></layer></map>`)
+	f.Add(`<map width="1" height="1" orientation="orthogonal"><tileset firstgid="4294967295"><tile id="1"/></tileset></map>`)
+
+	f.Fuzz(func(t *testing.T, in string) {
+		Decode(bytes.NewBufferString(in))
+
+		m, err := DecodeWithConfig(bytes.NewBufferString(in), &DecodeConfig{Strict: true})
+		if err != nil {
+			return
+		}
+		if m.Width*m.Height > 64*64 {
+			// valid, but too slow to check often
+			return
+		}
+		m.cells()
+
+		buf := &bytes.Buffer{}
+		err = m.Encode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := DecodeWithConfig(buf, &DecodeConfig{Strict: true})
+		if err != nil {
+			t.Fatalf("re-decoding: %v", err)
+		}
+		if !m.Equal(again) {
+			t.Fatalf("re-decoded map differs: %v", m.Diff(again))
+		}
+	})
+}
@@ -1,21 +1,23 @@
 module github.com/voidshard/tile
 
-go 1.14
+go 1.17
 
 require (
 	github.com/alecthomas/kong v0.2.16
-	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751 // indirect
-	github.com/alecthomas/units v0.0.0-20210208195552-ff826a37aa15 // indirect
 	github.com/fogleman/gg v1.3.0
-	github.com/go-yaml/yaml v2.1.0+incompatible
-	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
-	github.com/jmoiron/sqlx v1.3.4 // indirect
-	github.com/justinfx/gofileseq v2.6.1+incompatible // indirect
-	github.com/mattn/go-sqlite3 v1.14.12 // indirect
-	github.com/mitchellh/go-homedir v1.1.0
+	github.com/jmoiron/sqlx v1.3.4
+	github.com/mattn/go-sqlite3 v1.14.12
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
-	github.com/stretchr/testify v1.6.1
+	github.com/stretchr/testify v1.8.1
+	go.etcd.io/bbolt v1.3.7
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/image v0.0.0-20200801110659-972c09e46d76 // indirect
-	gopkg.in/alecthomas/kingpin.v2 v2.2.6 // indirect
-	gopkg.in/yaml.v2 v2.3.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
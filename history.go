@@ -0,0 +1,405 @@
+/* file adds a change log, snapshots & restoring to an InfiniteMap. */
+package tile
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metadata keys for history
+const (
+	metaHistory  = "history.enabled"
+	metaSnapshot = "snapshot." // + snapshot name
+)
+
+// Snapshot is a named point in an InfiniteMap's change log
+type Snapshot struct {
+	Name string    `json:"-"`
+	Seq  int64     `json:"seq"` // the last change included in the snapshot
+	Time time.Time `json:"time"`
+}
+
+// historyStore wraps a Store so that tile & property writes are added to
+// the change log (with their previous values) and / or kept to be sent
+// to subscribers.
+type historyStore struct {
+	Store
+	tag  string
+	log  bool      // add changes to the change log
+	seen *[]Change // if not nil changes are appended here
+}
+
+// record logs and / or keeps the given changes
+func (h *historyStore) record(changes []Change) error {
+	if h.seen != nil {
+		*h.seen = append(*h.seen, changes...)
+	}
+	if !h.log {
+		return nil
+	}
+	return h.Store.LogChanges(changes...)
+}
+
+// SetTiles writes the given tiles & logs any that changed
+func (h *historyStore) SetTiles(cells ...Cell) error {
+	now := time.Now()
+	changes := []Change{}
+	pending := map[[3]int]string{} // for cells set more than once in a single call
+
+	for _, c := range cells {
+		xyz := [3]int{c.X, c.Y, c.Z}
+		old, ok := pending[xyz]
+		if !ok {
+			var err error
+			old, err = h.Store.Tile(c.X, c.Y, c.Z)
+			if err != nil {
+				return err
+			}
+		}
+		pending[xyz] = c.Src
+		if old == c.Src {
+			continue
+		}
+		changes = append(changes, Change{Time: now, Tag: h.tag, Kind: ChangeTile, X: c.X, Y: c.Y, Z: c.Z, Old: old, New: c.Src})
+	}
+
+	err := h.Store.SetTiles(cells...)
+	if err != nil {
+		return err
+	}
+	return h.record(changes)
+}
+
+// SetProperties overwrites the properties of the given srcs & logs any that changed
+func (h *historyStore) SetProperties(props map[string]*Properties) error {
+	srcs := []string{}
+	for src := range props {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+
+	saved, err := h.Store.Properties(srcs...)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	changes := []Change{}
+	for _, src := range srcs {
+		old, value := changeProperties(saved[src]), changeProperties(props[src])
+		if old == value {
+			continue
+		}
+		changes = append(changes, Change{Time: now, Tag: h.tag, Kind: ChangeProperties, Src: src, Old: old, New: value})
+	}
+
+	err = h.Store.SetProperties(props)
+	if err != nil {
+		return err
+	}
+	return h.record(changes)
+}
+
+// changeProperties encodes properties for a Change, where no properties
+// (nil or empty) are ""
+func changeProperties(props *Properties) string {
+	if props == nil || len(props.ints)+len(props.strings)+len(props.bools) == 0 {
+		return ""
+	}
+	return string(encodeProperties(props))
+}
+
+// EnableHistory turns on (or off) logging of tile & property changes.
+// This is kept in the map so it persists when the map is reopened.
+// While enabled writes are a little slower as previous values must be read.
+// Turning history off logs a ChangeGap, snapshots from before a gap can't be
+// diffed against or restored as changes made during it are unknown.
+// Writes through another InfiniteMap on the same storage that was opened
+// while history was off aren't logged either, & aren't detected.
+func (i *InfiniteMap) EnableHistory(enabled bool) error {
+	value := ""
+	if enabled {
+		value = "true"
+	} else if i.HistoryEnabled() {
+		err := i.storage.LogChanges(Change{Time: time.Now(), Kind: ChangeGap})
+		if err != nil {
+			return err
+		}
+	}
+	err := i.storage.SetMetadata(map[string]string{metaHistory: value})
+	if err != nil {
+		return err
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.history = enabled
+	return nil
+}
+
+// HistoryEnabled returns if tile & property changes are being logged
+func (i *InfiniteMap) HistoryEnabled() bool {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return i.history
+}
+
+// Tagged runs `fn` in a transaction (see Batch) where every logged change
+// is tagged with `tag` (eg. the name of a generation pass).
+func (i *InfiniteMap) Tagged(tag string, fn func(tx *InfiniteTx) error) error {
+	return i.Batch(func(tx *InfiniteTx) error {
+		tx.SetTag(tag)
+		return fn(tx)
+	})
+}
+
+// write calls `fn` with a store to write to. If history is enabled or there
+// are subscribers this is in a transaction so that changes & their log entries
+// are written together & events are sent once they're committed.
+func (i *InfiniteMap) write(fn func(s Store) error) error {
+	if !i.HistoryEnabled() && !i.events.active() {
+		return fn(i.storage)
+	}
+	return i.Batch(func(tx *InfiniteTx) error {
+		return fn(tx.writer())
+	})
+}
+
+// History returns all logged changes made after the named snapshot, oldest first.
+// If name is "" all logged changes are returned.
+func (i *InfiniteMap) History(name string) ([]Change, error) {
+	from, err := snapshotSeq(i.storage, name)
+	if err != nil {
+		return nil, err
+	}
+	last, err := i.storage.LastChange()
+	if err != nil {
+		return nil, err
+	}
+	return i.storage.Changes(from, last)
+}
+
+// Snapshot records the current state of the map under `name`, replacing any
+// snapshot with the same name. History must be enabled.
+func (i *InfiniteMap) Snapshot(name string) error {
+	if !i.HistoryEnabled() {
+		return fmt.Errorf("history is not enabled, unable to snapshot")
+	}
+	if name == "" {
+		return fmt.Errorf("snapshot name required")
+	}
+
+	last, err := i.storage.LastChange()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&Snapshot{Seq: last, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	return i.storage.SetMetadata(map[string]string{metaSnapshot + name: string(data)})
+}
+
+// Snapshots returns all snapshots, oldest first
+func (i *InfiniteMap) Snapshots() ([]*Snapshot, error) {
+	meta, err := i.storage.Metadata(metaSnapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Snapshot{}
+	for key, value := range meta {
+		snap := &Snapshot{}
+		err := json.Unmarshal([]byte(value), snap)
+		if err != nil {
+			return nil, err
+		}
+		snap.Name = strings.TrimPrefix(key, metaSnapshot)
+		result = append(result, snap)
+	}
+
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Seq != result[b].Seq {
+			return result[a].Seq < result[b].Seq
+		}
+		return result[a].Name < result[b].Name
+	})
+	return result, nil
+}
+
+// RemoveSnapshot removes a snapshot by name (if it exists).
+// The change log itself is kept.
+func (i *InfiniteMap) RemoveSnapshot(name string) error {
+	return i.storage.SetMetadata(map[string]string{metaSnapshot + name: ""})
+}
+
+// snapshotSeq returns the Seq of the last change in the named snapshot in `s`.
+// "" is the start of the change log.
+func snapshotSeq(s Store, name string) (int64, error) {
+	if name == "" {
+		return 0, nil
+	}
+
+	meta, err := s.Metadata(metaSnapshot + name)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := meta[metaSnapshot+name]
+	if !ok {
+		return 0, fmt.Errorf("snapshot %s not found", name)
+	}
+
+	snap := &Snapshot{}
+	err = json.Unmarshal([]byte(value), snap)
+	return snap.Seq, err
+}
+
+// SnapshotDiff returns the overall changes between two snapshots, one change
+// per tile or src where Old is the value in snapshot `from` & New the value
+// in snapshot `to`. Tiles & srcs that changed but ended up the same are not
+// included. If `to` is "" the diff is to the current map.
+func (i *InfiniteMap) SnapshotDiff(from, to string) ([]Change, error) {
+	return snapshotDiff(i.storage, from, to)
+}
+
+// snapshotDiff returns the diff between two snapshots in `s` (see SnapshotDiff)
+func snapshotDiff(s Store, from, to string) ([]Change, error) {
+	start, err := snapshotSeq(s, from)
+	if err != nil {
+		return nil, err
+	}
+
+	var end int64
+	if to == "" {
+		end, err = s.LastChange()
+	} else {
+		end, err = snapshotSeq(s, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if end < start {
+		return nil, fmt.Errorf("snapshot %s is before %s", to, from)
+	}
+
+	changes, err := s.Changes(start, end)
+	if err != nil {
+		return nil, err
+	}
+	if hasGap(changes) {
+		return nil, fmt.Errorf("history was disabled after snapshot %s, unable to diff", from)
+	}
+	return squashChanges(changes), nil
+}
+
+// hasGap returns if history was disabled during `changes`
+func hasGap(changes []Change) bool {
+	for _, c := range changes {
+		if c.Kind == ChangeGap {
+			return true
+		}
+	}
+	return false
+}
+
+// squashChanges merges changes to the same tile or src into one, keeping the
+// first Old & the last New. The result is ordered by the last change made.
+func squashChanges(changes []Change) []Change {
+	type key struct {
+		kind    ChangeKind
+		x, y, z int
+		src     string
+	}
+
+	index := map[key]int{}
+	merged := []Change{}
+	for _, c := range changes {
+		k := key{kind: c.Kind, x: c.X, y: c.Y, z: c.Z, src: c.Src}
+		at, ok := index[k]
+		if !ok {
+			index[k] = len(merged)
+			merged = append(merged, c)
+			continue
+		}
+		c.Old = merged[at].Old
+		merged[at] = c
+	}
+
+	result := []Change{}
+	for _, c := range merged {
+		if c.Old != c.New {
+			result = append(result, c)
+		}
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Seq < result[b].Seq })
+	return result
+}
+
+// RestoreSnapshot returns every tile & property to how it was at the named snapshot.
+// The restore is itself logged (tagged "restore <name>") so it can be undone.
+// Map level metadata (tile size, image layers, regions etc) isn't logged so
+// isn't restored. If history was disabled since the snapshot an error is returned.
+func (i *InfiniteMap) RestoreSnapshot(name string) error {
+	return i.restore(name, nil)
+}
+
+// RestoreRegion returns every tile in the rectangle (x0,y0,x1,y1) to how it
+// was at the named snapshot. Tiles outside the region & properties are left as they are.
+func (i *InfiniteMap) RestoreRegion(name string, x0, y0, x1, y1 int) error {
+	if x1 <= x0 || y1 <= y0 {
+		return fmt.Errorf("region dimensions invalid")
+	}
+	return i.restore(name, &[4]int{x0, y0, x1, y1})
+}
+
+// restore undoes all changes since the named snapshot, optionally only those
+// to tiles in the rectangle `region`
+func (i *InfiniteMap) restore(name string, region *[4]int) error {
+	if !i.HistoryEnabled() {
+		return fmt.Errorf("history is not enabled, unable to restore")
+	}
+
+	return i.Tagged("restore "+name, func(tx *InfiniteTx) error {
+		// diff from inside the transaction, so no write can land between
+		// reading the change log & undoing it
+		diff, err := snapshotDiff(tx.tx, name, "")
+		if err != nil {
+			return err
+		}
+
+		cells := []Cell{}
+		props := map[string]*Properties{}
+		for _, c := range diff {
+			if c.Kind == ChangeTile {
+				if region != nil && (c.X < region[0] || c.Y < region[1] || c.X >= region[2] || c.Y >= region[3]) {
+					continue
+				}
+				cells = append(cells, Cell{X: c.X, Y: c.Y, Z: c.Z, Src: c.Old})
+				continue
+			}
+
+			if region != nil {
+				continue
+			}
+			p := NewProperties()
+			if c.Old != "" {
+				p, err = decodeProperties([]byte(c.Old))
+				if err != nil {
+					return err
+				}
+			}
+			props[c.Src] = p
+		}
+
+		s := tx.writer()
+		err = s.SetTiles(cells...)
+		if err != nil {
+			return err
+		}
+		return s.SetProperties(props)
+	})
+}
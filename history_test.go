@@ -0,0 +1,137 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+
+			// nothing is logged until enabled
+			assert.Nil(t, inf.Set(0, 0, 0, "grass.png"))
+			assert.NotNil(t, inf.Snapshot("before"))
+			assert.Nil(t, inf.EnableHistory(true))
+			assert.True(t, NewInfiniteMapWithStorage(s).HistoryEnabled())
+
+			for x := 0; x < 4; x++ {
+				assert.Nil(t, inf.Set(x, 0, 0, "grass.png"))
+			}
+			props := NewProperties()
+			props.SetBool("soft", true)
+			assert.Nil(t, inf.SetProperties("grass.png", props))
+			assert.Nil(t, inf.Snapshot("meadow"))
+
+			// a generation pass wrecks things
+			err := inf.Tagged("erosion", func(tx *InfiniteTx) error {
+				for x := 0; x < 4; x++ {
+					err := tx.Set(x, 0, 0, "mud.png")
+					if err != nil {
+						return err
+					}
+				}
+				assert.Nil(t, tx.Set(0, 0, 0, "bog.png"))
+				assert.Nil(t, tx.Set(2, 2, 1, "puddle.png"))
+				return tx.SetProperties("grass.png", NewProperties())
+			})
+			assert.Nil(t, err)
+			assert.Nil(t, inf.Snapshot("muddy"))
+
+			all, err := inf.History("")
+			assert.Nil(t, err)
+			assert.Equal(t, 3+1+7, len(all)) // (0,0,0) was already grass
+			since, err := inf.History("meadow")
+			assert.Nil(t, err)
+			assert.Equal(t, 7, len(since))
+			assert.Equal(t, "erosion", since[0].Tag)
+			assert.True(t, since[0].Seq < since[1].Seq)
+
+			diff, err := inf.SnapshotDiff("meadow", "muddy")
+			assert.Nil(t, err)
+			assert.Equal(t, 6, len(diff)) // (0,0,0) is squashed into one change
+			for _, c := range diff {
+				if c.Kind == ChangeTile && c.X == 0 && c.Y == 0 {
+					assert.Equal(t, "grass.png", c.Old)
+					assert.Equal(t, "bog.png", c.New)
+				}
+			}
+
+			snaps, err := inf.Snapshots()
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(snaps))
+			assert.Equal(t, "meadow", snaps[0].Name)
+
+			// restore only part of the map
+			assert.Nil(t, inf.RestoreRegion("meadow", 0, 0, 2, 1))
+			src, _ := inf.At(0, 0, 0)
+			assert.Equal(t, "grass.png", src)
+			src, _ = inf.At(2, 0, 0)
+			assert.Equal(t, "mud.png", src)
+			p, _ := inf.Properties("grass.png")
+			soft, _ := p.Bool("soft")
+			assert.False(t, soft)
+
+			// restore everything
+			assert.Nil(t, inf.RestoreSnapshot("meadow"))
+			src, _ = inf.At(3, 0, 0)
+			assert.Equal(t, "grass.png", src)
+			src, _ = inf.At(2, 2, 1)
+			assert.Equal(t, "", src)
+			p, _ = inf.Properties("grass.png")
+			soft, _ = p.Bool("soft")
+			assert.True(t, soft)
+
+			diff, err = inf.SnapshotDiff("meadow", "")
+			assert.Nil(t, err)
+			assert.Equal(t, 0, len(diff))
+
+			// restores are logged, so can be undone too
+			assert.Nil(t, inf.RestoreSnapshot("muddy"))
+			src, _ = inf.At(0, 0, 0)
+			assert.Equal(t, "bog.png", src)
+
+			assert.Nil(t, inf.RemoveSnapshot("muddy"))
+			assert.NotNil(t, inf.RestoreSnapshot("muddy"))
+		})
+	}
+}
+
+func TestHistoryGap(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+			assert.Nil(t, inf.EnableHistory(true))
+			assert.Nil(t, inf.Set(0, 0, 0, "grass.png"))
+			assert.Nil(t, inf.Snapshot("before"))
+
+			// changes made while history is off aren't known
+			assert.Nil(t, inf.EnableHistory(false))
+			assert.Nil(t, inf.Set(1, 0, 0, "mud.png"))
+			assert.Nil(t, inf.EnableHistory(true))
+			assert.Nil(t, inf.Set(0, 0, 0, "bog.png"))
+			assert.Nil(t, inf.Snapshot("after"))
+
+			all, err := inf.History("")
+			assert.Nil(t, err)
+			assert.Equal(t, ChangeGap, all[1].Kind)
+
+			_, err = inf.SnapshotDiff("before", "")
+			assert.NotNil(t, err)
+			assert.NotNil(t, inf.RestoreSnapshot("before"))
+			assert.NotNil(t, inf.RestoreRegion("before", 0, 0, 1, 1))
+			src, _ := inf.At(0, 0, 0)
+			assert.Equal(t, "bog.png", src)
+
+			// snapshots after the gap are fine
+			assert.Nil(t, inf.Set(0, 0, 0, "sand.png"))
+			assert.Nil(t, inf.RestoreSnapshot("after"))
+			src, _ = inf.At(0, 0, 0)
+			assert.Equal(t, "bog.png", src)
+			src, _ = inf.At(1, 0, 0)
+			assert.Equal(t, "mud.png", src)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+/* file adds loading tile images relative to the map they're used in. */
+package tile
+
+import (
+	"fmt"
+	"image"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// Image returns the decoded image for the given tile src. Sources are
+// relative to the map's .tmx file if it was read with Open or OpenFS,
+// otherwise to the working directory. Images are cached, so each is only
+// read once.
+func (m *Map) Image(src string) (image.Image, error) {
+	return m.imageLoader().load(src)
+}
+
+// imageLoader returns the map's image loader
+func (m *Map) imageLoader() *imageLoader {
+	if m.images == nil {
+		// the map wasn't made by New or read from a file
+		return newImageLoader(osFS{}, ".")
+	}
+	return m.images
+}
+
+// imageLoader reads & decodes tile images, caching them by src.
+// It's safe for concurrent use.
+type imageLoader struct {
+	fsys fs.FS
+	dir  string // relative sources are relative to this dir of fsys
+
+	lock  sync.Mutex
+	cache map[string]image.Image
+}
+
+// newImageLoader returns a loader for images in `fsys` relative to `dir`
+// (slash separated, eg. "." or "maps/town")
+func newImageLoader(fsys fs.FS, dir string) *imageLoader {
+	return &imageLoader{fsys: fsys, dir: dir, cache: map[string]image.Image{}}
+}
+
+// load returns the decoded image for the given src
+func (l *imageLoader) load(src string) (image.Image, error) {
+	l.lock.Lock()
+	img, ok := l.cache[src]
+	l.lock.Unlock()
+	if ok {
+		return img, nil
+	}
+
+	name := filepath.ToSlash(src)
+	if !path.IsAbs(name) && !filepath.IsAbs(src) {
+		name = path.Join(l.dir, name)
+	}
+
+	f, err := l.fsys.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tile image %s: %w", src, err)
+	}
+	defer f.Close()
+
+	img, _, err = image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode tile image %s: %w", src, err)
+	}
+
+	l.lock.Lock()
+	l.cache[src] = img
+	l.lock.Unlock()
+	return img, nil
+}
+
+// osFS reads files from disk. Unlike os.DirFS any path the os understands
+// may be opened (eg. "../sets/plants.tsx" or "/abs/path.png"), as maps
+// often refer to files outside of their own directory.
+type osFS struct{}
+
+// Open implements fs.FS
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(filepath.FromSlash(name))
+}
@@ -0,0 +1,68 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"errors"
+	"image"
+	"image/color"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOpenFS(t *testing.T) {
+	green := color.RGBA{0, 255, 0, 255}
+	fsys := fstest.MapFS{
+		"maps/swamp.tmx":       &fstest.MapFile{Data: []byte(handmade)},
+		"maps/sets/plants.tsx": &fstest.MapFile{Data: []byte(handmadeTileset)},
+		"maps/sets/reed.png":   &fstest.MapFile{Data: solidPNG(32, 48, green)},
+		"maps/swamp.png":       &fstest.MapFile{Data: solidPNG(96, 64, green)},
+	}
+
+	m, err := OpenFS(fsys, "maps/swamp.tmx")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(m.Tilesets))
+
+	// sources are relative to the map
+	img, err := m.Image("sets/reed.png")
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 32, 48), img.Bounds())
+
+	// & cached
+	delete(fsys, "maps/sets/reed.png")
+	again, err := m.Clone().Image("sets/reed.png")
+	assert.Nil(t, err)
+	assert.Equal(t, img, again)
+
+	_, err = m.Image("sets/lily.png")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	out, err := m.Render(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, green, out.At(10, 10))
+
+	// the external tileset must be in fsys too
+	_, err = OpenFS(fstest.MapFS{"swamp.tmx": &fstest.MapFile{Data: []byte(handmade)}}, "swamp.tmx")
+	assert.NotNil(t, err)
+}
+
+func TestOpenImagesRelative(t *testing.T) {
+	m := openHandmade(t)
+
+	// openHandmade writes the map to a temp dir, not the working dir
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "grass.png"), solidPNG(32, 32, color.White), 0644))
+	_, err := m.Image("grass.png")
+	assert.NotNil(t, err)
+
+	fname := filepath.Join(dir, "swamp.tmx")
+	assert.Nil(t, m.WriteFile(fname))
+	m, err = Open(fname)
+	assert.Nil(t, err)
+	img, err := m.Image("grass.png")
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 32, 32), img.Bounds())
+}
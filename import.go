@@ -0,0 +1,266 @@
+/* file adds importing whole (eg. hand made) .tmx maps into an InfiniteMap. */
+package tile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sheetSep separates the image of a single image tileset & the id of a tile
+// in it, in the src the tile is imported as (eg. "terrain.png#12")
+const sheetSep = "#"
+
+// ImportConfig configures how Import writes a Map into an InfiniteMap
+type ImportConfig struct {
+	// LayerZ sets the z-level of tile layers by name.
+	// Layers not given here use their name if it's an int (as tobs do),
+	// otherwise their position among the map's tile layers (0 for the first).
+	LayerZ map[string]int
+
+	// Overwrite allows replacing tiles that are already set. If false Import
+	// fails without writing anything if any tile is already set.
+	Overwrite bool
+
+	// Metadata also writes map level data: tile size, orientation, map
+	// properties (merged with those already set) & image layers.
+	Metadata bool
+
+	// Region if set records the rectangle the map is imported to as a
+	// named region (see InfiniteMap.SetRegion).
+	Region string
+}
+
+// DefaultImportConfig returns a config that writes everything, overwriting
+// whatever is already there.
+func DefaultImportConfig() *ImportConfig {
+	return &ImportConfig{
+		LayerZ:    map[string]int{},
+		Overwrite: true,
+		Metadata:  true,
+	}
+}
+
+// Import writes all tile layers of `m` into the infinite map with it's top
+// left corner at (x,y) & each layer's z-level offset by z.
+// Unlike Add this works with any Tiled map (eg. with multiple tilesets or
+// layers not named after z-levels) & also writes map level metadata.
+// Tiles of single image tilesets are written as eg. "terrain.png#12" (see
+// sheetSrc) & flipped tiles as eg. "tree.png|flip=h".
+// Everything is written in a single transaction.
+func (i *InfiniteMap) Import(m *Map, x, y, z int, cfg *ImportConfig) error {
+	return i.Batch(func(tx *InfiniteTx) error {
+		return tx.Import(m, x, y, z, cfg)
+	})
+}
+
+// Import writes all tile layers of `m` into the infinite map (see InfiniteMap.Import).
+func (t *InfiniteTx) Import(m *Map, x, y, z int, cfg *ImportConfig) error {
+	return t.inf.importMap(t.writer(), m, x, y, z, cfg)
+}
+
+// importMap writes `m` into the given store
+func (i *InfiniteMap) importMap(s Store, m *Map, x, y, z int, cfg *ImportConfig) error {
+	if cfg == nil {
+		cfg = DefaultImportConfig()
+	}
+
+	cells, sheets, err := importCells(m, cfg)
+	if err != nil {
+		return err
+	}
+	for index := range cells {
+		cells[index].X += x
+		cells[index].Y += y
+		cells[index].Z += z
+	}
+
+	if !cfg.Overwrite && len(cells) > 0 {
+		existing, err := s.Tiles(x, y, x+m.Width, y+m.Height)
+		if err != nil {
+			return err
+		}
+		set := map[[3]int]bool{}
+		for _, c := range existing {
+			set[[3]int{c.X, c.Y, c.Z}] = true
+		}
+		for _, c := range cells {
+			if set[[3]int{c.X, c.Y, c.Z}] {
+				return fmt.Errorf("tile already set at (%d,%d,%d)", c.X, c.Y, c.Z)
+			}
+		}
+	}
+
+	if cfg.Metadata {
+		err = importMetadata(s, m, x, y)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.Region != "" {
+		err = setRegion(s, &Region{Name: cfg.Region, X0: x, Y0: y, X1: x + m.Width, Y1: y + m.Height})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(cells) == 0 {
+		return nil
+	}
+
+	for _, ts := range sheets {
+		err = setSheetTileset(s, ts)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.SetTiles(cells...)
+	if err != nil {
+		return err
+	}
+
+	srcs := []string{}
+	seen := map[string]bool{}
+	for _, c := range cells {
+		src, _ := splitFlips(c.Src)
+		if !seen[src] {
+			seen[src] = true
+			srcs = append(srcs, src)
+		}
+	}
+
+	existingProps, err := s.Properties(srcs...)
+	if err != nil {
+		return err
+	}
+
+	update := map[string]*Properties{}
+	for _, src := range srcs {
+		saved := existingProps[src]
+		if saved == nil {
+			saved = NewProperties()
+		}
+		mprops, _ := m.Properties(src)
+		if image, id, ok := splitSheetSrc(src); ok && sheets[image] != nil {
+			mprops = sheets[image].tileProperties(id)
+		}
+		update[src] = saved.Merge(mprops)
+	}
+
+	return s.SetProperties(update)
+}
+
+// importCells returns every set tile of `m` with z-levels decided by `cfg`,
+// & the single image tilesets used by image. Tiles of single image tilesets
+// are given a src from the tileset's image (see sheetSrc) & flipped tiles
+// have their flip flags written after their src (see flipSuffix).
+func importCells(m *Map, cfg *ImportConfig) ([]Cell, map[string]*Tileset, error) {
+	cells := []Cell{}
+	sheets := map[string]*Tileset{}
+	for position, tl := range m.TileLayers {
+		z, ok := cfg.LayerZ[tl.Name]
+		if !ok {
+			named, err := strconv.ParseInt(tl.Name, 10, 64)
+			if err == nil {
+				z = int(named)
+			} else {
+				z = position
+			}
+		}
+
+		for index, gid := tl.decodedTiles.next(0); index >= 0; index, gid = tl.decodedTiles.next(index + 1) {
+			src := ""
+			if tile := m.tileByGID(gid); tile != nil && tile.Image != nil && tile.Image.Source != "" {
+				src = tile.Image.Source
+			} else if ts, count := m.imageTileset(gid); ts != nil {
+				src = sheetSrc(ts, gid&^gidFlipFlags-ts.FirstGID)
+				if sheets[ts.Image.Source] == nil {
+					cp := cloneTileset(ts)
+					cp.FirstGID = 0
+					cp.TileCount = count
+					cp.index()
+					sheets[ts.Image.Source] = cp
+				}
+			} else {
+				return nil, nil, fmt.Errorf("layer %s: tile %d not found in any tileset", tl.Name, gid)
+			}
+
+			// the reverse of index = y * width + x
+			cells = append(cells, Cell{X: index % m.Width, Y: index / m.Width, Z: z, Src: src + flipSuffix(gid)})
+		}
+	}
+	return cells, sheets, nil
+}
+
+// sheetSrc returns the src tile `id` of the single image tileset `ts` is
+// imported as, which an InfiniteMap resolves back to the tileset
+func sheetSrc(ts *Tileset, id uint) string {
+	return ts.Image.Source + sheetSep + strconv.FormatUint(uint64(id), 10)
+}
+
+// splitSheetSrc returns the tileset image & tile id of a src written by sheetSrc
+func splitSheetSrc(src string) (string, uint, bool) {
+	i := strings.LastIndex(src, sheetSep)
+	if i < 0 {
+		return "", 0, false
+	}
+	id, err := strconv.ParseUint(src[i+len(sheetSep):], 10, 32)
+	if err != nil {
+		return "", 0, false
+	}
+	return src[:i], uint(id), true
+}
+
+// importMetadata writes map level data from `m` into the given store
+func importMetadata(s Store, m *Map, x, y int) error {
+	saved, err := s.Metadata("map.")
+	if err != nil {
+		return err
+	}
+
+	update := map[string]string{}
+	for key, value := range map[string]string{
+		metaTileWidth:   strconv.Itoa(m.TileWidth),
+		metaTileHeight:  strconv.Itoa(m.TileHeight),
+		metaOrientation: m.Orientation,
+	} {
+		was, ok := saved[key]
+		if !ok {
+			update[key] = value
+		} else if was != value {
+			return fmt.Errorf("map %s is %s but the infinite map is %s", key, value, was)
+		}
+	}
+
+	props, err := mapProperties(s)
+	if err != nil {
+		return err
+	}
+	update[metaProperties] = string(encodeProperties(props.Merge(m.MapProperties())))
+
+	err = s.SetMetadata(update)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range m.ImageLayers {
+		if l.Image == nil || l.Image.Source == "" {
+			continue
+		}
+		err = setImageLayer(s, &InfiniteImageLayer{
+			Name:   l.Name,
+			Source: l.Image.Source,
+			Width:  l.Image.Width,
+			Height: l.Image.Height,
+			X:      x + l.OffsetX/m.TileWidth,
+			Y:      y + l.OffsetY/m.TileHeight,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
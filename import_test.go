@@ -0,0 +1,211 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// handmade is a map as Tiled might write it; two tilesets (one external),
+// layers with names rather than z-levels, map properties & a background
+var handmade = `<?xml version="1.0" encoding="UTF-8"?>
+<map version="1.5" tiledversion="1.7.2" orientation="orthogonal" renderorder="right-down" width="3" height="2" tilewidth="32" tileheight="32" infinite="0">
+ <properties>
+  <property name="biome" value="swamp"/>
+ </properties>
+ <tileset firstgid="1" name="ground" tilewidth="32" tileheight="32" tilecount="2" columns="0">
+  <tile id="0">
+   <image width="32" height="32" source="grass.png"/>
+  </tile>
+  <tile id="1">
+   <properties>
+    <property name="wet" type="bool" value="true"/>
+   </properties>
+   <image width="32" height="32" source="water.png"/>
+  </tile>
+ </tileset>
+ <tileset firstgid="3" source="sets/plants.tsx"/>
+ <imagelayer id="3" name="background">
+  <image source="swamp.png" width="96" height="64"/>
+ </imagelayer>
+ <layer id="1" name="Ground" width="3" height="2">
+  <data encoding="csv">
+1,1,2,
+1,2,2
+</data>
+ </layer>
+ <layer id="2" name="Plants" width="3" height="2">
+  <data encoding="csv">
+0,4,0,
+3,0,2147483651
+</data>
+ </layer>
+</map>`
+
+var handmadeTileset = `<?xml version="1.0" encoding="UTF-8"?>
+<tileset version="1.5" name="plants" tilewidth="32" tileheight="32" tilecount="2" columns="0">
+ <tile id="0">
+  <image width="32" height="32" source="reed.png"/>
+ </tile>
+ <tile id="1">
+  <image width="32" height="32" source="lily.png"/>
+ </tile>
+</tileset>`
+
+// openHandmade writes the handmade map & it's tileset to disk & opens it
+func openHandmade(t *testing.T) *Map {
+	dir := t.TempDir()
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "sets"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "sets", "plants.tsx"), []byte(handmadeTileset), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "swamp.tmx"), []byte(handmade), 0644))
+
+	m, err := Open(filepath.Join(dir, "swamp.tmx"))
+	assert.Nil(t, err)
+	return m
+}
+
+func TestOpenMultipleTilesets(t *testing.T) {
+	m := openHandmade(t)
+
+	assert.Equal(t, 2, len(m.Tilesets))
+
+	// z-levels are only layers named after ints, so we read via gids
+	assert.Equal(t, "water.png", m.tileByGID(m.TileLayers[0].decodedTiles.get(2)).Image.Source)
+	assert.Equal(t, "sets/lily.png", m.tileByGID(m.TileLayers[1].decodedTiles.get(1)).Image.Source)
+
+	// flipped tiles are still found
+	assert.Equal(t, "sets/reed.png", m.tileByGID(m.TileLayers[1].decodedTiles.get(5)).Image.Source)
+
+	// new tiles don't collide with existing gids
+	m.Set(0, 0, 0, "mushroom.png")
+	_, gid := m.tileBySrc("mushroom.png")
+	assert.Equal(t, uint(5), gid)
+
+	_, err := Decode(bytes.NewBuffer([]byte(handmade)))
+	assert.NotNil(t, err)
+}
+
+func TestImport(t *testing.T) {
+	m := openHandmade(t)
+
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+			assert.Nil(t, inf.Set(11, 20, 5, "rock.png"))
+
+			cfg := DefaultImportConfig()
+			cfg.LayerZ["Plants"] = 3
+			cfg.Region = "swamp"
+			assert.Nil(t, inf.Import(m, 10, 20, 2, cfg))
+
+			r, err := inf.Region("swamp")
+			assert.Nil(t, err)
+			assert.Equal(t, &Region{Name: "swamp", X0: 10, Y0: 20, X1: 13, Y1: 22}, r)
+
+			src, _ := inf.At(12, 20, 2)
+			assert.Equal(t, "water.png", src)
+			src, _ = inf.At(11, 20, 5)
+			assert.Equal(t, "sets/lily.png", src)
+			src, _ = inf.At(12, 21, 5)
+			assert.Equal(t, "sets/reed.png|flip=h", src) // flipped tiles keep their flags
+
+			props, _ := inf.Properties("water.png")
+			wet, _ := props.Bool("wet")
+			assert.True(t, wet)
+
+			meta, err := s.Metadata("")
+			assert.Nil(t, err)
+			assert.Equal(t, "32", meta[metaTileWidth])
+			assert.Contains(t, meta[metaProperties], "swamp")
+			assert.Contains(t, meta[metaImageLayer+"background"], "swamp.png")
+
+			// without overwrite nothing is written if anything is in the way
+			cfg.Overwrite = false
+			assert.NotNil(t, inf.Import(m, 10, 20, 2, cfg))
+			assert.Nil(t, inf.Import(m, 10, 20, 10, cfg))
+
+			// tile size must match
+			small := New(&Config{TileWidth: 16, TileHeight: 16, MapWidth: 1, MapHeight: 1})
+			small.Set(0, 0, 0, "grass.png")
+			assert.NotNil(t, inf.Import(small, 0, 0, 0, nil))
+			src, _ = inf.At(0, 0, 0)
+			assert.Equal(t, "", src)
+		})
+	}
+}
+
+func TestImportImageTileset(t *testing.T) {
+	m, err := Decode(strings.NewReader(imageTilesetMap))
+	assert.Nil(t, err)
+
+	// terrain.png is 4 tiles: red, green, blue & white
+	sheet := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i, c := range []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 255, 255}} {
+		draw.Draw(sheet, image.Rect(i%2*32, i/2*32, i%2*32+32, i/2*32+32), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	buf := &bytes.Buffer{}
+	assert.Nil(t, png.Encode(buf, sheet))
+	fsys := fstest.MapFS{
+		"terrain.png": &fstest.MapFile{Data: buf.Bytes()},
+		"grass.png":   &fstest.MapFile{Data: solidPNG(32, 32, color.Black)},
+	}
+
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+			assert.Nil(t, inf.Import(m, 0, 0, 0, nil))
+
+			// tiles of the tileset are given a src from it's image
+			src, _ := inf.At(1, 0, 0)
+			assert.Equal(t, "terrain.png#0", src)
+			src, _ = inf.At(1, 1, 0)
+			assert.Equal(t, "terrain.png#1|flip=h", src)
+			src, _ = inf.At(0, 1, 0)
+			assert.Equal(t, "terrain.png#3", src)
+
+			// which resolve back to the tileset
+			out, err := inf.Map(0, 0, 4, 2)
+			assert.Nil(t, err)
+			var terrain *Tileset
+			for _, ts := range out.Tilesets {
+				if ts.Name == "terrain" {
+					terrain = ts
+				}
+			}
+			assert.NotNil(t, terrain)
+			assert.Equal(t, 4, terrain.TileCount)
+			tiles := out.TileLayers[0].decodedTiles
+			assert.Equal(t, terrain.FirstGID, tiles.get(1))
+			assert.Equal(t, terrain.FirstGID+1|0x80000000, tiles.get(5))
+			assert.Nil(t, out.validate())
+
+			// & are drawn from it
+			cfg := DefaultRenderConfig()
+			cfg.FS = fsys
+			img, err := out.Render(cfg)
+			assert.Nil(t, err)
+			for _, expect := range []struct {
+				x, y int
+				c    color.RGBA
+			}{
+				{0, 0, color.RGBA{0, 0, 0, 255}},
+				{1, 0, color.RGBA{255, 0, 0, 255}},
+				{2, 0, color.RGBA{0, 255, 0, 255}},
+				{3, 0, color.RGBA{0, 0, 255, 255}},
+				{0, 1, color.RGBA{255, 255, 255, 255}},
+			} {
+				assert.Equal(t, expect.c, color.RGBAModel.Convert(img.At(expect.x*32+16, expect.y*32+16)), expect)
+			}
+		})
+	}
+}
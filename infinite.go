@@ -1,48 +1,71 @@
 package tile
 
 import (
-	"encoding/json"
 	"fmt"
-	"math/rand"
 	"os"
-	"path/filepath"
+	"sort"
 	"strconv"
-	"strings"
-	"time"
-
-	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
-)
-
-const (
-	sqlUpdateTiles = `INSERT INTO tiles (id, x, y, z, src) VALUES (:id, :x, :y, :z, :src) ON CONFLICT (id) DO UPDATE SET src=EXCLUDED.src;`
-	sqlGetProps    = `SELECT src,data FROM properties WHERE `
-	sqlUpdateProps = `INSERT INTO properties (src, data) VALUES (:src, :data) ON CONFLICT (src) DO UPDATE SET data=EXCLUDED.data;`
+	"sync"
 )
 
-// namedQuery allows us to use either a transaction.NamedQuery or DB.NamedQuery
-// in our sub functions.
-// Tl;dr it's helpful for using the same code in & out of transactions.
-type namedQuery func(string, interface{}) (*sqlx.Rows, error)
-
 // NewInfiniteMap creates an 'infinite' version of a 'tileable' map.
-// This creates a random name for the database & stores it in the os tempdir.
+// This creates a new uniquely named database in the os tempdir, use
+// Destroy to remove it when done.
 func NewInfiniteMap() (*InfiniteMap, error) {
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	fname := filepath.Join(os.TempDir(), fmt.Sprintf("infmap.%d.sqlite", rng.Intn(1000000)))
-	return OpenInfiniteMap(fname)
+	return CreateTempInfiniteMap("", "infmap.*.sqlite")
 }
 
-// OpenInfiniteMap given it's filename (database file) on disk.
+// CreateTempInfiniteMap creates a new infinite map (sqlite database file) in `dir`
+// (or the os tempdir if "") with a unique name from `pattern` (see os.CreateTemp).
+// The file is always new, so we never open another map by mistake.
+func CreateTempInfiniteMap(dir, pattern string) (*InfiniteMap, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	fname := f.Name()
+	err = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := OpenSQLiteStorage(fname)
+	if err != nil {
+		os.Remove(fname)
+		return nil, err
+	}
+	return NewInfiniteMapWithStorage(s), nil
+}
+
+// OpenInfiniteMap given it's filename (sqlite database file) on disk.
 // Will create if it doesn't exist.
 func OpenInfiniteMap(fname string) (*InfiniteMap, error) {
-	db, err := sqlx.Open("sqlite3", fname)
+	return OpenInfiniteMapWithConfig(fname, nil)
+}
+
+// OpenInfiniteMapWithConfig opens the sqlite database file with the given
+// settings (eg. read only, must exist).
+func OpenInfiniteMapWithConfig(fname string, cfg *OpenConfig) (*InfiniteMap, error) {
+	s, err := OpenSQLiteStorageWithConfig(fname, cfg)
 	if err != nil {
 		return nil, err
 	}
+	return NewInfiniteMapWithStorage(s), nil
+}
 
-	inf := &InfiniteMap{db: db, filename: fname}
-	return inf, inf.init()
+// NewInfiniteMapWithStorage returns an infinite map that keeps it's data
+// in the given storage.
+func NewInfiniteMapWithStorage(s Storage) *InfiniteMap {
+	inf := &InfiniteMap{storage: s, events: &notifier{}}
+	named, ok := s.(interface{ Filename() string })
+	if ok {
+		inf.filename = named.Filename()
+	}
+	meta, err := s.Metadata(metaHistory)
+	if err == nil {
+		inf.history = meta[metaHistory] != ""
+	}
+	return inf
 }
 
 // InfiniteMap holds all the same data as a 'Map' (an in memory .TMX map)
@@ -51,18 +74,81 @@ func OpenInfiniteMap(fname string) (*InfiniteMap, error) {
 //
 // We can then use this to write out any number of .tmx maps of practical
 // sizes for use in other systems.
+//
+// An InfiniteMap is safe to use from many goroutines at once. Reads may run
+// together while writes (& transactions) are made one at a time.
 type InfiniteMap struct {
 	filename string
-	db       *sqlx.DB
+	storage  Storage
+	events   *notifier
+
+	lock    sync.RWMutex // guards history
+	history bool         // log changes (see EnableHistory)
 }
 
 // Filename returns the path to the infinite map data on disk
+// (or "" if the storage isn't a file).
 func (i *InfiniteMap) Filename() string {
 	return i.filename
 }
 
+// Storage returns where the infinite map keeps it's data
+func (i *InfiniteMap) Storage() Storage {
+	return i.storage
+}
+
+// Close releases the storage (open files etc). The map can't be used after.
+func (i *InfiniteMap) Close() error {
+	return i.storage.Close()
+}
+
+// Destroy closes the map & deletes it's database file(s) from disk.
+func (i *InfiniteMap) Destroy() error {
+	err := i.Close()
+	if err != nil {
+		return err
+	}
+	if i.filename == "" {
+		return nil
+	}
+
+	err = os.Remove(i.filename)
+	if err != nil {
+		return err
+	}
+
+	// files sqlite may leave alongside the database
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		err = os.Remove(i.filename + suffix)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 // Map returns a (Tile)Map with all tiles from the infinite map in the rectangle (x0,y0,x1,y1).
-func (i *InfiniteMap) Map(tilewidth, tileheight uint, x0, y0, x1, y1 int) (*Map, error) {
+// The map is configured with the infinite map's tile size, orientation, map properties
+// & any image layers that overlap the rectangle.
+func (i *InfiniteMap) Map(x0, y0, x1, y1 int) (*Map, error) {
+	return i.MapWithTileSize(0, 0, x0, y0, x1, y1)
+}
+
+// MapWithTileSize is Map but with the given tile size (in pixels) rather than the
+// infinite map's. If either is 0 the infinite map's tile size is used.
+func (i *InfiniteMap) MapWithTileSize(tilewidth, tileheight uint, x0, y0, x1, y1 int) (*Map, error) {
+	if tilewidth == 0 || tileheight == 0 {
+		var err error
+		tilewidth, tileheight, err = i.TileSize()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return i.mapWithSize(tilewidth, tileheight, x0, y0, x1, y1)
+}
+
+// mapWithSize is Map but with the given tile size (in pixels)
+func (i *InfiniteMap) mapWithSize(tilewidth, tileheight uint, x0, y0, x1, y1 int) (*Map, error) {
 	if x1 <= x0 || y1 <= y0 {
 		return nil, fmt.Errorf("requested map dimensions invalid, unable to render map")
 	}
@@ -78,89 +164,171 @@ func (i *InfiniteMap) Map(tilewidth, tileheight uint, x0, y0, x1, y1 int) (*Map,
 		TileLayers:     []*TileLayer{},
 		ImageLayers:    []*ImageLayer{},
 		nextID:         1,
+		images:         newImageLoader(osFS{}, "."),
 	}
 
-	rows, err := i.db.NamedQuery(
-		"SELECT x,y,z,src FROM tiles WHERE x>=:x0 AND x<:x1 AND y>=:y0 AND y<:y1;",
-		map[string]interface{}{
-			"x0": x0, "x1": x1,
-			"y0": y0, "y1": y1,
-		},
-	)
+	tiles, err := i.tiles(x0, y0, x1, y1)
+	if err != nil {
+		return nil, err
+	}
+
+	// not all stores return tiles in order, sort them so tiles are given
+	// the same ids (& so the map is encoded the same) every time
+	sort.Slice(tiles, func(a, b int) bool {
+		if tiles[a].X != tiles[b].X {
+			return tiles[a].X < tiles[b].X
+		}
+		if tiles[a].Y != tiles[b].Y {
+			return tiles[a].Y < tiles[b].Y
+		}
+		return tiles[a].Z < tiles[b].Z
+	})
+
+	sheets, err := sheetTilesets(i.storage)
 	if err != nil {
 		return nil, err
 	}
 
 	srcs := []string{}
-	tile := dbTile{}
-	for rows.Next() {
-		rows.StructScan(&tile)
-		srcs = append(srcs, tile.Src)
-		tmap.Set(tile.X, tile.Y, tile.Z, tile.Src)
+	seen := map[string]bool{}
+	for _, tile := range tiles {
+		src, flags := splitFlips(tile.Src)
+		if !seen[src] {
+			seen[src] = true
+			srcs = append(srcs, src)
+		}
+		tmap.setTile(tile.X-x0, tile.Y-y0, tile.Z, src, flags, sheets)
 	}
 
-	srcProps, err := i.properties(i.db.NamedQuery, srcs...)
+	srcProps, err := i.storage.Properties(srcs...)
 	if err != nil {
 		return nil, err
 	}
 
 	for src, props := range srcProps {
+		if image, id, ok := splitSheetSrc(src); ok && sheets[image] != nil {
+			tmap.sheetTileset(sheets[image], sheets[image].TileCount).setTileProperties(id, props)
+			continue
+		}
 		tmap.SetProperties(src, props)
 	}
 
-	return tmap, nil
+	return tmap, i.configure(tmap, x0, y0, x1, y1)
 }
 
-// At returns the tile that exists at the given location (or "" if unset)
-func (i *InfiniteMap) At(x, y, z int) (string, error) {
-	rows, err := i.db.NamedQuery(
-		"SELECT x,y,z,src FROM tiles WHERE x=:x0 AND y=:y0 AND z=:z0 LIMIT 1;",
-		map[string]interface{}{
-			"x0": x,
-			"y0": y,
-			"z0": z,
-		},
-	)
-	if err != nil {
-		return "", err
+// setTile sets the tile at (x,y,z) of a map taken from an infinite map to
+// `src` with the given flip flags. Srcs of tiles imported from single image
+// tilesets (see sheetSrc) are set as tiles of a copy of the tileset.
+func (m *Map) setTile(x, y, z int, src string, flags uint, sheets map[string]*Tileset) {
+	l := m.layer(z)
+	if l == nil {
+		l = m.newTilelayer(strconv.Itoa(z))
 	}
 
-	tile := dbTile{}
-	for rows.Next() { // there's at most one due to LIMIT 1
-		rows.StructScan(&tile)
+	gid := uint(0)
+	if image, id, ok := splitSheetSrc(src); ok && sheets[image] != nil {
+		gid = m.sheetTileset(sheets[image], sheets[image].TileCount).FirstGID + id
+	} else {
+		var t *Tile
+		t, gid = m.tileBySrc(src)
+		if t == nil {
+			_, gid = m.newTile(src)
+		}
+	}
+	l.decodedTiles.set(y*m.Width+x, gid|flags)
+}
+
+// tiles returns all tiles in the rectangle (x0,y0,x1,y1) ordered by (x,y,z)
+func (i *InfiniteMap) tiles(x0, y0, x1, y1 int) ([]Cell, error) {
+	return i.storage.Tiles(x0, y0, x1, y1)
+}
+
+// extent returns the smallest & largest (x,y) of any set tile.
+// If no tiles are set `ok` is false.
+func (i *InfiniteMap) extent() (x0, y0, x1, y1 int, ok bool, err error) {
+	b, err := i.Bounds()
+	if err != nil || b == nil {
+		return 0, 0, 0, 0, false, err
 	}
+	return b.MinX, b.MinY, b.MaxX, b.MaxY, true, nil
+}
+
+// Bounds returns the smallest box that includes every set tile.
+// If no tiles are set nil is returned.
+func (i *InfiniteMap) Bounds() (*Bounds, error) {
+	return i.storage.Bounds()
+}
+
+// ZLevels returns all z-levels with at least one tile set, sorted low -> high.
+func (i *InfiniteMap) ZLevels() ([]int, error) {
+	return i.storage.ZLevels()
+}
+
+// At returns the tile that exists at the given location (or "" if unset)
+func (i *InfiniteMap) At(x, y, z int) (string, error) {
+	return i.at(i.storage, x, y, z)
+}
 
-	return tile.Src, nil
+// at returns the tile at the given location using the given store
+func (i *InfiniteMap) at(s Store, x, y, z int) (string, error) {
+	return s.Tile(x, y, z)
 }
 
-// Set the given image src at (x,y,z)
+// Set the given image src at (x,y,z).
+// If "" is passed for src the tile is removed.
 func (i *InfiniteMap) Set(x, y, z int, src string) error {
-	_, err := i.db.NamedExec(sqlUpdateTiles, newDBTile(x, y, z, src))
-	return err
+	return i.write(func(s Store) error {
+		return i.set(s, x, y, z, src)
+	})
+}
+
+// set the given src at (x,y,z) using the given store
+func (i *InfiniteMap) set(s Store, x, y, z int, src string) error {
+	return s.SetTiles(Cell{X: x, Y: y, Z: z, Src: src})
+}
+
+// Remove the tile at (x,y,z) (if any)
+func (i *InfiniteMap) Remove(x, y, z int) error {
+	return i.write(func(s Store) error {
+		return i.remove(s, x, y, z)
+	})
+}
+
+// remove the tile at (x,y,z) using the given store
+func (i *InfiniteMap) remove(s Store, x, y, z int) error {
+	return s.SetTiles(Cell{X: x, Y: y, Z: z})
 }
 
-// Add the given tile object map `0` beginning at (x,y,z)
+// Add the given tile object map `o` beginning at (x,y,z).
+// Tiles & their properties are written in a single transaction.
+// A negative zoffset places `o` on top of whatever is at (x,y) (see Map.Add).
 func (i *InfiniteMap) Add(x, y, zoffset int, o *Map) error {
-	updateTiles := []dbTile{}
+	return i.Batch(func(tx *InfiniteTx) error {
+		return tx.Add(x, y, zoffset, o)
+	})
+}
+
+// add the given object map `o` beginning at (x,y,z) using the given store
+func (i *InfiniteMap) add(s Store, x, y, zoffset int, o *Map) error {
+	zoffset, err := i.stackZ(s, x, y, zoffset)
+	if err != nil {
+		return err
+	}
+
+	updateTiles := []Cell{}
 
 	srcsToUpdate := []string{}
 	propsCurrent := map[string]*Properties{}
 
-	ts := o.Tilesets[0]
 	for _, tl := range o.TileLayers {
 		z, err := strconv.ParseInt(tl.Name, 10, 64)
 		if err != nil {
 			continue
 		}
 
-		for index, tid := range tl.decodedTiles {
-			if tid == 0 {
-				// skip nil tile
-				continue
-			}
-
-			tile, ok := ts.tileByID[tid]
-			if !ok {
+		for index, tid := tl.decodedTiles.next(0); index >= 0; index, tid = tl.decodedTiles.next(index + 1) {
+			tile := o.tileByGID(tid)
+			if tile == nil || tile.Image == nil {
 				// implies we have a tile with no tileset entry ??
 				continue
 			}
@@ -170,144 +338,125 @@ func (i *InfiniteMap) Add(x, y, zoffset int, o *Map) error {
 
 			src := tile.Image.Source
 
-			updateTiles = append(updateTiles, newDBTile(tx+x, ty+y, int(z)+zoffset, src))
-			oprops, _ := o.Properties(src)
-			propsCurrent[src] = oprops
-			srcsToUpdate = append(srcsToUpdate, src)
+			updateTiles = append(updateTiles, Cell{X: tx + x, Y: ty + y, Z: int(z) + zoffset, Src: src})
+			_, seen := propsCurrent[src]
+			if !seen {
+				oprops, _ := o.Properties(src)
+				propsCurrent[src] = oprops
+				srcsToUpdate = append(srcsToUpdate, src)
+			}
 		}
 	}
 
-	// insert tiles
-	_, err := i.db.NamedExec(sqlUpdateTiles, updateTiles)
-	if err != nil {
-		return err
+	if len(updateTiles) == 0 {
+		return nil
 	}
 
-	// update properties in a transaction
-	txn, err := i.db.Beginx()
+	// insert tiles
+	err = s.SetTiles(updateTiles...)
 	if err != nil {
 		return err
 	}
 
-	existingProps, err := i.properties(txn.NamedQuery, srcsToUpdate...)
+	existingProps, err := s.Properties(srcsToUpdate...)
 	if err != nil {
-		txn.Rollback()
 		return err
 	}
 
-	propStructs := []dbProp{}
 	for src, now := range propsCurrent {
 		saved, _ := existingProps[src]
 		if saved == nil {
 			saved = NewProperties()
 		}
-
-		propStructs = append(propStructs, newDBProp(src, saved.Merge(now)))
+		propsCurrent[src] = saved.Merge(now)
 	}
 
-	_, err = txn.NamedExec(sqlUpdateProps, propStructs)
-	if err != nil {
-		txn.Rollback()
-		return err
-	}
-
-	return txn.Commit()
+	return s.SetProperties(propsCurrent)
 }
 
 // Fits returns if writing the given tilemap `o` starting at (x,y,z) would require
 // overwriting an already set tile.
-// Nb. we do not check nil (empty) tiles in the given object but rather if there
-// are tiles set in the rectangle described starting from (x,y,z) and adding
-// the object width, height and it's highest z-layer.
-func (i *InfiniteMap) Fits(x, y, z int, o *Map) (bool, error) {
-	highest := 0
-	lvls := o.ZLevels()
-	if len(lvls) > 0 {
-		highest = lvls[len(lvls)-1]
-	}
-
-	rows, err := i.db.NamedQuery(
-		"SELECT count(*) as num FROM tiles WHERE x>=:x0 AND x<:x1 AND y>=:y0 AND y<:y1 AND z>=:z0 AND z<:z1;",
-		map[string]interface{}{
-			"x0": x, "x1": x + o.Width,
-			"y0": y, "y1": y + o.Height,
-			"z0": z, "z1": z + highest + 1, // since `highest` is the z-layer (eg, 0 means "the first layer")
-		},
-	)
+// Only the non nil tiles of `o` are checked (at their z-level + z), so objects
+// with empty cells can be placed with their bounding boxes overlapping.
+// A negative zoffset is handled as in Add.
+func (i *InfiniteMap) Fits(x, y, zoffset int, o *Map) (bool, error) {
+	return i.fits(i.storage, x, y, zoffset, o)
+}
+
+// fits checks if `o` fits at (x,y,z) using the given store
+func (i *InfiniteMap) fits(s Store, x, y, zoffset int, o *Map) (bool, error) {
+	zoffset, err := i.stackZ(s, x, y, zoffset)
 	if err != nil {
 		return false, err
 	}
 
-	var num int64
-	for rows.Next() { // should only be one row
-		rows.Scan(&num)
+	tiles, err := s.Tiles(x, y, x+o.Width, y+o.Height)
+	if err != nil {
+		return false, err
 	}
-
-	return num == 0, nil
-}
-
-// properties returns set properties by their src name
-func (i *InfiniteMap) properties(do namedQuery, in ...string) (map[string]*Properties, error) {
-	args := map[string]interface{}{}
-	or := []string{}
-
-	for i, src := range in {
-		name := fmt.Sprintf("prop_%d", i)
-
-		args[name] = src
-		or = append(or, fmt.Sprintf("src=:%s", name))
+	if len(tiles) == 0 {
+		return true, nil
 	}
 
-	qstr := fmt.Sprintf("%s %s LIMIT %d;", sqlGetProps, strings.Join(or, " OR "), len(in))
-
-	rows, err := do(qstr, args)
-	if err != nil {
-		return nil, err
+	set := map[[3]int]bool{}
+	for _, t := range tiles {
+		set[[3]int{t.X, t.Y, t.Z}] = true
 	}
 
-	result := map[string]*Properties{}
-
-	r := dbProp{}
-	dblock := struct {
-		I map[string]int
-		S map[string]string
-		B map[string]bool
-	}{}
-	for rows.Next() {
-		err = rows.StructScan(&r)
+	for _, tl := range o.TileLayers {
+		z, err := strconv.ParseInt(tl.Name, 10, 64)
 		if err != nil {
-			return nil, err
+			continue
 		}
 
-		// explicit reset to results don't bleed together
-		dblock.I = nil
-		dblock.S = nil
-		dblock.B = nil
+		for index, _ := tl.decodedTiles.next(0); index >= 0; index, _ = tl.decodedTiles.next(index + 1) {
+			// the reverse of index = y * width + x
+			tx := index % o.Width
+			ty := index / o.Width
 
-		err = json.Unmarshal([]byte(r.Data), &dblock)
-		if err != nil {
-			return nil, err
+			if set[[3]int{tx + x, ty + y, int(z) + zoffset}] {
+				return false, nil
+			}
 		}
+	}
 
-		result[r.Src] = &Properties{
-			ints:    dblock.I,
-			strings: dblock.S,
-			bools:   dblock.B,
-		}
+	return true, nil
+}
+
+// stackZ returns the zoffset to use when adding an object at (x,y).
+// As with Map.Add a negative zoffset means "on top of" the highest tile
+// set at (x,y), or 0 if there isn't one.
+func (i *InfiniteMap) stackZ(s Store, x, y, zoffset int) (int, error) {
+	if zoffset >= 0 {
+		return zoffset, nil
 	}
 
-	return result, nil
+	column, err := s.Tiles(x, y, x+1, y+1)
+	if err != nil {
+		return 0, err
+	}
+
+	// tiles are ordered by z, so the last is the highest
+	if len(column) == 0 || column[len(column)-1].Z < 0 {
+		return 0, nil
+	}
+	return column[len(column)-1].Z, nil
 }
 
 // Properties returns properties for a given src
 // Asking for "" (the empty tile) always returns nil
 // Otherwise if no properties are set an empty properties will be returned.
 func (i *InfiniteMap) Properties(src string) (*Properties, error) {
+	return i.srcProperties(i.storage, src)
+}
+
+// srcProperties returns properties for a given src using the given store
+func (i *InfiniteMap) srcProperties(s Store, src string) (*Properties, error) {
 	if src == "" {
 		return nil, nil
 	}
 
-	result, err := i.properties(i.db.NamedQuery, src)
+	result, err := s.Properties(src)
 	if err != nil {
 		return nil, err
 	}
@@ -322,69 +471,12 @@ func (i *InfiniteMap) Properties(src string) (*Properties, error) {
 
 // SetProperties for the given src. This doesn't do an update / merge just overwrites.
 func (i *InfiniteMap) SetProperties(src string, props *Properties) error {
-	_, err := i.db.NamedExec(sqlUpdateProps, newDBProp(src, props))
-	return err
-}
-
-// init creates some DB tables for us if they don't exist
-func (i *InfiniteMap) init() error {
-	createTiles := `CREATE TABLE IF NOT EXISTS tiles(
-		id TEXT PRIMARY KEY,
-		x INTEGER NOT NULL,
-		y INTEGER NOT NULL,
-		z INTEGER NOT NULL,
-		src TEXT NOT NULL
-	    );`
-	_, err := i.db.Exec(createTiles)
-	if err != nil {
-		return err
-	}
-
-	createProps := `CREATE TABLE IF NOT EXISTS properties(
-		src TEXT PRIMARY KEY,
-		data TEXT
-	    );`
-
-	_, err = i.db.Exec(createProps)
-	return err
+	return i.write(func(s Store) error {
+		return i.setProperties(s, src, props)
+	})
 }
 
-// dbTile object encodes a single tile.
-// The ID here is used to insert/update on a unique tile by it's (x,y,z)
-// with a more straight forward query.
-type dbTile struct {
-	ID  string `db:"id"`
-	X   int    `db:"x"`
-	Y   int    `db:"y"`
-	Z   int    `db:"z"`
-	Src string `db:"src"`
-}
-
-// newDBTile crafts a dbTile struct given it's inputs
-func newDBTile(x, y, z int, src string) dbTile {
-	return dbTile{ID: fmt.Sprintf("%d-%d-%d", x, y, z), X: x, Y: y, Z: z, Src: src}
-}
-
-// dbProp object encodes properties for a single src.
-type dbProp struct {
-	Src  string `db:"src"`
-	Data string `db:"data"`
-}
-
-// newDBProp crats a dbProp struct given it's inputs.
-// Properties are encoded into JSON.
-func newDBProp(src string, props *Properties) dbProp {
-	dblock := struct {
-		I map[string]int
-		S map[string]string
-		B map[string]bool
-	}{
-		props.ints,
-		props.strings,
-		props.bools,
-	}
-
-	databytes, _ := json.Marshal(dblock)
-
-	return dbProp{Src: src, Data: string(databytes)}
+// setProperties for the given src using the given store
+func (i *InfiniteMap) setProperties(s Store, src string, props *Properties) error {
+	return s.SetProperties(map[string]*Properties{src: props})
 }
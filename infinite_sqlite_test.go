@@ -0,0 +1,40 @@
+//go:build cgo
+// +build cgo
+
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"os"
+	"testing"
+)
+
+func TestInfiniteMapLifecycle(t *testing.T) {
+	dir := t.TempDir()
+
+	one, err := CreateTempInfiniteMap(dir, "map.*.sqlite")
+	require.NoError(t, err)
+	two, err := CreateTempInfiniteMap(dir, "map.*.sqlite")
+	require.NoError(t, err)
+	assert.NotEqual(t, one.Filename(), two.Filename())
+
+	assert.Nil(t, one.Set(0, 0, 0, "grass.png"))
+	assert.Nil(t, one.Close())
+
+	cfg := DefaultOpenConfig()
+	cfg.MustExist = true
+	again, err := OpenInfiniteMapWithConfig(one.Filename(), cfg)
+	require.NoError(t, err)
+	src, _ := again.At(0, 0, 0)
+	assert.Equal(t, "grass.png", src)
+
+	assert.Nil(t, again.Destroy())
+	assert.Nil(t, two.Destroy())
+	files, _ := os.ReadDir(dir)
+	assert.Equal(t, 0, len(files))
+
+	_, err = OpenInfiniteMapWithConfig(one.Filename(), cfg)
+	assert.NotNil(t, err)
+}
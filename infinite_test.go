@@ -0,0 +1,152 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestInfiniteMapOrigin(t *testing.T) {
+	inf := NewInfiniteMapWithStorage(NewMemoryStorage())
+	assert.Nil(t, inf.Set(10, 20, 0, "a.png"))
+	assert.Nil(t, inf.Set(11, 21, 1, "b.png"))
+	assert.Nil(t, inf.Set(9, 20, 0, "outside.png"))
+	assert.Nil(t, inf.Set(-3, -2, 0, "c.png"))
+
+	m, err := inf.Map(10, 20, 12, 22)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, m.Width)
+	assert.Equal(t, 2, m.Height)
+
+	src, _ := m.At(0, 0, 0)
+	assert.Equal(t, "a.png", src)
+	src, _ = m.At(1, 1, 1)
+	assert.Equal(t, "b.png", src)
+	assert.Equal(t, 2, len(m.cells()))
+
+	m, err = inf.Map(-4, -4, 0, 0)
+	assert.Nil(t, err)
+	src, _ = m.At(1, 2, 0)
+	assert.Equal(t, "c.png", src)
+
+	m, err = inf.MapWithTileSize(16, 8, 10, 20, 12, 22)
+	assert.Nil(t, err)
+	assert.Equal(t, 16, m.TileWidth)
+	assert.Equal(t, 8, m.TileHeight)
+	src, _ = m.At(0, 0, 0)
+	assert.Equal(t, "a.png", src)
+}
+
+// benchTiles returns how many tiles to fill benchmark maps with.
+// Set TILE_BENCH_TILES to test at larger sizes (eg. 100000000).
+func benchTiles() int {
+	num, err := strconv.Atoi(os.Getenv("TILE_BENCH_TILES"))
+	if err != nil || num < 1 {
+		return 1000000
+	}
+	return num
+}
+
+// benchStorages returns a new empty storage of each backend by name.
+// Backends that can't be opened (eg. sqlite without cgo) are left out.
+func benchStorages(b *testing.B) map[string]Storage {
+	stores := map[string]Storage{"memory": NewMemoryStorage()}
+
+	sq, err := OpenSQLiteStorage(filepath.Join(b.TempDir(), "bench.sqlite"))
+	if err == nil {
+		stores["sqlite"] = sq
+	}
+
+	bt, err := OpenBoltStorage(filepath.Join(b.TempDir(), "bench.bolt"))
+	if err == nil {
+		stores["bolt"] = bt
+	}
+
+	return stores
+}
+
+// benchInfiniteMaps runs `fn` for each storage backend with an infinite map
+// filled with `num` tiles on z-level 0 in a square-ish area of the given width.
+func benchInfiniteMaps(b *testing.B, num int, fn func(b *testing.B, inf *InfiniteMap, width int)) {
+	width := 1
+	for width*width < num {
+		width++
+	}
+
+	for name, s := range benchStorages(b) {
+		batch := make([]Cell, 0, maxBenchBatch)
+		for n := 0; n < num; n++ {
+			batch = append(batch, Cell{X: n % width, Y: n / width, Src: "grass.png"})
+			if len(batch) < cap(batch) && n < num-1 {
+				continue
+			}
+			tx, err := s.Begin()
+			if err != nil {
+				b.Fatal(err)
+			}
+			err = tx.SetTiles(batch...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			err = tx.Commit()
+			if err != nil {
+				b.Fatal(err)
+			}
+			batch = batch[:0]
+		}
+
+		inf := NewInfiniteMapWithStorage(s)
+		b.Run(name, func(b *testing.B) {
+			fn(b, inf, width)
+		})
+		s.Close()
+	}
+}
+
+// maxBenchBatch is how many tiles we write per transaction when filling
+// benchmark maps
+const maxBenchBatch = 100000
+
+func BenchmarkInfiniteAt(b *testing.B) {
+	benchInfiniteMaps(b, benchTiles(), func(b *testing.B, inf *InfiniteMap, width int) {
+		for n := 0; n < b.N; n++ {
+			_, err := inf.At((n*7919)%width, (n*104729)%width, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkInfiniteMap(b *testing.B) {
+	benchInfiniteMaps(b, benchTiles(), func(b *testing.B, inf *InfiniteMap, width int) {
+		for n := 0; n < b.N; n++ {
+			x := (n * 7919) % (width - 32)
+			y := (n * 104729) % (width - 32)
+			_, err := inf.Map(x, y, x+32, y+32)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkInfiniteSet(b *testing.B) {
+	benchInfiniteMaps(b, benchTiles(), func(b *testing.B, inf *InfiniteMap, width int) {
+		err := inf.Batch(func(tx *InfiniteTx) error {
+			for n := 0; n < b.N; n++ {
+				err := tx.Set((n*7919)%width, (n*104729)%width, 1, "mushroom.png")
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+/* file holds how tile layer data (gids) are kept in memory. */
+package tile
+
+// LayerMode picks how a Map holds it's tile layers in memory
+type LayerMode int
+
+const (
+	// LayersAuto uses dense layers for small maps & sparse layers for large ones
+	LayersAuto LayerMode = iota
+
+	// LayersDense holds every tile of every layer, which is fastest for small
+	// or mostly full maps
+	LayersDense
+
+	// LayersSparse holds layers in chunks that are only allocated once a
+	// tile in them is set, so large mostly empty maps use little memory
+	LayersSparse
+)
+
+const (
+	// layerChunkSize is the width & height in tiles of a sparse layer chunk
+	layerChunkSize = 16
+
+	// sparseLayerTiles is the number of tiles in a layer above which
+	// LayersAuto uses sparse layers
+	sparseLayerTiles = 256 * 256
+)
+
+// sparse returns if layers of a width x height map should be sparse
+func (l LayerMode) sparse(width, height int) bool {
+	switch l {
+	case LayersDense:
+		return false
+	case LayersSparse:
+		return true
+	}
+	return width*height > sparseLayerTiles
+}
+
+// layerTiles holds the gid of each tile in a layer, indexed by y * width + x.
+// Either every tile is held (dense) or tiles are held in chunks of
+// layerChunkSize x layerChunkSize where nil chunks are all the nil tile (0).
+type layerTiles struct {
+	width, height int
+
+	dense []uint
+
+	chunked bool
+	across  int      // number of chunks in each row of chunks
+	chunks  [][]uint // row major chunks
+}
+
+// newLayerTiles returns an empty layer of width x height tiles
+func newLayerTiles(width, height int, sparse bool) *layerTiles {
+	if width < 0 || height < 0 {
+		width, height = 0, 0
+	}
+	if !sparse {
+		return &layerTiles{width: width, height: height, dense: make([]uint, width*height)}
+	}
+
+	across := (width + layerChunkSize - 1) / layerChunkSize
+	down := (height + layerChunkSize - 1) / layerChunkSize
+	return &layerTiles{
+		width:   width,
+		height:  height,
+		chunked: true,
+		across:  across,
+		chunks:  make([][]uint, across*down),
+	}
+}
+
+// denseLayerTiles returns a dense layer holding the given gids (which may
+// be shorter or longer than width * height, as found in a .tmx file)
+func denseLayerTiles(width, height int, gids []uint) *layerTiles {
+	return &layerTiles{width: width, height: height, dense: gids}
+}
+
+// len returns the number of tiles in the layer
+func (l *layerTiles) len() int {
+	if l == nil {
+		return 0
+	}
+	if !l.chunked {
+		return len(l.dense)
+	}
+	return l.width * l.height
+}
+
+// chunk returns the chunk number & the index within it for a layer index
+func (l *layerTiles) chunk(index int) (int, int) {
+	x, y := index%l.width, index/l.width
+	return (y/layerChunkSize)*l.across + x/layerChunkSize, (y%layerChunkSize)*layerChunkSize + x%layerChunkSize
+}
+
+// get returns the gid at the given index, which must be < len()
+func (l *layerTiles) get(index int) uint {
+	if !l.chunked {
+		return l.dense[index]
+	}
+	c, i := l.chunk(index)
+	if l.chunks[c] == nil {
+		return 0
+	}
+	return l.chunks[c][i]
+}
+
+// set the gid at the given index, which must be < len()
+func (l *layerTiles) set(index int, gid uint) {
+	if !l.chunked {
+		l.dense[index] = gid
+		return
+	}
+	c, i := l.chunk(index)
+	if l.chunks[c] == nil {
+		if gid == 0 {
+			return
+		}
+		l.chunks[c] = make([]uint, layerChunkSize*layerChunkSize)
+	}
+	l.chunks[c][i] = gid
+}
+
+// next returns the index & gid of the first non nil tile at or after
+// index `from`, or -1 if there are no more. Layers are walked with
+//
+//	for index, gid := l.next(0); index >= 0; index, gid = l.next(index + 1) {
+func (l *layerTiles) next(from int) (int, uint) {
+	if l == nil || from < 0 {
+		return -1, 0
+	}
+	if !l.chunked {
+		for index := from; index < len(l.dense); index++ {
+			if l.dense[index] != 0 {
+				return index, l.dense[index]
+			}
+		}
+		return -1, 0
+	}
+	if l.width == 0 {
+		return -1, 0
+	}
+
+	for y := from / l.width; y < l.height; y++ {
+		x := 0
+		if y == from/l.width {
+			x = from % l.width
+		}
+		row := (y / layerChunkSize) * l.across
+		offset := (y % layerChunkSize) * layerChunkSize
+		for x < l.width {
+			cx := x / layerChunkSize
+			chunk := l.chunks[row+cx]
+			if chunk == nil {
+				// skip the rest of an empty chunk
+				x = (cx + 1) * layerChunkSize
+				continue
+			}
+			if gid := chunk[offset+x%layerChunkSize]; gid != 0 {
+				return y*l.width + x, gid
+			}
+			x++
+		}
+	}
+	return -1, 0
+}
+
+// clone returns a deep copy of the layer
+func (l *layerTiles) clone() *layerTiles {
+	if l == nil {
+		return nil
+	}
+	out := *l
+	if !l.chunked {
+		out.dense = append([]uint{}, l.dense...)
+		return &out
+	}
+	out.chunks = make([][]uint, len(l.chunks))
+	for i, chunk := range l.chunks {
+		if chunk != nil {
+			out.chunks[i] = append([]uint{}, chunk...)
+		}
+	}
+	return &out
+}
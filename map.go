@@ -3,12 +3,15 @@
 package tile
 
 import (
-	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/xml"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
 	"sort"
 	"strconv"
 )
@@ -26,6 +29,8 @@ func New(cfg *Config) *Map {
 		TileLayers:     []*TileLayer{},
 		ImageLayers:    []*ImageLayer{},
 		nextID:         1,
+		layers:         cfg.Layers,
+		images:         newImageLoader(osFS{}, "."),
 	}
 }
 
@@ -42,19 +47,7 @@ func (m *Map) SetMapProperties(in *Properties) {
 // Fits returns if copying in the given map to (x,y,zoffset) would
 // overwrite an existing tile on any layer in our current map.
 func (m *Map) Fits(x, y, zoffset int, o *Map) (bool, error) {
-	if zoffset < 0 {
-		levels := m.ZLevels()
-		for i := len(levels) - 1; i >= 0; i-- {
-			src, _ := m.At(x, y, i)
-			if src != "" {
-				zoffset = i
-				break
-			}
-		}
-		if zoffset < 0 {
-			zoffset = 0
-		}
-	}
+	zoffset = m.stackZ(x, y, zoffset)
 
 	for _, tl := range o.TileLayers {
 		z, err := strconv.ParseInt(tl.Name, 10, 64)
@@ -62,11 +55,7 @@ func (m *Map) Fits(x, y, zoffset int, o *Map) (bool, error) {
 			continue
 		}
 
-		for index, tid := range tl.decodedTiles {
-			if tid == 0 {
-				continue // nil tile
-			}
-
+		for index, _ := tl.decodedTiles.next(0); index >= 0; index, _ = tl.decodedTiles.next(index + 1) {
 			// the reverse of index = y * width + x
 			tx := index % o.Width
 			ty := index / o.Width
@@ -92,20 +81,7 @@ func (m *Map) Fits(x, y, zoffset int, o *Map) (bool, error) {
 // we write ie, those named after their z-layers (0, 1, 2, 3, ...).
 // (x,y) is the top left tile, irrespective of z-layer.
 func (m *Map) Add(x, y, zoffset int, o *Map) error {
-	ts := o.Tilesets[0]
-	if zoffset < 0 {
-		levels := m.ZLevels()
-		for i := len(levels) - 1; i >= 0; i-- {
-			src, _ := m.At(x, y, i)
-			if src != "" {
-				zoffset = i
-				break
-			}
-		}
-		if zoffset < 0 {
-			zoffset = 0
-		}
-	}
+	zoffset = m.stackZ(x, y, zoffset)
 
 	for _, tl := range o.TileLayers {
 		z, err := strconv.ParseInt(tl.Name, 10, 64)
@@ -113,13 +89,9 @@ func (m *Map) Add(x, y, zoffset int, o *Map) error {
 			continue
 		}
 
-		for index, tid := range tl.decodedTiles {
-			if tid == 0 {
-				// skip nil tile
-				continue
-			}
-			tile, ok := ts.tileByID[tid]
-			if !ok {
+		for index, tid := tl.decodedTiles.next(0); index >= 0; index, tid = tl.decodedTiles.next(index + 1) {
+			tile := o.tileByGID(tid)
+			if tile == nil || tile.Image == nil {
 				// implies we have a tile with no tileset entry ??
 				continue
 			}
@@ -143,6 +115,47 @@ func (m *Map) Add(x, y, zoffset int, o *Map) error {
 	return nil
 }
 
+// stackZ returns the zoffset to use when adding an object at (x,y).
+// A negative zoffset means "on top of" the highest tile set at (x,y),
+// or 0 if there isn't one.
+func (m *Map) stackZ(x, y, zoffset int) int {
+	if zoffset >= 0 {
+		return zoffset
+	}
+
+	levels := m.ZLevels()
+	for i := len(levels) - 1; i >= 0 && levels[i] >= 0; i-- {
+		src, _ := m.At(x, y, levels[i])
+		if src != "" {
+			return levels[i]
+		}
+	}
+	return 0
+}
+
+// cells returns every non nil tile in our z-level layers
+func (m *Map) cells() []Cell {
+	result := []Cell{}
+	for _, tl := range m.TileLayers {
+		z, err := strconv.ParseInt(tl.Name, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		for index, tid := tl.decodedTiles.next(0); index >= 0; index, tid = tl.decodedTiles.next(index + 1) {
+			tile := m.tileByGID(tid)
+			if tile == nil || tile.Image == nil || tile.Image.Source == "" {
+				continue
+			}
+
+			// the reverse of index = y * width + x
+			result = append(result, Cell{X: index % m.Width, Y: index / m.Width, Z: int(z), Src: tile.Image.Source})
+		}
+	}
+
+	return result
+}
+
 // ZLevels returns all z-level maps (maps named after an int) sorted low -> high.
 func (m *Map) ZLevels() []int {
 	levels := []int{}
@@ -161,80 +174,57 @@ func (m *Map) ZLevels() []int {
 // At returns the properties of the tile at (x, y, z) or nil if not set
 // (ie. set to the nil tile).
 func (m *Map) At(x, y, z int) (string, error) {
-	var l *TileLayer
-	for _, tl := range m.TileLayers {
-		// match z => tilelayer name
-		if fmt.Sprintf("%d", z) == tl.Name {
-			l = tl
-			break
-		}
-	}
-	if l == nil {
+	l := m.layer(z)
+	if l == nil || x < 0 || y < 0 || x >= m.Width || y >= m.Height {
 		return "", nil
 	}
 
 	index := y*m.Width + x
-	if index >= len(l.decodedTiles) || index < 0 {
-		return "", nil
-	}
 
-	id := l.decodedTiles[index]
+	id := l.decodedTiles.get(index)
 	if id == 0 {
 		// the nil tile
 		return "", nil
 	}
 
-	for _, ts := range m.Tilesets {
-		t, ok := ts.tileByID[id]
-		if !ok {
-			return "", nil
-		}
-		return t.Image.Source, nil
+	t := m.tileByGID(id)
+	if t == nil || t.Image == nil {
+		return "", nil
 	}
-
-	return "", nil
+	return t.Image.Source, nil
 }
 
 // Set the tile source for (x,y,z) to some image src.
 // If the image doesn't exist in a tileset it is added.
 // If "" is passed for source the nil tile is set (ID: 0).
 func (m *Map) Set(x, y, z int, source string) error {
-	var l *TileLayer
-	for _, tl := range m.TileLayers {
-		// match z => tilelayer name
-		if fmt.Sprintf("%d", z) == tl.Name {
-			l = tl
-			break
-		}
+	if x < 0 || y < 0 || x >= m.Width || y >= m.Height {
+		return fmt.Errorf("(%d,%d) is out of bounds for this map", x, y)
 	}
 
-	index := y*m.Width + x
+	l := m.layer(z)
 	if l == nil {
-		l = m.newTilelayer(fmt.Sprintf("%d", z))
+		l = m.newTilelayer(strconv.Itoa(z))
 	}
+	index := y*m.Width + x
 
-	if len(l.decodedTiles) <= index || index < 0 {
-		return fmt.Errorf("index %d is out of bounds for this map", index)
+	old := ""
+	if m.events.active() {
+		old, _ = m.At(x, y, z)
+		defer m.tileEvent(x, y, z, old, source)
 	}
 
 	if source == "" {
 		// nil tile
-		l.decodedTiles[index] = 0
+		l.decodedTiles.set(index, 0)
 		return nil
 	}
 
-	var t *Tile
-	for _, ts := range m.Tilesets {
-		var ok bool
-		t, ok = ts.tileBySrc[source]
-		if ok {
-			break
-		}
-	}
+	t, gid := m.tileBySrc(source)
 	if t == nil {
-		t = m.newTile(source)
+		_, gid = m.newTile(source)
 	}
-	l.decodedTiles[index] = t.ID
+	l.decodedTiles.set(index, gid)
 	return nil
 }
 
@@ -271,14 +261,7 @@ func (m *Map) Properties(source string) (*Properties, error) {
 		return nil, nil
 	}
 
-	var t *Tile
-	for _, ts := range m.Tilesets {
-		var ok bool
-		t, ok = ts.tileBySrc[source]
-		if ok {
-			break
-		}
-	}
+	t, _ := m.tileBySrc(source)
 	if t == nil {
 		return NewProperties(), nil
 	}
@@ -293,18 +276,14 @@ func (m *Map) SetProperties(source string, in *Properties) error {
 		return nil
 	}
 
-	var t *Tile
-	for _, ts := range m.Tilesets {
-		var ok bool
-		t, ok = ts.tileBySrc[source]
-		if ok {
-			break
-		}
-	}
+	t, _ := m.tileBySrc(source)
 	if t == nil {
-		t = m.newTile(source)
+		t, _ = m.newTile(source)
 	}
 
+	if m.events.active() {
+		defer m.propertiesEvent(source, newPropertiesFromList(t.Properties), in)
+	}
 	t.Properties = in.toList()
 
 	return nil
@@ -312,25 +291,25 @@ func (m *Map) SetProperties(source string, in *Properties) error {
 
 // Encode the current map as XML to a io.Writer stream
 func (m *Map) Encode(w io.Writer) error {
-	for _, ts := range m.Tilesets {
-		ts.tileBySrc = map[string]*Tile{}
-		ts.tileByID = map[uint]*Tile{}
+	return m.EncodeWithConfig(w, DefaultEncodeConfig())
+}
 
-		for _, t := range ts.Tiles {
-			ts.tileBySrc[t.Image.Source] = t
-			ts.tileByID[t.ID] = t
-		}
-	}
+// EncodeWithConfig encodes the current map as XML to a io.Writer stream.
+//
+// The encoding is canonical; the same map is always written as the same bytes
+// with layers, tilesets, tiles & properties written in a set order.
+func (m *Map) EncodeWithConfig(w io.Writer, cfg *EncodeConfig) error {
+	m.index()
 
 	// tiled renders maps in order of ID, low -> high
 	// So we'll sort our layers, then ID them in order to make sure they're rendered
-	// in the intended order.
-	sort.Slice(m.ImageLayers, func(i, j int) bool {
+	// in the intended order. Layers not named after a number keep their order.
+	sort.SliceStable(m.ImageLayers, func(i, j int) bool {
 		in, _ := strconv.ParseInt(m.ImageLayers[i].Name, 10, 64)
 		jn, _ := strconv.ParseInt(m.ImageLayers[j].Name, 10, 64)
 		return in < jn
 	})
-	sort.Slice(m.TileLayers, func(i, j int) bool {
+	sort.SliceStable(m.TileLayers, func(i, j int) bool {
 		in, _ := strconv.ParseInt(m.TileLayers[i].Name, 10, 64)
 		jn, _ := strconv.ParseInt(m.TileLayers[j].Name, 10, 64)
 		return in < jn
@@ -341,85 +320,372 @@ func (m *Map) Encode(w io.Writer) error {
 	for i, l := range m.TileLayers {
 		l.ID = uint(i + len(m.ImageLayers) + 1)
 	}
+	m.renumberExtra()
 
-	offset := uint(0)
-	if len(m.Tilesets) > 0 { // TODO: this only supports one tileset
-		offset += m.Tilesets[0].FirstGID
-	}
 	for _, tl := range m.TileLayers {
-		ids := make([]uint, len(tl.decodedTiles))
-		for i, j := range tl.decodedTiles {
-			if j == 0 {
-				ids[i] = 0
-			} else {
-				ids[i] = j + offset
-			}
+		// tiles are held as gids, so are written as is a row at a time
+		tl.Data.tiles, tl.Data.width, tl.Data.height = tl.decodedTiles, m.Width, m.Height
+		sortProperties(tl.Properties)
+	}
+	defer func() {
+		for _, tl := range m.TileLayers {
+			tl.Data.tiles = nil
 		}
+	}()
 
-		tdata, err := tl.Data.encodeCSV(m.Width, m.Height, ids)
-		if err != nil {
-			return err
+	// tilesets by gid & their tiles by id
+	sort.SliceStable(m.Tilesets, func(i, j int) bool {
+		return m.Tilesets[i].FirstGID < m.Tilesets[j].FirstGID
+	})
+	for _, ts := range m.Tilesets {
+		sort.SliceStable(ts.Tiles, func(i, j int) bool {
+			return ts.Tiles[i].ID < ts.Tiles[j].ID
+		})
+		if ts.TileCount != 0 && ts.isCollection() {
+			ts.TileCount = len(ts.Tiles)
+		}
+		sortProperties(ts.Properties)
+		for _, t := range ts.Tiles {
+			sortProperties(t.Properties)
 		}
-		tl.Data.RawData = tdata
 	}
+	sortProperties(m.RootProperties)
 
-	return xml.NewEncoder(w).Encode(m)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", cfg.Indent)
+	err := enc.Encode(&encodedMap{Map: m, Layers: layerWriter{m: m}})
+	if err != nil {
+		return err
+	}
+	if cfg.Indent != "" {
+		// end with a newline, as text files do
+		_, err = w.Write([]byte("\n"))
+	}
+	return err
 }
 
-// Decode an input TMX map XML
+// Decode an input TMX map XML.
+// External (.tsx) tilesets can't be loaded from a reader, use Open for those.
 func Decode(r io.Reader) (*Map, error) {
-	m := &Map{}
-	if err := xml.NewDecoder(r).Decode(m); err != nil {
-		return nil, err
+	return decode(r, nil, "", DefaultDecodeConfig())
+}
+
+// DecodeWithConfig decodes an input TMX map XML (see Decode).
+// Problems with the map are returned as a *DecodeError.
+func DecodeWithConfig(r io.Reader, cfg *DecodeConfig) (*Map, error) {
+	return decode(r, nil, "", cfg)
+}
+
+// decode an input TMX map XML, loading external tilesets & images from
+// `dir` of fsys
+func decode(r io.Reader, fsys fs.FS, dir string, cfg *DecodeConfig) (*Map, error) {
+	return decodeStream(r, fsys, dir, cfg, func(m *Map, tl *TileLayer) error {
+		m.TileLayers = append(m.TileLayers, tl)
+		return nil
+	})
+}
+
+// checkSize returns an error if the map has too many tiles to hold, or if
+// strict no tiles
+func (m *Map) checkSize(strict bool) error {
+	least := 0
+	if strict {
+		least = 1
 	}
+	if m.Width < least || m.Height < least || (m.Height > 0 && m.Width > maxMapTiles/m.Height) {
+		return decodeError(ErrMapSize, "map", "%dx%d", m.Width, m.Height)
+	}
+	return nil
+}
 
-	if len(m.Tilesets) != 1 {
-		return nil, fmt.Errorf("lib only supports 1 tileset")
+// decodeLayer reads the tiles of a tile layer from it's csv data, which is
+// then dropped. If not strict other encodings are read as if they were csv.
+func (m *Map) decodeLayer(tl *TileLayer, cfg *DecodeConfig) error {
+	element := fmt.Sprintf("layer %q", tl.Name)
+	if cfg.Strict && (tl.Data.Encoding != "csv" || tl.Data.Compression != "") {
+		return decodeError(ErrUnsupportedEncoding, element, "encoding %q compression %q", tl.Data.Encoding, tl.Data.Compression)
+	}
+
+	var tiles *layerTiles
+	var gids []uint
+	sparse := m.layers.sparse(m.Width, m.Height)
+	if sparse {
+		tiles = newLayerTiles(m.Width, m.Height, true)
+	} else {
+		// there's at most one gid per 2 bytes of data
+		size := len(tl.Data.RawData)/2 + 1
+		if size > m.Width*m.Height {
+			size = m.Width * m.Height
+		}
+		gids = make([]uint, 0, size)
 	}
 
-	m.nextID = uint(1)
-	for _, ts := range m.Tilesets {
-		ts.tileByID = map[uint]*Tile{}
-		ts.tileBySrc = map[string]*Tile{}
+	count, err := tl.Data.readCSV(cfg.Strict, func(index int, gid uint) {
+		if !sparse {
+			gids = append(gids, gid)
+		} else if index < tiles.len() {
+			tiles.set(index, gid)
+		}
+	})
+	if err != nil {
+		return decodeError(ErrInvalidTileData, element, "%v", err)
+	}
+	if !sparse {
+		tiles = denseLayerTiles(m.Width, m.Height, gids)
+	}
+	tl.decodedTiles = tiles
+	tl.Data.RawData = nil
 
-		// I know we just checked if len tilesets != 1 but
-		// in future we may support more
+	if cfg.Strict {
+		return m.validateLayer(tl, count)
+	}
+	return nil
+}
+
+// validate map level settings & tilesets of a decoded map
+func (m *Map) validate() error {
+	if m.Orientation != "orthogonal" {
+		return decodeError(ErrUnsupportedOrientation, "map", "%q", m.Orientation)
+	}
+
+	for _, ts := range m.Tilesets {
+		element := fmt.Sprintf("tileset %q", ts.Name)
+		if !ts.isCollection() {
+			if ts.Image == nil || ts.Image.Source == "" {
+				return decodeError(ErrMissingImage, element, "")
+			}
+			continue
+		}
 		for _, t := range ts.Tiles {
-			if t.ID > m.nextID {
-				m.nextID = t.ID + 1
+			if t.Image == nil || t.Image.Source == "" {
+				return decodeError(ErrMissingImage, fmt.Sprintf("%s tile %d", element, t.ID), "")
 			}
+		}
+	}
+
+	return nil
+}
 
-			ts.tileByID[t.ID+ts.FirstGID] = t
-			ts.tileBySrc[t.Image.Source] = t
+// validateLayer checks the size & gids of a decoded tile layer, which had
+// `count` tiles in the file
+func (m *Map) validateLayer(tl *TileLayer, count int) error {
+	element := fmt.Sprintf("layer %q", tl.Name)
+	if tl.Width != m.Width || tl.Height != m.Height {
+		return decodeError(ErrLayerSize, element, "layer is %dx%d, map is %dx%d", tl.Width, tl.Height, m.Width, m.Height)
+	}
+	if count != m.Width*m.Height {
+		return decodeError(ErrLayerSize, element, "%d tiles for a %dx%d map", count, m.Width, m.Height)
+	}
+
+	for index, gid := tl.decodedTiles.next(0); index >= 0; index, gid = tl.decodedTiles.next(index + 1) {
+		if !m.knownGID(gid) {
+			return decodeError(ErrUnknownGID, fmt.Sprintf("%s (%d,%d)", element, index%m.Width, index/m.Width), "%d", gid)
 		}
 	}
+	return nil
+}
 
-	for _, tl := range m.TileLayers {
-		csvdata, err := tl.Data.decodeCSV()
-		if err != nil {
-			return nil, err
+// knownGID returns if `gid` is a tile of one of our tilesets
+func (m *Map) knownGID(gid uint) bool {
+	if m.tileByGID(gid) != nil {
+		return true
+	}
+
+	// tiles of single image tilesets aren't listed
+	gid &^= gidFlipFlags
+	for i, ts := range m.Tilesets {
+		if ts.isCollection() || gid < ts.FirstGID {
+			continue
+		}
+		if ts.TileCount > 0 {
+			if gid < ts.FirstGID+uint(ts.TileCount) {
+				return true
+			}
+			continue
+		}
+
+		// without a tile count, it's up to the next tileset
+		last := true
+		for _, other := range m.Tilesets[i+1:] {
+			if other.FirstGID > ts.FirstGID {
+				last = false
+				if gid < other.FirstGID {
+					return true
+				}
+			}
+		}
+		if last {
+			return true
 		}
-		tl.decodedTiles = csvdata
+	}
+	return false
+}
+
+// load an external tileset file (.tsx) into ts, after which it's treated as if
+// it were written in the map.
+func (ts *Tileset) load(fsys fs.FS, fname string) error {
+	data, err := fs.ReadFile(fsys, fname)
+	if err != nil {
+		return err
+	}
+
+	ext := &Tileset{}
+	err = xml.Unmarshal(data, ext)
+	if err != nil {
+		return fmt.Errorf("failed to read tileset %s: %v", fname, err)
+	}
+
+	// image sources in a tileset are relative to the tileset file, but once
+	// embedded they'll be read relative to the map
+	rel := path.Dir(ts.Source)
+	for _, t := range ext.Tiles {
+		if t.Image != nil && t.Image.Source != "" && rel != "." && !path.IsAbs(t.Image.Source) {
+			t.Image.Source = path.Join(rel, t.Image.Source)
+		}
+	}
+	if ext.Image != nil && ext.Image.Source != "" && rel != "." && !path.IsAbs(ext.Image.Source) {
+		ext.Image.Source = path.Join(rel, ext.Image.Source)
 	}
 
-	return m, nil
+	ext.FirstGID = ts.FirstGID
+	*ts = *ext
+	return nil
 }
 
-//
+// Open reads a .tmx map file from disk
 func Open(fname string) (*Map, error) {
+	return OpenWithConfig(fname, DefaultDecodeConfig())
+}
+
+// OpenWithConfig reads a .tmx map file from disk (see DecodeWithConfig)
+func OpenWithConfig(fname string, cfg *DecodeConfig) (*Map, error) {
 	f, err := os.Open(fname)
 	if err != nil {
 		return nil, err
 	}
-	return Decode(f)
+	defer f.Close()
+	return decode(f, osFS{}, filepath.ToSlash(filepath.Dir(fname)), cfg)
 }
 
-//
+// OpenFS reads a .tmx map file from a fs.FS, eg. an embed.FS or zip.Reader.
+// External tilesets & tile images (see Image) are read from fsys too,
+// relative to the map.
+func OpenFS(fsys fs.FS, name string) (*Map, error) {
+	return OpenFSWithConfig(fsys, name, DefaultDecodeConfig())
+}
+
+// OpenFSWithConfig reads a .tmx map file from a fs.FS (see OpenFS & DecodeWithConfig)
+func OpenFSWithConfig(fsys fs.FS, name string, cfg *DecodeConfig) (*Map, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decode(f, fsys, path.Dir(name), cfg)
+}
+
+// WriteFile encodes the map to the given file
 func (m *Map) WriteFile(fname string) error {
-	buff := bytes.Buffer{}
-	err := m.Encode(&buff)
+	return m.WriteFileWithConfig(fname, DefaultEncodeConfig())
+}
+
+// WriteFileWithConfig encodes the map to the given file (see EncodeWithConfig).
+// The map is written to a temporary file that replaces `fname` once it's
+// complete, so `fname` is never left partly written.
+func (m *Map) WriteFileWithConfig(fname string, cfg *EncodeConfig) error {
+	return writeFileAtomic(fname, func(w io.Writer) error {
+		return m.EncodeWithConfig(w, cfg)
+	})
+}
+
+// writeFileAtomic writes a file via a temporary file in the same directory,
+// which is renamed to `fname` if `write` succeeds (& removed otherwise).
+// If `fname` is a symlink the file it points to is written, keeping the
+// link (even if it's target doesn't exist yet). The mode of a file being
+// replaced is kept, new files are created as 0666 less the umask.
+func writeFileAtomic(fname string, write func(w io.Writer) error) error {
+	fname, err := resolveLink(fname)
+	if err != nil {
+		return err
+	}
+
+	var mode os.FileMode
+	info, err := os.Stat(fname)
+	if err == nil {
+		mode = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := createTemp(filepath.Dir(fname), "."+filepath.Base(fname)+".")
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(fname, buff.Bytes(), 0644)
+
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && mode != 0 {
+		err = os.Chmod(f.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fname)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// maxLinks is the most symlinks followed by resolveLink
+const maxLinks = 255
+
+// resolveLink follows `fname` while it's a symlink, one link at a time, &
+// returns the path it ends at. Unlike filepath.EvalSymlinks the path
+// doesn't need to exist, so a dangling link resolves to it's target.
+func resolveLink(fname string) (string, error) {
+	for i := 0; i < maxLinks; i++ {
+		info, err := os.Lstat(fname)
+		if os.IsNotExist(err) {
+			return fname, nil
+		} else if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fname, nil
+		}
+
+		target, err := os.Readlink(fname)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(fname), target)
+		}
+		fname = target
+	}
+	return "", fmt.Errorf("too many symlinks, unable to resolve %s", fname)
+}
+
+// createTemp creates a new file in `dir` named `prefix` & a random suffix.
+// Unlike ioutil.TempFile the file is created as 0666 (less the umask) so
+// it has the mode any new file would.
+func createTemp(dir, prefix string) (*os.File, error) {
+	buf := make([]byte, 8)
+	for i := 0; i < 100; i++ {
+		_, err := rand.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(filepath.Join(dir, prefix+hex.EncodeToString(buf)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
+	return nil, fmt.Errorf("unable to create a temporary file in %s", dir)
 }
@@ -0,0 +1,193 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// benchMapSize returns the width & height of benchmark maps.
+// Set TILE_BENCH_MAP to test at larger sizes (eg. 1000).
+func benchMapSize() int {
+	num, err := strconv.Atoi(os.Getenv("TILE_BENCH_MAP"))
+	if err != nil || num < 1 {
+		return 200
+	}
+	return num
+}
+
+// benchMapLevels is the number of z-levels in benchmark maps
+const benchMapLevels = 40
+
+func TestLayerZ(t *testing.T) {
+	for name, z := range map[string]int{"0": 0, "7": 7, "-1": -1, "120": 120} {
+		got, ok := layerZ(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, z, got, name)
+	}
+	for _, name := range []string{"", "-", "-0", "+1", "01", "1a", "Ground", "99999999999999999999"} {
+		_, ok := layerZ(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestMapLayerIndex(t *testing.T) {
+	m := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 4, MapHeight: 4})
+	assert.Nil(t, m.Set(1, 1, 3, "grass.png"))
+
+	// layers added directly are still found
+	m.TileLayers = append(m.TileLayers, &TileLayer{Name: "5", decodedTiles: newLayerTiles(4, 4, false)})
+	assert.Nil(t, m.Set(1, 1, 5, "tree.png"))
+	assert.Equal(t, 2, len(m.TileLayers))
+
+	// names not written as z-levels aren't z-levels
+	m.TileLayers = append(m.TileLayers, &TileLayer{Name: "06", decodedTiles: newLayerTiles(4, 4, false)})
+	assert.Nil(t, m.Set(1, 1, 6, "cloud.png"))
+	assert.Equal(t, 4, len(m.TileLayers))
+
+	src, _ := m.At(1, 1, 5)
+	assert.Equal(t, "tree.png", src)
+	src, _ = m.At(1, 1, 6)
+	assert.Equal(t, "cloud.png", src)
+}
+
+func TestMapAtSetAllocs(t *testing.T) {
+	m := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 16, MapHeight: 16})
+	m.Set(0, 0, 2, "grass.png")
+	m.Set(0, 0, 3, "tree.png")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		m.At(3, 4, 2)
+		m.At(3, 4, 10) // no such layer
+		m.Set(3, 4, 3, "grass.png")
+		m.Set(3, 4, 2, "tree.png")
+		m.Set(3, 4, 3, "")
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestLayerTiles(t *testing.T) {
+	for _, sparse := range []bool{false, true} {
+		l := newLayerTiles(40, 20, sparse)
+		assert.Equal(t, 800, l.len())
+
+		index, _ := l.next(0)
+		assert.Equal(t, -1, index)
+
+		for _, i := range []int{0, 17, 39, 40, 399, 799} {
+			l.set(i, uint(i+1))
+		}
+		l.set(500, 0) // nil tile in a nil chunk
+
+		found := []int{}
+		for index, gid := l.next(0); index >= 0; index, gid = l.next(index + 1) {
+			assert.Equal(t, uint(index+1), gid)
+			assert.Equal(t, gid, l.get(index))
+			found = append(found, index)
+		}
+		assert.Equal(t, []int{0, 17, 39, 40, 399, 799}, found, sparse)
+		assert.Equal(t, uint(0), l.get(500))
+	}
+}
+
+func TestMapLayerModes(t *testing.T) {
+	maps := map[string]*Map{}
+	for name, mode := range map[string]LayerMode{"dense": LayersDense, "sparse": LayersSparse, "auto": LayersAuto} {
+		m := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 50, MapHeight: 30, Layers: mode})
+		assert.Nil(t, m.Set(0, 0, 0, "grass.png"))
+		assert.Nil(t, m.Set(49, 29, 0, "rock.png"))
+		assert.Nil(t, m.Set(20, 17, 2, "tree.png"))
+		assert.Nil(t, m.Set(20, 17, 2, ""))
+		assert.Nil(t, m.Add(10, 10, 1, testTree()))
+		assert.NotNil(t, m.Set(50, 29, 0, "rock.png"))
+		maps[name] = m
+	}
+	assert.False(t, maps["dense"].TileLayers[0].decodedTiles.chunked)
+	assert.True(t, maps["sparse"].TileLayers[0].decodedTiles.chunked)
+	assert.False(t, maps["auto"].TileLayers[0].decodedTiles.chunked)
+	assert.True(t, LayersAuto.sparse(1000, 1000))
+
+	// both hold & write the same tiles
+	dense := &bytes.Buffer{}
+	sparse := &bytes.Buffer{}
+	assert.Nil(t, maps["dense"].Encode(dense))
+	assert.Nil(t, maps["sparse"].Encode(sparse))
+	assert.Equal(t, dense.String(), sparse.String())
+	assert.Equal(t, maps["dense"].cells(), maps["sparse"].cells())
+
+	src, _ := maps["sparse"].At(49, 29, 0)
+	assert.Equal(t, "rock.png", src)
+	fits, _ := maps["sparse"].Fits(10, 10, 1, testTree())
+	assert.False(t, fits)
+}
+
+// benchMap returns a map filled with tiles on every z-level
+func benchMap(b *testing.B) *Map {
+	size := benchMapSize()
+	m := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: uint(size), MapHeight: uint(size)})
+	for z := 0; z < benchMapLevels; z++ {
+		for i := 0; i < size*size; i += 7 {
+			m.Set(i%size, i/size, z, "grass.png")
+		}
+	}
+	return m
+}
+
+func BenchmarkMapFill(b *testing.B) {
+	size := benchMapSize()
+	srcs := []string{"grass.png", "dirt.png", "rock.png", "water.png"}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		m := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: uint(size), MapHeight: uint(size)})
+		for z := 0; z < benchMapLevels; z++ {
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					m.Set(x, y, z, srcs[(x+y+z)%len(srcs)])
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkMapSparseMemory reports the bytes used by a large, mostly empty map
+func BenchmarkMapSparseMemory(b *testing.B) {
+	size := benchMapSize() * 10
+	for name, mode := range map[string]LayerMode{"dense": LayersDense, "sparse": LayersSparse} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				m := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: uint(size), MapHeight: uint(size), Layers: mode})
+				for z := 0; z < benchMapLevels; z++ {
+					for i := 0; i < 100; i++ {
+						m.Set((i*7919)%size, (i*104729)%size, z, "tree.png")
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMapSet(b *testing.B) {
+	m := benchMap(b)
+	size := benchMapSize()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		i := (n * 7919) % (size * size)
+		m.Set(i%size, i/size, n%benchMapLevels, "rock.png")
+	}
+}
+
+func BenchmarkMapAt(b *testing.B) {
+	m := benchMap(b)
+	size := benchMapSize()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		i := (n * 7919) % (size * size)
+		m.At(i%size, i/size, n%benchMapLevels)
+	}
+}
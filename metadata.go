@@ -0,0 +1,329 @@
+/* file adds map level data (tile size, properties, image layers, regions) to an InfiniteMap. */
+package tile
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metadata keys
+const (
+	metaTileWidth   = "map.tilewidth"
+	metaTileHeight  = "map.tileheight"
+	metaOrientation = "map.orientation"
+	metaProperties  = "map.properties"
+	metaImageLayer  = "imagelayer." // + layer name
+	metaRegion      = "region."     // + region name
+	metaTileset     = "tileset."    // + image of a single image tileset
+)
+
+// defaults for an InfiniteMap with no metadata set, to match DefaultConfig
+const (
+	defaultTileWidth   = 32
+	defaultTileHeight  = 32
+	defaultOrientation = "orthogonal"
+)
+
+// InfiniteImageLayer is an image layer kept by an InfiniteMap
+type InfiniteImageLayer struct {
+	Name   string `json:"-"`
+	Source string `json:"source"`
+
+	// size in pixels
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// the tile the top left corner of the image is at
+	X int `json:"x"`
+	Y int `json:"y"`
+
+	// Cover means the image is stretched over the whole of any Map taken
+	// from the infinite map (X, Y, Width & Height are ignored), as a background.
+	Cover bool `json:"cover,omitempty"`
+}
+
+// Region is a named rectangle (x0,y0,x1,y1) of an InfiniteMap
+type Region struct {
+	Name string `json:"-"`
+	X0   int    `json:"x0"`
+	Y0   int    `json:"y0"`
+	X1   int    `json:"x1"`
+	Y1   int    `json:"y1"`
+}
+
+// TileSize returns the width & height of tiles in pixels.
+// If unset 32x32 is returned.
+func (i *InfiniteMap) TileSize() (uint, uint, error) {
+	meta, err := i.storage.Metadata("map.tile")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	w, h := uint(defaultTileWidth), uint(defaultTileHeight)
+	value, ok := meta[metaTileWidth]
+	if ok {
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return 0, 0, err
+		}
+		w = uint(n)
+	}
+	value, ok = meta[metaTileHeight]
+	if ok {
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return 0, 0, err
+		}
+		h = uint(n)
+	}
+	return w, h, nil
+}
+
+// SetTileSize sets the width & height of tiles in pixels
+func (i *InfiniteMap) SetTileSize(width, height uint) error {
+	return i.storage.SetMetadata(map[string]string{
+		metaTileWidth:  strconv.FormatUint(uint64(width), 10),
+		metaTileHeight: strconv.FormatUint(uint64(height), 10),
+	})
+}
+
+// Orientation returns the map orientation, "orthogonal" if unset
+func (i *InfiniteMap) Orientation() (string, error) {
+	meta, err := i.storage.Metadata(metaOrientation)
+	if err != nil {
+		return "", err
+	}
+	value, ok := meta[metaOrientation]
+	if !ok {
+		return defaultOrientation, nil
+	}
+	return value, nil
+}
+
+// SetOrientation sets the map orientation
+func (i *InfiniteMap) SetOrientation(orientation string) error {
+	return i.storage.SetMetadata(map[string]string{metaOrientation: orientation})
+}
+
+// MapProperties returns properties set on the map itself
+func (i *InfiniteMap) MapProperties() (*Properties, error) {
+	return mapProperties(i.storage)
+}
+
+// SetMapProperties sets properties on the map. This doesn't do an update / merge just overwrites.
+func (i *InfiniteMap) SetMapProperties(props *Properties) error {
+	return i.storage.SetMetadata(map[string]string{metaProperties: string(encodeProperties(props))})
+}
+
+// mapProperties reads the map properties from the given store
+func mapProperties(s Store) (*Properties, error) {
+	meta, err := s.Metadata(metaProperties)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := meta[metaProperties]
+	if !ok {
+		return NewProperties(), nil
+	}
+	return decodeProperties([]byte(value))
+}
+
+// ImageLayers returns all image layers sorted by name
+func (i *InfiniteMap) ImageLayers() ([]*InfiniteImageLayer, error) {
+	meta, err := i.storage.Metadata(metaImageLayer)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*InfiniteImageLayer{}
+	for key, value := range meta {
+		l := &InfiniteImageLayer{}
+		err := json.Unmarshal([]byte(value), l)
+		if err != nil {
+			return nil, err
+		}
+		l.Name = strings.TrimPrefix(key, metaImageLayer)
+		result = append(result, l)
+	}
+
+	sort.Slice(result, func(a, b int) bool { return result[a].Name < result[b].Name })
+	return result, nil
+}
+
+// SetImageLayer adds (or replaces) an image layer by name
+func (i *InfiniteMap) SetImageLayer(l *InfiniteImageLayer) error {
+	return setImageLayer(i.storage, l)
+}
+
+// setImageLayer writes an image layer to the given store
+func setImageLayer(s Store, l *InfiniteImageLayer) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return s.SetMetadata(map[string]string{metaImageLayer + l.Name: string(data)})
+}
+
+// RemoveImageLayer removes an image layer by name (if it exists)
+func (i *InfiniteMap) RemoveImageLayer(name string) error {
+	return i.storage.SetMetadata(map[string]string{metaImageLayer + name: ""})
+}
+
+// SetBackground sets (/creates) an image layer "background" that covers any
+// map taken from the infinite map (see Map.SetBackground).
+func (i *InfiniteMap) SetBackground(src string) error {
+	return i.SetImageLayer(&InfiniteImageLayer{Name: "background", Source: src, Cover: true})
+}
+
+// Regions returns all named regions sorted by name
+func (i *InfiniteMap) Regions() ([]*Region, error) {
+	meta, err := i.storage.Metadata(metaRegion)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Region{}
+	for key, value := range meta {
+		r := &Region{}
+		err := json.Unmarshal([]byte(value), r)
+		if err != nil {
+			return nil, err
+		}
+		r.Name = strings.TrimPrefix(key, metaRegion)
+		result = append(result, r)
+	}
+
+	sort.Slice(result, func(a, b int) bool { return result[a].Name < result[b].Name })
+	return result, nil
+}
+
+// Region returns the region with the given name or nil if there isn't one
+func (i *InfiniteMap) Region(name string) (*Region, error) {
+	meta, err := i.storage.Metadata(metaRegion + name)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := meta[metaRegion+name]
+	if !ok {
+		return nil, nil
+	}
+
+	r := &Region{}
+	err = json.Unmarshal([]byte(value), r)
+	r.Name = name
+	return r, err
+}
+
+// SetRegion adds (or replaces) a named region
+func (i *InfiniteMap) SetRegion(r *Region) error {
+	return setRegion(i.storage, r)
+}
+
+// setRegion writes a region to the given store
+func setRegion(s Store, r *Region) error {
+	if r.X1 <= r.X0 || r.Y1 <= r.Y0 {
+		return fmt.Errorf("region %s dimensions invalid", r.Name)
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.SetMetadata(map[string]string{metaRegion + r.Name: string(data)})
+}
+
+// RemoveRegion removes a region by name (if it exists)
+func (i *InfiniteMap) RemoveRegion(name string) error {
+	return i.storage.SetMetadata(map[string]string{metaRegion + name: ""})
+}
+
+// RegionMap returns a Map of the named region
+func (i *InfiniteMap) RegionMap(name string) (*Map, error) {
+	r, err := i.Region(name)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, fmt.Errorf("region %s not found", name)
+	}
+	return i.Map(r.X0, r.Y0, r.X1, r.Y1)
+}
+
+// configure sets map level data (orientation, properties, image layers) on `m`
+// which is the rectangle (x0,y0,x1,y1) of the infinite map.
+func (i *InfiniteMap) configure(m *Map, x0, y0, x1, y1 int) error {
+	orientation, err := i.Orientation()
+	if err != nil {
+		return err
+	}
+	m.Orientation = orientation
+
+	props, err := i.MapProperties()
+	if err != nil {
+		return err
+	}
+	m.SetMapProperties(props)
+
+	layers, err := i.ImageLayers()
+	if err != nil {
+		return err
+	}
+	for _, l := range layers {
+		if l.Cover {
+			m.ImageLayers = append(m.ImageLayers, &ImageLayer{
+				Name:  l.Name,
+				Image: &Image{Source: l.Source, Width: m.TileWidth * m.Width, Height: m.TileHeight * m.Height},
+			})
+			continue
+		}
+
+		// skip images entirely outside of the map
+		lx0, ly0 := l.X*m.TileWidth, l.Y*m.TileHeight
+		if lx0 >= x1*m.TileWidth || ly0 >= y1*m.TileHeight ||
+			lx0+l.Width <= x0*m.TileWidth || ly0+l.Height <= y0*m.TileHeight {
+			continue
+		}
+
+		m.ImageLayers = append(m.ImageLayers, &ImageLayer{
+			Name:    l.Name,
+			OffsetX: (l.X - x0) * m.TileWidth,
+			OffsetY: (l.Y - y0) * m.TileHeight,
+			Image:   &Image{Source: l.Source, Width: l.Width, Height: l.Height},
+		})
+	}
+
+	return nil
+}
+
+// sheetTilesets returns the single image tilesets tiles were imported from
+// (see sheetSrc) by image
+func sheetTilesets(s Store) (map[string]*Tileset, error) {
+	meta, err := s.Metadata(metaTileset)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]*Tileset{}
+	for key, value := range meta {
+		ts := &Tileset{}
+		err := xml.Unmarshal([]byte(value), ts)
+		if err != nil {
+			return nil, err
+		}
+		ts.index()
+		result[strings.TrimPrefix(key, metaTileset)] = ts
+	}
+	return result, nil
+}
+
+// setSheetTileset writes a single image tileset to the given store, by it's image
+func setSheetTileset(s Store, ts *Tileset) error {
+	data, err := xml.Marshal(ts)
+	if err != nil {
+		return err
+	}
+	return s.SetMetadata(map[string]string{metaTileset + ts.Image.Source: string(data)})
+}
@@ -0,0 +1,84 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+func TestInfiniteMetadata(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+
+			// defaults
+			w, h, err := inf.TileSize()
+			assert.Nil(t, err)
+			assert.Equal(t, uint(32), w)
+			assert.Equal(t, uint(32), h)
+			o, err := inf.Orientation()
+			assert.Nil(t, err)
+			assert.Equal(t, "orthogonal", o)
+
+			assert.Nil(t, inf.SetTileSize(16, 24))
+			assert.Nil(t, inf.SetOrientation("isometric"))
+			props := NewProperties()
+			props.SetString("biome", "desert")
+			assert.Nil(t, inf.SetMapProperties(props))
+			assert.Nil(t, inf.SetBackground("sand.png"))
+			assert.Nil(t, inf.SetImageLayer(&InfiniteImageLayer{Name: "oasis", Source: "oasis.png", Width: 32, Height: 48, X: 5, Y: 5}))
+			assert.Nil(t, inf.Set(5, 5, 0, "palm.png"))
+
+			layers, err := inf.ImageLayers()
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(layers))
+			assert.Equal(t, "background", layers[0].Name)
+			assert.True(t, layers[0].Cover)
+
+			// maps taken from the infinite map carry it's metadata
+			m, err := inf.Map(4, 4, 8, 8)
+			assert.Nil(t, err)
+			assert.Equal(t, 16, m.TileWidth)
+			assert.Equal(t, 24, m.TileHeight)
+			assert.Equal(t, "isometric", m.Orientation)
+			biome, _ := m.MapProperties().String("biome")
+			assert.Equal(t, "desert", biome)
+			assert.Equal(t, 2, len(m.ImageLayers))
+			assert.Equal(t, 64, m.ImageLayers[0].Image.Width)
+			assert.Equal(t, 16, m.ImageLayers[1].OffsetX)
+			assert.Equal(t, 24, m.ImageLayers[1].OffsetY)
+
+			// image layers outside the map are skipped
+			m, err = inf.Map(20, 20, 24, 24)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(m.ImageLayers))
+
+			assert.Nil(t, inf.RemoveImageLayer("oasis"))
+			layers, _ = inf.ImageLayers()
+			assert.Equal(t, 1, len(layers))
+
+			// regions
+			assert.NotNil(t, inf.SetRegion(&Region{Name: "bad", X0: 1, Y0: 1, X1: 1, Y1: 2}))
+			assert.Nil(t, inf.SetRegion(&Region{Name: "camp", X0: 4, Y0: 4, X1: 7, Y1: 7}))
+			assert.Nil(t, inf.SetRegion(&Region{Name: "arena", X0: 0, Y0: 0, X1: 2, Y1: 2}))
+
+			regions, err := inf.Regions()
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(regions))
+			assert.Equal(t, "arena", regions[0].Name)
+
+			m, err = inf.RegionMap("camp")
+			assert.Nil(t, err)
+			assert.Equal(t, 3, m.Width)
+			src, _ := m.At(1, 1, 0)
+			assert.Equal(t, "palm.png", src)
+
+			assert.Nil(t, inf.RemoveRegion("camp"))
+			r, err := inf.Region("camp")
+			assert.Nil(t, err)
+			assert.Nil(t, r)
+			_, err = inf.RegionMap("camp")
+			assert.NotNil(t, err)
+		})
+	}
+}
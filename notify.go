@@ -0,0 +1,203 @@
+/* file adds subscribing to changes made to a Map or InfiniteMap. */
+package tile
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is a change made to a Map or InfiniteMap.
+// For ChangeProperties events Properties holds the new properties of Src.
+// Seq is not set.
+type Event struct {
+	Change
+	Properties *Properties
+}
+
+// EventFilter picks which events a subscriber is sent
+type EventFilter struct {
+	// Region if set limits tile events to those inside it
+	Region *Region
+
+	// TilesOnly drops property events. Property events have no location
+	// so are otherwise sent regardless of Region.
+	TilesOnly bool
+}
+
+// match returns if the event passes the filter
+func (f *EventFilter) match(e *Event) bool {
+	if f == nil {
+		return true
+	}
+	if e.Kind == ChangeProperties {
+		return !f.TilesOnly
+	}
+	if f.Region == nil {
+		return true
+	}
+	return e.X >= f.Region.X0 && e.X < f.Region.X1 && e.Y >= f.Region.Y0 && e.Y < f.Region.Y1
+}
+
+// subscriber is a single call to Subscribe or Watch
+type subscriber struct {
+	filter *EventFilter
+	fn     func(e Event)
+}
+
+// notifier sends events to subscribers
+type notifier struct {
+	lock sync.RWMutex
+	next int
+	subs map[int]*subscriber
+}
+
+// active returns if there are any subscribers
+func (n *notifier) active() bool {
+	if n == nil {
+		return false
+	}
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return len(n.subs) > 0
+}
+
+// subscribe adds a subscriber, returning a func to remove it
+func (n *notifier) subscribe(fn func(e Event), filter *EventFilter) func() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.subs == nil {
+		n.subs = map[int]*subscriber{}
+	}
+	id := n.next
+	n.next++
+	n.subs[id] = &subscriber{filter: filter, fn: fn}
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			n.lock.Lock()
+			defer n.lock.Unlock()
+			delete(n.subs, id)
+		})
+	}
+}
+
+// watch adds a subscriber that is sent events over a channel with the given buffer
+func (n *notifier) watch(filter *EventFilter, buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	done := make(chan struct{})
+	lock := sync.Mutex{} // held while sending, so we don't close `ch` mid send
+	closed := false
+
+	cancel := n.subscribe(func(e Event) {
+		lock.Lock()
+		defer lock.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- e:
+		case <-done:
+		}
+	}, filter)
+
+	once := sync.Once{}
+	return ch, func() {
+		once.Do(func() {
+			cancel()
+			close(done)
+			lock.Lock()
+			defer lock.Unlock()
+			closed = true
+			close(ch)
+		})
+	}
+}
+
+// publish sends changes to all subscribers that want them, in order
+func (n *notifier) publish(changes ...Change) {
+	if len(changes) == 0 || !n.active() {
+		return
+	}
+
+	n.lock.RLock()
+	subs := make([]*subscriber, 0, len(n.subs))
+	for _, s := range n.subs {
+		subs = append(subs, s)
+	}
+	n.lock.RUnlock()
+
+	for _, c := range changes {
+		e := Event{Change: c}
+		if c.Kind == ChangeProperties {
+			e.Properties = NewProperties()
+			if c.New != "" {
+				e.Properties, _ = decodeProperties([]byte(c.New))
+			}
+		}
+		for _, s := range subs {
+			if s.filter.match(&e) {
+				s.fn(e)
+			}
+		}
+	}
+}
+
+// Subscribe calls `fn` for each change made to the map that passes `filter`
+// (nil for all changes). `fn` is called by whatever goroutine made the change
+// after it's made, so should be quick.
+// The returned func stops further calls.
+func (m *Map) Subscribe(fn func(e Event), filter *EventFilter) func() {
+	return m.notifier().subscribe(fn, filter)
+}
+
+// Watch is Subscribe but events are sent over a channel with the given buffer size.
+// Changes to the map block while the channel is full.
+// The returned func stops events & closes the channel.
+func (m *Map) Watch(filter *EventFilter, buffer int) (<-chan Event, func()) {
+	return m.notifier().watch(filter, buffer)
+}
+
+// notifier returns the map's notifier, creating it if needed
+func (m *Map) notifier() *notifier {
+	if m.events == nil {
+		m.events = &notifier{}
+	}
+	return m.events
+}
+
+// tileEvent publishes a tile change if anyone is subscribed
+func (m *Map) tileEvent(x, y, z int, old, src string) {
+	if old == src {
+		return
+	}
+	m.events.publish(Change{Time: time.Now(), Kind: ChangeTile, X: x, Y: y, Z: z, Old: old, New: src})
+}
+
+// propertiesEvent publishes a properties change if anyone is subscribed
+func (m *Map) propertiesEvent(src string, old, props *Properties) {
+	before, after := changeProperties(old), changeProperties(props)
+	if before == after {
+		return
+	}
+	m.events.publish(Change{Time: time.Now(), Kind: ChangeProperties, Src: src, Old: before, New: after})
+}
+
+// Subscribe calls `fn` for each change made to the map that passes `filter`
+// (nil for all changes). Changes made in a transaction are sent once it's
+// committed. `fn` is called by whatever goroutine made the change so should
+// be quick; it may read or write the map.
+// Only changes made via this InfiniteMap are seen, not those by another
+// process (or InfiniteMap) using the same database.
+// The returned func stops further calls.
+func (i *InfiniteMap) Subscribe(fn func(e Event), filter *EventFilter) func() {
+	return i.events.subscribe(fn, filter)
+}
+
+// Watch is Subscribe but events are sent over a channel with the given buffer size.
+// Changes to the map block while the channel is full.
+// The returned func stops events & closes the channel.
+func (i *InfiniteMap) Watch(filter *EventFilter, buffer int) (<-chan Event, func()) {
+	return i.events.watch(filter, buffer)
+}
@@ -0,0 +1,94 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"errors"
+	"testing"
+)
+
+// subscribable is implemented by Map & InfiniteMap
+type subscribable interface {
+	Tileable
+	Subscribe(fn func(e Event), filter *EventFilter) func()
+	Watch(filter *EventFilter, buffer int) (<-chan Event, func())
+}
+
+func TestSubscribe(t *testing.T) {
+	for name, impl := range testTileables(t) {
+		t.Run(name, func(t *testing.T) {
+			s := impl.(subscribable)
+
+			all := []Event{}
+			stop := s.Subscribe(func(e Event) { all = append(all, e) }, nil)
+
+			inside := []Event{}
+			s.Subscribe(func(e Event) { inside = append(inside, e) }, &EventFilter{
+				Region:    &Region{X0: 0, Y0: 0, X1: 2, Y1: 2},
+				TilesOnly: true,
+			})
+
+			assert.Nil(t, s.Set(1, 1, 0, "grass.png"))
+			assert.Nil(t, s.Set(1, 1, 0, "grass.png")) // no change, no event
+			assert.Nil(t, s.Set(5, 5, 0, "grass.png"))
+			assert.Nil(t, s.Set(1, 1, 0, ""))
+
+			props := NewProperties()
+			props.SetBool("soft", true)
+			assert.Nil(t, s.SetProperties("grass.png", props))
+
+			assert.Equal(t, 4, len(all))
+			assert.Equal(t, ChangeTile, all[0].Kind)
+			assert.Equal(t, "", all[0].Old)
+			assert.Equal(t, "grass.png", all[0].New)
+			assert.Equal(t, "grass.png", all[2].Old)
+			assert.Equal(t, ChangeProperties, all[3].Kind)
+			soft, _ := all[3].Properties.Bool("soft")
+			assert.True(t, soft)
+
+			assert.Equal(t, 2, len(inside))
+			assert.Equal(t, 1, inside[1].X)
+
+			stop()
+			assert.Nil(t, s.Set(2, 2, 0, "grass.png"))
+			assert.Equal(t, 4, len(all))
+
+			ch, cancel := s.Watch(nil, 1)
+			assert.Nil(t, s.Set(3, 3, 0, "rock.png"))
+			e := <-ch
+			assert.Equal(t, "rock.png", e.New)
+			cancel()
+			_, open := <-ch
+			assert.False(t, open)
+		})
+	}
+}
+
+func TestSubscribeTx(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+
+			events := []Event{}
+			inf.Subscribe(func(e Event) { events = append(events, e) }, nil)
+
+			// nothing is sent for a rolled back transaction
+			err := inf.Batch(func(tx *InfiniteTx) error {
+				assert.Nil(t, tx.Set(0, 0, 0, "grass.png"))
+				return errors.New("oops")
+			})
+			assert.NotNil(t, err)
+			assert.Equal(t, 0, len(events))
+
+			// changes are sent after commit
+			err = inf.Tagged("trees", func(tx *InfiniteTx) error {
+				assert.Nil(t, tx.Add(0, 0, 0, testTree()))
+				assert.Equal(t, 0, len(events))
+				return nil
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, 6+1, len(events)) // tiles of the tree + trunk properties
+			assert.Equal(t, "trees", events[0].Tag)
+		})
+	}
+}
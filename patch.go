@@ -0,0 +1,390 @@
+/* file adds patches; the changes between two maps that can be saved & re-applied. */
+package tile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Patch is a list of changes made to a map, eg. hand edits made in Tiled to
+// a generated map, that can be saved (as JSON) & applied again later.
+//
+// Each change holds the value it was made from (Old), so applying a patch
+// to a map that no longer matches is reported as a conflict.
+type Patch struct {
+	Changes []Difference `json:"changes"`
+}
+
+// PatchConfig sets how patches are applied
+type PatchConfig struct {
+	// SkipConflicts applies the changes that don't conflict, rather than
+	// failing without changing anything
+	SkipConflicts bool
+
+	// Force applies every change whether it conflicts or not, other than
+	// cells outside of the map
+	Force bool
+}
+
+// DefaultPatchConfig returns a config that applies nothing if any change conflicts
+func DefaultPatchConfig() *PatchConfig {
+	return &PatchConfig{}
+}
+
+// Conflict is a change in a patch where the map doesn't hold the value the
+// change was made from, Found is the value the map holds instead
+type Conflict struct {
+	Difference
+	Found string
+}
+
+// ConflictError is returned when a patch doesn't match the map it's applied to
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+// Error returns a description of the first conflict
+func (e *ConflictError) Error() string {
+	c := e.Conflicts[0]
+	return fmt.Sprintf("patch conflicts with the map in %d place(s), first %s (found %q)", len(e.Conflicts), c.Difference, c.Found)
+}
+
+// NewPatch returns the changes that turn `base` into `edited`, being the
+// cells, tile properties, map properties & tile layers that differ.
+// Other differences (eg. map size, image layers) aren't included.
+func NewPatch(base, edited *Map) *Patch {
+	p := &Patch{Changes: []Difference{}}
+	for _, d := range base.Diff(edited) {
+		switch d.Kind {
+		case DiffMap:
+			if d.Key != "properties" {
+				continue
+			}
+		case DiffTileset:
+			// a new src brings it's properties with it
+			props := NewProperties()
+			if t, _ := edited.tileBySrc(d.New); t != nil {
+				props = newPropertiesFromList(t.Properties)
+			}
+			if d.New == "" || changeProperties(props) == "" {
+				continue
+			}
+			d = Difference{Kind: DiffProperties, Key: d.New, New: changeProperties(props)}
+		case DiffImageLayer:
+			continue
+		}
+		p.Changes = append(p.Changes, d)
+	}
+	return p
+}
+
+// DecodePatch reads a patch written by Patch.Encode
+func DecodePatch(r io.Reader) (*Patch, error) {
+	p := &Patch{}
+	err := json.NewDecoder(r).Decode(p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Encode the patch as JSON
+func (p *Patch) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// ApplyPatch applies the patch with it's top left corner at (x,y) & z-levels
+// offset by z. If any change conflicts nothing is applied & a *ConflictError
+// is returned (see PatchConfig).
+func (m *Map) ApplyPatch(p *Patch, x, y, z int, cfg *PatchConfig) error {
+	return applyPatch(&mapPatcher{m: m}, p, x, y, z, cfg)
+}
+
+// ApplyPatch applies the patch with it's top left corner at (x,y) & z-levels
+// offset by z, in one transaction. Tile layers of the patch must be named
+// after z-levels. If any change conflicts nothing is applied & a *ConflictError
+// is returned (see PatchConfig).
+func (i *InfiniteMap) ApplyPatch(p *Patch, x, y, z int, cfg *PatchConfig) error {
+	return i.Tagged("patch", func(tx *InfiniteTx) error {
+		return tx.ApplyPatch(p, x, y, z, cfg)
+	})
+}
+
+// ApplyPatch applies the patch as part of the transaction (see InfiniteMap.ApplyPatch)
+func (t *InfiniteTx) ApplyPatch(p *Patch, x, y, z int, cfg *PatchConfig) error {
+	return applyPatch(&infinitePatcher{tx: t}, p, x, y, z, cfg)
+}
+
+// patcher is something a patch can be applied to
+type patcher interface {
+	// current returns the value `d` would change in the map now
+	current(d Difference) (string, error)
+
+	// apply the change `d`
+	apply(d Difference) error
+}
+
+// applyPatch checks every change of `p` (moved by x,y,z) against the current
+// value in `t` then applies them
+func applyPatch(t patcher, p *Patch, x, y, z int, cfg *PatchConfig) error {
+	if cfg == nil {
+		cfg = DefaultPatchConfig()
+	}
+
+	conflicts := []Conflict{}
+	todo := []Difference{}
+	for _, d := range p.Changes {
+		if d.Kind == DiffCell || d.Kind == DiffLayer {
+			d.Layer = offsetLayer(d.Layer, z)
+		}
+		if d.Kind == DiffCell {
+			d.X += x
+			d.Y += y
+		}
+
+		found, err := t.current(d)
+		if err != nil {
+			return err
+		}
+		if found != d.Old && (!cfg.Force || found == outsideMap) {
+			conflicts = append(conflicts, Conflict{Difference: d, Found: found})
+			continue
+		}
+		todo = append(todo, d)
+	}
+	if len(conflicts) > 0 && !cfg.SkipConflicts {
+		return &ConflictError{Conflicts: conflicts}
+	}
+
+	// layers are removed once their cells are
+	removed := []Difference{}
+	for _, d := range todo {
+		if d.Kind == DiffLayer && d.Key == "" && d.New == "" {
+			removed = append(removed, d)
+			continue
+		}
+		err := t.apply(d)
+		if err != nil {
+			return err
+		}
+	}
+	for _, d := range removed {
+		err := t.apply(d)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// offsetLayer returns the name of layer `name` moved by z levels, if it's
+// named after a z-level
+func offsetLayer(name string, z int) string {
+	level, ok := layerZ(name)
+	if !ok {
+		return name
+	}
+	return strconv.Itoa(level + z)
+}
+
+// decodeChange decodes properties from a Difference ("" for none)
+func decodeChange(value string) (*Properties, error) {
+	if value == "" {
+		return NewProperties(), nil
+	}
+	return decodeProperties([]byte(value))
+}
+
+// mapPatcher applies patches to a Map
+type mapPatcher struct {
+	m *Map
+}
+
+// outsideMap is the value found for a cell outside of the map, it's always a
+// conflict (even if forced) as it can't be set
+const outsideMap = "(outside the map)"
+
+// current implements patcher
+func (p *mapPatcher) current(d Difference) (string, error) {
+	switch d.Kind {
+	case DiffMap:
+		return changeProperties(p.m.MapProperties()), nil
+	case DiffProperties:
+		props, err := p.m.Properties(d.Key)
+		return changeProperties(props), err
+	case DiffLayer:
+		if d.Key == "" {
+			// adding a layer that exists or removing one that doesn't is fine
+			return d.Old, nil
+		}
+		l := p.m.namedLayer(d.Layer)
+		if l == nil {
+			return "", nil
+		}
+		return changeProperties(newPropertiesFromList(l.Properties)), nil
+	case DiffCell:
+		if d.X < 0 || d.Y < 0 || d.X >= p.m.Width || d.Y >= p.m.Height {
+			return outsideMap, nil
+		}
+		l := p.m.namedLayer(d.Layer)
+		if l == nil {
+			return "", nil
+		}
+		gid := l.decodedTiles.get(d.Y*p.m.Width + d.X)
+		if gid == 0 {
+			return "", nil
+		}
+		return p.m.gidSrc(gid), nil
+	}
+	return "", fmt.Errorf("%s can't be applied to a map", d)
+}
+
+// apply implements patcher
+func (p *mapPatcher) apply(d Difference) error {
+	switch d.Kind {
+	case DiffMap:
+		props, err := decodeChange(d.New)
+		if err != nil {
+			return err
+		}
+		p.m.SetMapProperties(props)
+		return nil
+	case DiffProperties:
+		props, err := decodeChange(d.New)
+		if err != nil {
+			return err
+		}
+		return p.m.SetProperties(d.Key, props)
+	case DiffLayer:
+		l := p.m.namedLayer(d.Layer)
+		switch {
+		case d.Key != "":
+			props, err := decodeChange(d.New)
+			if err != nil {
+				return err
+			}
+			if l == nil {
+				l = p.m.newTilelayer(d.Layer)
+			}
+			l.Properties = props.toList()
+		case d.New != "" && l == nil:
+			p.m.newTilelayer(d.Layer)
+		case d.New == "" && l != nil:
+			p.m.removeLayer(l)
+		}
+		return nil
+	case DiffCell:
+		src, flags := splitFlips(d.New)
+		var err error
+		if z, ok := layerZ(d.Layer); ok {
+			err = p.m.Set(d.X, d.Y, z, src)
+		} else {
+			err = p.m.setNamed(d.Layer, d.X, d.Y, src)
+		}
+		if err != nil || flags == 0 {
+			return err
+		}
+		l := p.m.namedLayer(d.Layer)
+		index := d.Y*p.m.Width + d.X
+		l.decodedTiles.set(index, l.decodedTiles.get(index)|flags)
+		return nil
+	}
+	return fmt.Errorf("%s can't be applied to a map", d)
+}
+
+// setNamed sets the tile src at (x,y) on the named layer, which is created
+// if needed
+func (m *Map) setNamed(name string, x, y int, src string) error {
+	if x < 0 || y < 0 || x >= m.Width || y >= m.Height {
+		return fmt.Errorf("(%d,%d) is out of bounds for this map", x, y)
+	}
+	l := m.namedLayer(name)
+	if l == nil {
+		l = m.newTilelayer(name)
+	}
+
+	gid := uint(0)
+	if src != "" {
+		var t *Tile
+		t, gid = m.tileBySrc(src)
+		if t == nil {
+			_, gid = m.newTile(src)
+		}
+	}
+	l.decodedTiles.set(y*m.Width+x, gid)
+	return nil
+}
+
+// removeLayer removes the tile layer `l` if it holds no tiles
+func (m *Map) removeLayer(l *TileLayer) {
+	if index, _ := l.decodedTiles.next(0); index >= 0 {
+		return
+	}
+	for i, tl := range m.TileLayers {
+		if tl == l {
+			m.TileLayers = append(m.TileLayers[:i], m.TileLayers[i+1:]...)
+			m.indexLayers()
+			return
+		}
+	}
+}
+
+// infinitePatcher applies patches to an InfiniteMap in a transaction
+type infinitePatcher struct {
+	tx *InfiniteTx
+}
+
+// current implements patcher
+func (p *infinitePatcher) current(d Difference) (string, error) {
+	switch d.Kind {
+	case DiffMap:
+		props, err := mapProperties(p.tx.tx)
+		return changeProperties(props), err
+	case DiffProperties:
+		props, err := p.tx.Properties(d.Key)
+		return changeProperties(props), err
+	case DiffLayer:
+		if d.Key == "" {
+			// z-levels are always there
+			return d.Old, nil
+		}
+	case DiffCell:
+		z, ok := layerZ(d.Layer)
+		if !ok {
+			return "", fmt.Errorf("layer %s isn't named after a z-level", d.Layer)
+		}
+		return p.tx.At(d.X, d.Y, z)
+	}
+	return "", fmt.Errorf("%s can't be applied to an infinite map", d)
+}
+
+// apply implements patcher
+func (p *infinitePatcher) apply(d Difference) error {
+	switch d.Kind {
+	case DiffMap:
+		props, err := decodeChange(d.New)
+		if err != nil {
+			return err
+		}
+		return p.tx.writer().SetMetadata(map[string]string{metaProperties: string(encodeProperties(props))})
+	case DiffProperties:
+		props, err := decodeChange(d.New)
+		if err != nil {
+			return err
+		}
+		return p.tx.SetProperties(d.Key, props)
+	case DiffLayer:
+		return nil
+	case DiffCell:
+		// infinite maps don't keep flip flags (as with Import)
+		z, _ := layerZ(d.Layer)
+		src, _ := splitFlips(d.New)
+		return p.tx.Set(d.X, d.Y, z, src)
+	}
+	return fmt.Errorf("%s can't be applied to an infinite map", d)
+}
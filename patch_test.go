@@ -0,0 +1,160 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testPatch returns the house map & a patch of hand edits made to it
+func testPatch(t *testing.T) (*Map, *Patch) {
+	base := testHouse()
+
+	edited := base.Clone()
+	edited.Set(5, 6, 2, "door.png")
+	edited.Set(4, 4, 3, "")
+	edited.Set(1, 1, 7, "cloud.png")
+	props := NewProperties()
+	props.SetBool("open", true)
+	edited.SetProperties("door.png", props)
+	edited.SetMapProperties(props)
+
+	// patches survive being written out
+	buf := &bytes.Buffer{}
+	assert.Nil(t, NewPatch(base, edited).Encode(buf))
+	assert.Contains(t, buf.String(), `"kind": "cell"`)
+	p, err := DecodePatch(buf)
+	assert.Nil(t, err)
+	return base, p
+}
+
+func TestPatch(t *testing.T) {
+	base, p := testPatch(t)
+	assert.Equal(t, 6, len(p.Changes))
+
+	// a regenerated map that still matches gets the edits
+	regen := testHouse()
+	regen.Set(0, 0, 0, "flower.png")
+	assert.Nil(t, regen.ApplyPatch(p, 0, 0, 0, nil))
+	src, _ := regen.At(5, 6, 2)
+	assert.Equal(t, "door.png", src)
+	src, _ = regen.At(4, 4, 3)
+	assert.Equal(t, "", src)
+	src, _ = regen.At(0, 0, 0)
+	assert.Equal(t, "flower.png", src)
+	props, _ := regen.Properties("door.png")
+	open, _ := props.Bool("open")
+	assert.True(t, open)
+	open, _ = regen.MapProperties().Bool("open")
+	assert.True(t, open)
+
+	// undoing the edits by hand gives the base map
+	regen.Set(0, 0, 0, "")
+	assert.Equal(t, []Difference{}, NewPatch(regen, regen.Clone()).Changes)
+	assert.Equal(t, 6, len(NewPatch(base, regen).Changes))
+
+	// conflicts apply nothing by default
+	regen = testHouse()
+	regen.Set(5, 6, 2, "window.png")
+	err := regen.ApplyPatch(p, 0, 0, 0, DefaultPatchConfig())
+	conflict := &ConflictError{}
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, 1, len(conflict.Conflicts))
+	assert.Equal(t, "window.png", conflict.Conflicts[0].Found)
+	assert.Equal(t, "wall.png", conflict.Conflicts[0].Old)
+	src, _ = regen.At(1, 1, 7)
+	assert.Equal(t, "", src)
+
+	// or can be skipped
+	assert.Nil(t, regen.ApplyPatch(p, 0, 0, 0, &PatchConfig{SkipConflicts: true}))
+	src, _ = regen.At(5, 6, 2)
+	assert.Equal(t, "window.png", src)
+	src, _ = regen.At(1, 1, 7)
+	assert.Equal(t, "cloud.png", src)
+
+	// or overwritten
+	assert.Nil(t, regen.ApplyPatch(p, 0, 0, 0, &PatchConfig{Force: true}))
+	src, _ = regen.At(5, 6, 2)
+	assert.Equal(t, "door.png", src)
+}
+
+func TestPatchLayers(t *testing.T) {
+	base := testHouse()
+	edited := base.Clone()
+	for x := 0; x < 10; x++ {
+		edited.Set(x, 9, 0, "")
+	}
+	edited.TileLayers = edited.TileLayers[1:]
+	edited.indexLayers()
+	assert.Nil(t, edited.setNamed("Decor", 2, 2, "vase.png"))
+
+	p := NewPatch(base, edited)
+	assert.Nil(t, base.ApplyPatch(p, 0, 0, 0, nil))
+	assert.True(t, base.Equal(edited), base.Diff(edited))
+}
+
+func TestPatchMapEdge(t *testing.T) {
+	base := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 4, MapHeight: 4})
+	edited := base.Clone()
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			edited.Set(x, y, 0, "grass.png")
+		}
+	}
+	p := NewPatch(base, edited)
+
+	// most of the patch is off the edge of a 2x2 map, nothing is applied
+	m := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 2, MapHeight: 2})
+	for _, cfg := range []*PatchConfig{nil, {Force: true}} {
+		err := m.ApplyPatch(p, 0, 0, 0, cfg)
+		var conflict *ConflictError
+		assert.True(t, errors.As(err, &conflict))
+		assert.Equal(t, 12, len(conflict.Conflicts))
+		assert.Equal(t, outsideMap, conflict.Conflicts[0].Found)
+		assert.Equal(t, 0, len(m.cells()))
+	}
+
+	// .. or only the part on the map
+	assert.Nil(t, m.ApplyPatch(p, 1, 1, 0, &PatchConfig{SkipConflicts: true}))
+	src, _ := m.At(1, 1, 0)
+	assert.Equal(t, "grass.png", src)
+	assert.Equal(t, 1, len(m.cells()))
+
+	// x is checked on it's own, (3,0) isn't (1,1) of a 2 wide map
+	assert.NotNil(t, m.Set(3, 0, 0, "mud.png"))
+	src, _ = m.At(1, 1, 0)
+	assert.Equal(t, "grass.png", src)
+}
+
+func TestPatchInfinite(t *testing.T) {
+	base, p := testPatch(t)
+
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+			assert.Nil(t, inf.Import(base, 100, 50, 1, DefaultImportConfig()))
+
+			// the patch applies at the same offset as the map it was made from
+			assert.NotNil(t, inf.ApplyPatch(p, 0, 0, 0, nil))
+			assert.Nil(t, inf.ApplyPatch(p, 100, 50, 1, nil))
+
+			src, _ := inf.At(105, 56, 3)
+			assert.Equal(t, "door.png", src)
+			src, _ = inf.At(104, 54, 4)
+			assert.Equal(t, "", src)
+			src, _ = inf.At(101, 51, 8)
+			assert.Equal(t, "cloud.png", src)
+			props, _ := inf.Properties("door.png")
+			open, _ := props.Bool("open")
+			assert.True(t, open)
+
+			// applying it again conflicts (except adding the z-level)
+			err := inf.ApplyPatch(p, 100, 50, 1, nil)
+			conflict := &ConflictError{}
+			assert.True(t, errors.As(err, &conflict))
+			assert.Equal(t, 5, len(conflict.Conflicts))
+		})
+	}
+}
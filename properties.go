@@ -2,6 +2,7 @@ package tile
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 )
 
@@ -72,9 +73,37 @@ func (p *Properties) toList() []*Property {
 			Type:  PropString,
 		})
 	}
+	sortProperties(ps)
 	return ps
 }
 
+// sortProperties sorts properties by name (then type & value) so they're
+// always written in the same order
+func sortProperties(ps []*Property) {
+	sort.SliceStable(ps, func(i, j int) bool {
+		if ps[i].Name != ps[j].Name {
+			return ps[i].Name < ps[j].Name
+		}
+		if ps[i].Type != ps[j].Type {
+			return ps[i].Type < ps[j].Type
+		}
+		return ps[i].Value < ps[j].Value
+	})
+}
+
+// each calls `fn` with every set property key & value
+func (p *Properties) each(fn func(key string, value interface{})) {
+	for k, v := range p.ints {
+		fn(k, v)
+	}
+	for k, v := range p.bools {
+		fn(k, v)
+	}
+	for k, v := range p.strings {
+		fn(k, v)
+	}
+}
+
 // newPropertiesFromList turns the XML []Property into our nicer properties
 // wrapper struct.
 func newPropertiesFromList(in []*Property) *Properties {
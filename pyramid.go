@@ -0,0 +1,434 @@
+/* file exports an infinite map as a 'slippy map' style pyramid of PNG tiles.
+ */
+package tile
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/nfnt/resize"
+)
+
+const pyramidManifest = "pyramid.json"
+
+// PyramidConfig includes settings for writing a tile pyramid
+type PyramidConfig struct {
+	// in pixels, 0 means use the infinite map's tile size
+	TileWidth  uint
+	TileHeight uint
+
+	// ChunkSize is the width & height (in map tiles) of each pyramid image
+	// at the highest zoom level.
+	ChunkSize int
+
+	// Zoom levels to write. MaxZoom is full resolution, each lower zoom
+	// level is half the resolution of the level above it.
+	MinZoom int
+	MaxZoom int
+
+	// Render settings used for drawing each chunk. Scale & overlays are
+	// applied to each chunk image at the highest zoom level.
+	Render *RenderConfig
+}
+
+// DefaultPyramidConfig returns a pyramid config with default settings.
+func DefaultPyramidConfig() *PyramidConfig {
+	return &PyramidConfig{
+		ChunkSize: 8,
+		MinZoom:   0,
+		MaxZoom:   5,
+		Render:    DefaultRenderConfig(),
+	}
+}
+
+// pyramidState is written alongside the pyramid so that later runs can tell
+// which chunks have changed.
+type pyramidState struct {
+	TileWidth  uint              `json:"tilewidth"`
+	TileHeight uint              `json:"tileheight"`
+	ChunkSize  int               `json:"chunksize"`
+	MinZoom    int               `json:"minzoom"`
+	MaxZoom    int               `json:"maxzoom"`
+	Render     string            `json:"render"` // hash of the render config
+	Chunks     map[string]string `json:"chunks"` // "x,y" => hash of chunk tiles
+
+	// Seq is the last logged change included, if history is enabled
+	Seq int64 `json:"seq,omitempty"`
+}
+
+// WritePyramid renders the infinite map into a pyramid of PNG images laid out
+// as `<dir>/<zoom>/<x>/<y>.png` (as understood by Leaflet & friends).
+//
+// The export is incremental; a manifest is kept in `dir` & only chunks whose
+// tiles have changed since the last run (and the lower zoom images that
+// include them) are re-rendered. If history is enabled (see EnableHistory)
+// changed chunks are found from the change log, otherwise every chunk is
+// read & compared. Changing the config (including the render config) forces
+// a full render, & images missing from `dir` are always re-rendered.
+//
+// Chunks with no tiles are not written. Returns the number of images written.
+func (i *InfiniteMap) WritePyramid(dir string, cfg *PyramidConfig) (int, error) {
+	if cfg == nil {
+		cfg = DefaultPyramidConfig()
+	}
+	if cfg.ChunkSize < 1 || cfg.MaxZoom < cfg.MinZoom {
+		return 0, fmt.Errorf("invalid pyramid config")
+	}
+	rcfg := cfg.Render
+	if rcfg == nil {
+		rcfg = DefaultRenderConfig()
+	}
+	if cfg.TileWidth == 0 || cfg.TileHeight == 0 {
+		w, h, err := i.TileSize()
+		if err != nil {
+			return 0, err
+		}
+		pcfg := *cfg
+		pcfg.TileWidth, pcfg.TileHeight = w, h
+		cfg = &pcfg
+	}
+
+	prev := readPyramidState(dir)
+	state := &pyramidState{
+		TileWidth:  cfg.TileWidth,
+		TileHeight: cfg.TileHeight,
+		ChunkSize:  cfg.ChunkSize,
+		MinZoom:    cfg.MinZoom,
+		MaxZoom:    cfg.MaxZoom,
+		Render:     hashRenderConfig(rcfg),
+		Chunks:     map[string]string{},
+	}
+	if prev == nil || prev.TileWidth != state.TileWidth || prev.TileHeight != state.TileHeight ||
+		prev.ChunkSize != state.ChunkSize || prev.MinZoom != state.MinZoom || prev.MaxZoom != state.MaxZoom ||
+		prev.Render != state.Render {
+		prev = &pyramidState{Chunks: map[string]string{}}
+	}
+
+	// changes made from here on are picked up by the next run
+	var err error
+	if i.HistoryEnabled() {
+		state.Seq, err = i.storage.LastChange()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var dirty map[[2]int]bool
+	if prev.Seq > 0 && state.Seq >= prev.Seq {
+		dirty, err = i.loggedPyramidChunks(cfg, prev, state)
+	} else {
+		dirty, err = i.scanPyramidChunks(cfg, prev, state)
+	}
+	if err != nil {
+		return 0, err
+	}
+	for chunk := range missingPyramidChunks(dir, cfg, state) {
+		dirty[chunk] = true
+	}
+
+	written := 0
+	for chunk := range dirty {
+		cx, cy := chunk[0], chunk[1]
+		fname := pyramidPath(dir, cfg.MaxZoom, cx, cy)
+
+		_, ok := state.Chunks[fmt.Sprintf("%d,%d", cx, cy)]
+		if !ok {
+			os.Remove(fname)
+			continue
+		}
+
+		m, err := i.mapWithSize(cfg.TileWidth, cfg.TileHeight, cx*cfg.ChunkSize, cy*cfg.ChunkSize, (cx+1)*cfg.ChunkSize, (cy+1)*cfg.ChunkSize)
+		if err != nil {
+			return written, err
+		}
+		img, err := m.Render(rcfg)
+		if err != nil {
+			return written, err
+		}
+		err = writePyramidImage(fname, img)
+		if err != nil {
+			return written, err
+		}
+		written++
+	}
+
+	// each lower zoom level is made of the four images above it
+	for zoom := cfg.MaxZoom - 1; zoom >= cfg.MinZoom; zoom-- {
+		parents := map[[2]int]bool{}
+		for chunk := range dirty {
+			parents[[2]int{floorDiv(chunk[0], 2), floorDiv(chunk[1], 2)}] = true
+		}
+
+		for chunk := range parents {
+			n, err := downsampleChunk(dir, zoom, chunk[0], chunk[1])
+			if err != nil {
+				return written, err
+			}
+			written += n
+		}
+		dirty = parents
+	}
+
+	return written, writePyramidState(dir, state)
+}
+
+// scanPyramidChunks hashes every chunk (at the highest zoom level) with
+// tiles into `state` & returns those that differ from the last run
+func (i *InfiniteMap) scanPyramidChunks(cfg *PyramidConfig, prev, state *pyramidState) (map[[2]int]bool, error) {
+	dirty := map[[2]int]bool{}
+	x0, y0, x1, y1, ok, err := i.extent()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		for cy := floorDiv(y0, cfg.ChunkSize); cy <= floorDiv(y1, cfg.ChunkSize); cy++ {
+			for cx := floorDiv(x0, cfg.ChunkSize); cx <= floorDiv(x1, cfg.ChunkSize); cx++ {
+				changed, err := i.hashPyramidChunk(cfg, prev, state, cx, cy)
+				if err != nil {
+					return nil, err
+				}
+				if changed {
+					dirty[[2]int{cx, cy}] = true
+				}
+			}
+		}
+	}
+
+	for key := range prev.Chunks {
+		_, ok := state.Chunks[key]
+		if ok {
+			continue
+		}
+		// chunk has been emptied since the last run
+		var cx, cy int
+		fmt.Sscanf(key, "%d,%d", &cx, &cy)
+		dirty[[2]int{cx, cy}] = true
+	}
+	return dirty, nil
+}
+
+// loggedPyramidChunks rehashes only the chunks with tiles changed in the
+// change log since the last run & returns those that differ from it
+func (i *InfiniteMap) loggedPyramidChunks(cfg *PyramidConfig, prev, state *pyramidState) (map[[2]int]bool, error) {
+	changes, err := i.storage.Changes(prev.Seq, state.Seq)
+	if err != nil {
+		return nil, err
+	}
+	if hasGap(changes) {
+		// some changes weren't logged
+		return i.scanPyramidChunks(cfg, prev, state)
+	}
+	for key, hash := range prev.Chunks {
+		state.Chunks[key] = hash
+	}
+
+	touched := map[[2]int]bool{}
+	for _, c := range changes {
+		if c.Kind == ChangeTile {
+			touched[[2]int{floorDiv(c.X, cfg.ChunkSize), floorDiv(c.Y, cfg.ChunkSize)}] = true
+		}
+	}
+
+	dirty := map[[2]int]bool{}
+	for chunk := range touched {
+		changed, err := i.hashPyramidChunk(cfg, prev, state, chunk[0], chunk[1])
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			dirty[chunk] = true
+		}
+	}
+	return dirty, nil
+}
+
+// hashPyramidChunk sets (or removes, if empty) the hash of chunk (cx,cy) in
+// `state` & returns if it differs from the last run
+func (i *InfiniteMap) hashPyramidChunk(cfg *PyramidConfig, prev, state *pyramidState, cx, cy int) (bool, error) {
+	tiles, err := i.tiles(cx*cfg.ChunkSize, cy*cfg.ChunkSize, (cx+1)*cfg.ChunkSize, (cy+1)*cfg.ChunkSize)
+	if err != nil {
+		return false, err
+	}
+
+	key := fmt.Sprintf("%d,%d", cx, cy)
+	if len(tiles) == 0 {
+		delete(state.Chunks, key)
+	} else {
+		state.Chunks[key] = hashTiles(tiles)
+	}
+	return prev.Chunks[key] != state.Chunks[key], nil
+}
+
+// missingPyramidChunks returns the chunks (at the highest zoom level) in
+// `state` where it's image, or that of any zoom level below it, is missing
+func missingPyramidChunks(dir string, cfg *PyramidConfig, state *pyramidState) map[[2]int]bool {
+	missing := map[[2]int]bool{}
+	exists := map[string]bool{} // by path, as chunks share lower zoom images
+	for key := range state.Chunks {
+		var cx, cy int
+		fmt.Sscanf(key, "%d,%d", &cx, &cy)
+
+		x, y := cx, cy
+		for zoom := cfg.MaxZoom; zoom >= cfg.MinZoom; zoom-- {
+			fname := pyramidPath(dir, zoom, x, y)
+			found, ok := exists[fname]
+			if !ok {
+				_, err := os.Stat(fname)
+				found = err == nil
+				exists[fname] = found
+			}
+			if !found {
+				missing[[2]int{cx, cy}] = true
+				break
+			}
+			x, y = floorDiv(x, 2), floorDiv(y, 2)
+		}
+	}
+	return missing
+}
+
+// downsampleChunk builds the image at (zoom, x, y) out of the four images that
+// it covers in zoom+1. If none of them exist the image is removed.
+func downsampleChunk(dir string, zoom, x, y int) (int, error) {
+	var out *image.RGBA
+	for dy := 0; dy < 2; dy++ {
+		for dx := 0; dx < 2; dx++ {
+			child, err := readPyramidImage(pyramidPath(dir, zoom+1, 2*x+dx, 2*y+dy))
+			if err != nil {
+				return 0, err
+			}
+			if child == nil {
+				continue
+			}
+
+			size := child.Bounds().Size()
+			if out == nil {
+				out = image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+			}
+
+			half := resize.Resize(uint(size.X/2), uint(size.Y/2), child, resize.Bilinear)
+			px := dx * size.X / 2
+			py := dy * size.Y / 2
+			draw.Draw(out, image.Rect(px, py, px+size.X/2, py+size.Y/2), half, half.Bounds().Min, draw.Src)
+		}
+	}
+
+	fname := pyramidPath(dir, zoom, x, y)
+	if out == nil {
+		os.Remove(fname)
+		return 0, nil
+	}
+	return 1, writePyramidImage(fname, out)
+}
+
+// pyramidPath returns where the image for (zoom, x, y) lives
+func pyramidPath(dir string, zoom, x, y int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d", zoom), fmt.Sprintf("%d", x), fmt.Sprintf("%d.png", y))
+}
+
+// writePyramidImage writes a png, creating parent dirs as needed
+func writePyramidImage(fname string, img image.Image) error {
+	err := os.MkdirAll(filepath.Dir(fname), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, img)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readPyramidImage reads a png, returning nil if it does not exist
+func readPyramidImage(fname string) (image.Image, error) {
+	f, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// readPyramidState reads the manifest from a previous run (if any)
+func readPyramidState(dir string) *pyramidState {
+	data, err := ioutil.ReadFile(filepath.Join(dir, pyramidManifest))
+	if err != nil {
+		return nil
+	}
+	state := &pyramidState{}
+	if json.Unmarshal(data, state) != nil || state.Chunks == nil {
+		return nil
+	}
+	return state
+}
+
+// writePyramidState saves the manifest for the next run
+func writePyramidState(dir string, state *pyramidState) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, pyramidManifest), data, 0644)
+}
+
+// hashTiles returns a hash of the given tiles, which are expected to be
+// in a stable order.
+func hashTiles(in []Cell) string {
+	h := sha1.New()
+	for _, t := range in {
+		fmt.Fprintf(h, "%d,%d,%d,%s\n", t.X, t.Y, t.Z, t.Src)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashRenderConfig returns a hash of the render settings, so a pyramid is
+// fully re-rendered when they change. An FS is compared by it's type (&
+// path, for os.DirFS) as it's files can't be.
+func hashRenderConfig(cfg *RenderConfig) string {
+	fsys := ""
+	if cfg.FS != nil {
+		fsys = fmt.Sprintf("%T", cfg.FS)
+		if reflect.ValueOf(cfg.FS).Kind() == reflect.String {
+			fsys += fmt.Sprintf(" %v", cfg.FS)
+		}
+	}
+
+	var r, g, b, a uint32
+	if cfg.OverlayColor != nil {
+		r, g, b, a = cfg.OverlayColor.RGBA()
+	}
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%s\n%d,%d\n%g\n%t,%t\n%d,%d,%d,%d\n", fsys, cfg.Dir, cfg.ZMin, cfg.ZMax, cfg.Scale, cfg.Grid, cfg.Coords, r, g, b, a)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// floorDiv divides rounding towards negative infinity (so chunks of negative
+// coords line up with positive ones).
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
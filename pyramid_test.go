@@ -0,0 +1,127 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestWritePyramid(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+			dir := t.TempDir()
+
+			inf.Set(0, 0, 0, "red.png")
+			inf.Set(5, 3, 0, "red.png")
+
+			cfg := DefaultPyramidConfig()
+			cfg.TileWidth = 4
+			cfg.TileHeight = 4
+			cfg.ChunkSize = 2
+			cfg.MaxZoom = 2
+			cfg.Render.FS = fstest.MapFS{
+				"red.png": &fstest.MapFile{Data: solidPNG(4, 4, color.RGBA{255, 0, 0, 255})},
+			}
+			out := filepath.Join(dir, "out")
+
+			n, err := inf.WritePyramid(out, cfg)
+
+			assert.Nil(t, err)
+			assert.Equal(t, 5, n) // 2 chunks at zoom 2, 2 at zoom 1, 1 at zoom 0
+			for _, f := range []string{"2/0/0.png", "2/2/1.png", "1/0/0.png", "1/1/0.png", "0/0/0.png"} {
+				_, err = os.Stat(filepath.Join(out, f))
+				assert.Nil(t, err, f)
+			}
+
+			// nothing has changed, so nothing should be written
+			n, err = inf.WritePyramid(out, cfg)
+
+			assert.Nil(t, err)
+			assert.Equal(t, 0, n)
+
+			// changing one chunk re-renders it & the images below it
+			inf.Set(4, 2, 1, "red.png")
+			n, err = inf.WritePyramid(out, cfg)
+
+			assert.Nil(t, err)
+			assert.Equal(t, 3, n)
+
+			// missing images are re-rendered, along with those below them
+			assert.Nil(t, os.Remove(filepath.Join(out, "1/1/0.png")))
+			n, err = inf.WritePyramid(out, cfg)
+			assert.Nil(t, err)
+			assert.Equal(t, 3, n)
+			_, err = os.Stat(filepath.Join(out, "1/1/0.png"))
+			assert.Nil(t, err)
+
+			// as is everything when the render config changes
+			cfg.Render.Scale = 2
+			n, err = inf.WritePyramid(out, cfg)
+			assert.Nil(t, err)
+			assert.Equal(t, 5, n)
+			img, err := readPyramidImage(filepath.Join(out, "0/0/0.png"))
+			assert.Nil(t, err)
+			assert.Equal(t, 16, img.Bounds().Dx()) // 2 tiles * 4px * 2 scale
+
+			n, err = inf.WritePyramid(out, cfg)
+			assert.Nil(t, err)
+			assert.Equal(t, 0, n)
+		})
+	}
+}
+
+// countingStorage counts calls to Tiles
+type countingStorage struct {
+	Storage
+	tiles int
+}
+
+// Tiles implements Store
+func (c *countingStorage) Tiles(x0, y0, x1, y1 int) ([]Cell, error) {
+	c.tiles++
+	return c.Storage.Tiles(x0, y0, x1, y1)
+}
+
+func TestWritePyramidFromHistory(t *testing.T) {
+	s := &countingStorage{Storage: NewMemoryStorage()}
+	inf := NewInfiniteMapWithStorage(s)
+	assert.Nil(t, inf.EnableHistory(true))
+
+	inf.Set(0, 0, 0, "red.png")
+	inf.Set(5, 3, 0, "red.png")
+
+	cfg := DefaultPyramidConfig()
+	cfg.TileWidth = 4
+	cfg.TileHeight = 4
+	cfg.ChunkSize = 2
+	cfg.MaxZoom = 2
+	cfg.Render.FS = fstest.MapFS{
+		"red.png": &fstest.MapFile{Data: solidPNG(4, 4, color.RGBA{255, 0, 0, 255})},
+	}
+	out := t.TempDir()
+
+	n, err := inf.WritePyramid(out, cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+
+	// only the changed chunk is read (to hash it, then to render it)
+	s.tiles = 0
+	inf.Set(4, 2, 1, "red.png")
+	n, err = inf.WritePyramid(out, cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 2, s.tiles)
+
+	// emptied chunks are removed
+	inf.Set(0, 0, 0, "")
+	n, err = inf.WritePyramid(out, cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n) // zoom 1's image of it is removed, zoom 0 is redrawn
+	_, err = os.Stat(filepath.Join(out, "2/0/0.png"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,237 @@
+/* file renders tile maps into flat (composited) images.
+ */
+package tile
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/fogleman/gg"
+	"github.com/nfnt/resize"
+)
+
+// RenderConfig includes settings for rendering a Map to an image
+type RenderConfig struct {
+	// FS to load tile images from. If neither FS or Dir are set images are
+	// loaded as by Map.Image, relative to where the map was read from.
+	FS fs.FS
+
+	// Dir on disk that relative tile image sources are relative to.
+	// Ignored if FS is set.
+	Dir string
+
+	// only z-levels in the range ZMin -> ZMax (inclusive) are drawn
+	ZMin int
+	ZMax int
+
+	// Scale the final image by some factor (1 is no scaling)
+	Scale float64
+
+	// Grid draws lines around each tile
+	Grid bool
+
+	// Coords writes the (x,y) coordinates of each tile
+	Coords bool
+
+	// colour of grid lines & coordinate text
+	OverlayColor color.Color
+}
+
+// DefaultRenderConfig returns a config that renders all z-levels at
+// full size, without any overlays.
+func DefaultRenderConfig() *RenderConfig {
+	return &RenderConfig{
+		ZMin:         math.MinInt32,
+		ZMax:         math.MaxInt32,
+		Scale:        1,
+		OverlayColor: color.RGBA{255, 0, 0, 160},
+	}
+}
+
+// Render draws the map as one image. The background image layer (if set) is
+// drawn first, then each z-level low -> high.
+func (m *Map) Render(cfg *RenderConfig) (image.Image, error) {
+	if cfg == nil {
+		cfg = DefaultRenderConfig()
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, m.Width*m.TileWidth, m.Height*m.TileHeight))
+	loader := m.renderImages(cfg)
+
+	for _, l := range m.ImageLayers {
+		if l.Name != "background" || l.Image == nil || l.Image.Source == "" {
+			continue
+		}
+		bg, err := loader.load(l.Image.Source)
+		if err != nil {
+			return nil, err
+		}
+		if l.Image.Width > 0 && l.Image.Height > 0 {
+			bg = resize.Resize(uint(l.Image.Width), uint(l.Image.Height), bg, resize.Bilinear)
+		}
+		draw.Draw(out, out.Bounds().Add(image.Pt(l.OffsetX, l.OffsetY)), bg, bg.Bounds().Min, draw.Over)
+	}
+
+	for _, z := range m.ZLevels() {
+		if z < cfg.ZMin || z > cfg.ZMax {
+			continue
+		}
+		for y := 0; y < m.Height; y++ {
+			for x := 0; x < m.Width; x++ {
+				img, err := m.tileImage(loader, x, y, z)
+				if err != nil {
+					return nil, err
+				}
+				if img == nil {
+					continue
+				}
+
+				// like Tiled we align tile images by their bottom left
+				// corner, so oversized images stretch up & right
+				size := img.Bounds().Size()
+				px := x * m.TileWidth
+				py := (y+1)*m.TileHeight - size.Y
+				draw.Draw(out, image.Rect(px, py, px+size.X, py+size.Y), img, img.Bounds().Min, draw.Over)
+			}
+		}
+	}
+
+	var final image.Image = out
+	if cfg.Scale > 0 && cfg.Scale != 1 {
+		final = resize.Resize(
+			uint(float64(out.Bounds().Dx())*cfg.Scale),
+			uint(float64(out.Bounds().Dy())*cfg.Scale),
+			out,
+			resize.Bilinear,
+		)
+	}
+
+	if cfg.Grid || cfg.Coords {
+		final = m.drawOverlay(final, cfg)
+	}
+
+	return final, nil
+}
+
+// tileImage returns the image of the tile at (x,y,z), or nil if it's unset.
+// Tiles of single image tilesets are cut out of the tileset's image.
+func (m *Map) tileImage(loader *imageLoader, x, y, z int) (image.Image, error) {
+	src, _ := m.At(x, y, z)
+	if src != "" {
+		return loader.load(src)
+	}
+
+	l := m.layer(z)
+	if l == nil {
+		return nil, nil
+	}
+	gid := l.decodedTiles.get(y*m.Width + x)
+	if gid == 0 {
+		return nil, nil
+	}
+	ts, _ := m.imageTileset(gid)
+	if ts == nil {
+		return nil, nil
+	}
+
+	sheet, err := loader.load(ts.Image.Source)
+	if err != nil {
+		return nil, err
+	}
+	sub, ok := sheet.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return nil, fmt.Errorf("unable to cut tiles from tileset image %s", ts.Image.Source)
+	}
+	return sub.SubImage(ts.sheetRect(gid&^gidFlipFlags-ts.FirstGID, sheet.Bounds())), nil
+}
+
+// renderImages returns the loader for tile images set by the config, if
+// any, otherwise the map's own
+func (m *Map) renderImages(cfg *RenderConfig) *imageLoader {
+	if cfg.FS != nil {
+		return newImageLoader(cfg.FS, ".")
+	}
+	if cfg.Dir != "" {
+		return newImageLoader(osFS{}, filepath.ToSlash(cfg.Dir))
+	}
+	return m.imageLoader()
+}
+
+// EncodePNG renders the map & writes it as a PNG to the given io.Writer stream
+func (m *Map) EncodePNG(w io.Writer, cfg *RenderConfig) error {
+	img, err := m.Render(cfg)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
+
+// WritePNG renders the map & writes it as a PNG to the given file
+func (m *Map) WritePNG(fname string, cfg *RenderConfig) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+
+	err = m.EncodePNG(f, cfg)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// drawOverlay adds grid lines and / or tile coords over the top of a rendered
+// map image. We do this after scaling so lines & text stay crisp.
+func (m *Map) drawOverlay(in image.Image, cfg *RenderConfig) image.Image {
+	rgba, ok := in.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(in.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), in, in.Bounds().Min, draw.Src)
+	}
+
+	scale := cfg.Scale
+	if scale <= 0 {
+		scale = 1
+	}
+	tw := float64(m.TileWidth) * scale
+	th := float64(m.TileHeight) * scale
+	w := float64(rgba.Bounds().Dx())
+	h := float64(rgba.Bounds().Dy())
+
+	ctx := gg.NewContextForRGBA(rgba)
+	ctx.SetColor(cfg.OverlayColor)
+	ctx.SetLineWidth(1)
+
+	if cfg.Grid {
+		for x := 0; x <= m.Width; x++ {
+			ctx.DrawLine(float64(x)*tw, 0, float64(x)*tw, h)
+		}
+		for y := 0; y <= m.Height; y++ {
+			ctx.DrawLine(0, float64(y)*th, w, float64(y)*th)
+		}
+		ctx.Stroke()
+	}
+
+	if cfg.Coords {
+		for y := 0; y < m.Height; y++ {
+			for x := 0; x < m.Width; x++ {
+				ctx.DrawString(fmt.Sprintf("%d,%d", x, y), float64(x)*tw+2, float64(y)*th+12)
+			}
+		}
+	}
+
+	return rgba
+}
@@ -0,0 +1,74 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"testing/fstest"
+)
+
+// solidPNG returns an encoded png of the given size & colour
+func solidPNG(w, h int, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	buf := bytes.Buffer{}
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func TestRender(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	fsys := fstest.MapFS{
+		"red.png":  &fstest.MapFile{Data: solidPNG(4, 4, red)},
+		"blue.png": &fstest.MapFile{Data: solidPNG(4, 4, blue)},
+	}
+
+	m := New(&Config{MapWidth: 2, MapHeight: 2, TileWidth: 4, TileHeight: 4})
+	m.Set(0, 0, 0, "red.png")
+	m.Set(1, 1, 0, "red.png")
+	m.Set(1, 1, 10, "blue.png")
+
+	cfg := DefaultRenderConfig()
+	cfg.FS = fsys
+
+	img, err := m.Render(cfg)
+
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 8, 8), img.Bounds())
+	assert.Equal(t, red, img.At(1, 1))
+	assert.Equal(t, color.RGBA{}, img.At(5, 1))
+	assert.Equal(t, blue, img.At(5, 5)) // z 10 drawn over z 0
+
+	cfg.ZMax = 5
+	img, err = m.Render(cfg)
+
+	assert.Nil(t, err)
+	assert.Equal(t, red, img.At(5, 5))
+
+	cfg.Scale = 0.5
+	img, err = m.Render(cfg)
+
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 4, 4), img.Bounds())
+}
+
+func TestRenderMissingImage(t *testing.T) {
+	m := New(&Config{MapWidth: 2, MapHeight: 2, TileWidth: 4, TileHeight: 4})
+	m.Set(0, 0, 0, "missing.png")
+
+	cfg := DefaultRenderConfig()
+	cfg.FS = fstest.MapFS{}
+
+	_, err := m.Render(cfg)
+
+	assert.NotNil(t, err)
+}
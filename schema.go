@@ -0,0 +1,123 @@
+//go:build cgo
+// +build cgo
+
+/* file handles creating & migrating the sqlite storage database schema.
+ */
+package tile
+
+import (
+	"fmt"
+)
+
+// migrations holds the SQL to move the database from one schema version to the
+// next, where migrations[i] moves from version i to i+1.
+// The schema version is kept in sqlite's `user_version` pragma.
+var migrations = []string{
+	// v0 -> v1
+	// Version 0 either has no tables (a new database) or our original schema
+	// where tiles are keyed by a text id "x-y-z" with no index on (x,y,z).
+	// We move to keying tiles on (x,y,z) directly with extra indexes for
+	// range queries on y & z.
+	`CREATE TABLE IF NOT EXISTS tiles(
+		id TEXT PRIMARY KEY,
+		x INTEGER NOT NULL,
+		y INTEGER NOT NULL,
+		z INTEGER NOT NULL,
+		src TEXT NOT NULL
+	);
+	CREATE TABLE tiles_v1(
+		x INTEGER NOT NULL,
+		y INTEGER NOT NULL,
+		z INTEGER NOT NULL,
+		src TEXT NOT NULL,
+		PRIMARY KEY (x, y, z)
+	) WITHOUT ROWID;
+	INSERT OR REPLACE INTO tiles_v1 (x, y, z, src) SELECT x, y, z, src FROM tiles;
+	DROP TABLE tiles;
+	ALTER TABLE tiles_v1 RENAME TO tiles;
+	CREATE INDEX tiles_yx ON tiles (y, x);
+	CREATE INDEX tiles_z ON tiles (z);
+	CREATE TABLE IF NOT EXISTS properties(
+		src TEXT PRIMARY KEY,
+		data TEXT
+	);`,
+
+	// v1 -> v2
+	// Adds map level metadata (tile size, map properties etc) as key / values.
+	`CREATE TABLE metadata(
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	) WITHOUT ROWID;`,
+
+	// v2 -> v3
+	// Adds the change log used for history, snapshots & restoring.
+	`CREATE TABLE changes(
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		time INTEGER NOT NULL,
+		tag TEXT NOT NULL,
+		kind INTEGER NOT NULL,
+		x INTEGER NOT NULL,
+		y INTEGER NOT NULL,
+		z INTEGER NOT NULL,
+		src TEXT NOT NULL,
+		old TEXT NOT NULL,
+		new TEXT NOT NULL
+	);`,
+}
+
+// SchemaVersion returns the version of the database schema.
+func (s *SQLiteStorage) SchemaVersion() (int, error) {
+	version := 0
+	err := s.db.Get(&version, "PRAGMA user_version;")
+	return version, err
+}
+
+// checkSchema returns an error if the database schema isn't up to date
+func (s *SQLiteStorage) checkSchema() error {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version != len(migrations) {
+		return fmt.Errorf("database schema version %d needs migrating to %d, open it writable first", version, len(migrations))
+	}
+	return nil
+}
+
+// migrate brings the database schema up to date, creating tables if needed.
+// Each migration is run in it's own transaction.
+func (s *SQLiteStorage) migrate() error {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, len(migrations))
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := s.db.Beginx()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(migrations[version])
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to migrate database schema from version %d: %w", version, err)
+		}
+
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", version+1))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
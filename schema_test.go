@@ -0,0 +1,71 @@
+//go:build cgo
+// +build cgo
+
+package tile
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateLegacySchema(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "legacy.sqlite")
+
+	// build a database with the original (version 0) schema
+	db, err := sqlx.Open("sqlite3", fname)
+	assert.Nil(t, err)
+	_, err = db.Exec(`CREATE TABLE tiles(
+		id TEXT PRIMARY KEY,
+		x INTEGER NOT NULL,
+		y INTEGER NOT NULL,
+		z INTEGER NOT NULL,
+		src TEXT NOT NULL
+	);
+	CREATE TABLE properties(src TEXT PRIMARY KEY, data TEXT);
+	INSERT INTO tiles (id, x, y, z, src) VALUES ('1-2-3', 1, 2, 3, 'grass.png');
+	INSERT INTO properties (src, data) VALUES ('grass.png', '{"I":{"one":1}}');`)
+	assert.Nil(t, err)
+	db.Close()
+
+	inf, err := OpenInfiniteMap(fname)
+
+	require.NoError(t, err)
+	version, err := inf.Storage().(*SQLiteStorage).SchemaVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	src, err := inf.At(1, 2, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "grass.png", src)
+
+	props, err := inf.Properties("grass.png")
+	assert.Nil(t, err)
+	one, _ := props.Int("one")
+	assert.Equal(t, 1, one)
+
+	// tiles are now keyed on (x,y,z)
+	assert.Nil(t, inf.Set(1, 2, 3, "mushroom.png"))
+	st, err := inf.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, st.Tiles)
+
+	// re-opening doesn't re-run migrations
+	inf, err = OpenInfiniteMap(fname)
+	require.NoError(t, err)
+	src, _ = inf.At(1, 2, 3)
+	assert.Equal(t, "mushroom.png", src)
+}
+
+func TestSQLiteJournalMode(t *testing.T) {
+	s, err := OpenSQLiteStorage(filepath.Join(t.TempDir(), "wal.sqlite"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	mode := ""
+	assert.Nil(t, s.db.Get(&mode, "PRAGMA journal_mode;"))
+	assert.Equal(t, "wal", mode)
+}
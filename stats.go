@@ -0,0 +1,75 @@
+/* file adds helpers for describing what is in an infinite map.
+ */
+package tile
+
+import (
+	"fmt"
+)
+
+// Bounds is a box (inclusive of both min & max) in tiles
+type Bounds struct {
+	MinX int
+	MinY int
+	MinZ int
+	MaxX int
+	MaxY int
+	MaxZ int
+}
+
+// Stats holds counts of what is set in an infinite map
+type Stats struct {
+	// Tiles is the total number of set tiles
+	Tiles int
+
+	// number of set tiles on each z-level
+	TilesByZ map[int]int
+
+	// number of set tiles using each src
+	TilesBySrc map[string]int
+
+	// number of set tiles with each property value,
+	// by property name => value => count
+	Properties map[string]map[string]int
+}
+
+// Stats counts the tiles in the map by z-level, src & property values.
+func (i *InfiniteMap) Stats() (*Stats, error) {
+	st := &Stats{
+		TilesByZ:   map[int]int{},
+		TilesBySrc: map[string]int{},
+		Properties: map[string]map[string]int{},
+	}
+
+	byZ, bySrc, err := i.storage.Counts()
+	if err != nil {
+		return nil, err
+	}
+	for z, num := range byZ {
+		st.TilesByZ[z] = num
+		st.Tiles += num
+	}
+
+	srcs := make([]string, 0, len(bySrc))
+	for src, num := range bySrc {
+		st.TilesBySrc[src] = num
+		srcs = append(srcs, src)
+	}
+
+	props, err := i.storage.Properties(srcs...)
+	if err != nil {
+		return nil, err
+	}
+	for src, p := range props {
+		num := st.TilesBySrc[src]
+		p.each(func(key string, value interface{}) {
+			values, ok := st.Properties[key]
+			if !ok {
+				values = map[string]int{}
+				st.Properties[key] = values
+			}
+			values[fmt.Sprintf("%v", value)] += num
+		})
+	}
+
+	return st, nil
+}
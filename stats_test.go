@@ -0,0 +1,44 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+
+			b, err := inf.Bounds()
+			assert.Nil(t, err)
+			assert.Nil(t, b)
+
+			inf.Set(-2, 3, 0, "grass.png")
+			inf.Set(4, -1, 0, "grass.png")
+			inf.Set(1, 1, 20, "mushroom.png")
+			props := NewProperties()
+			props.SetString("biome", "forest")
+			inf.SetProperties("grass.png", props)
+
+			b, err = inf.Bounds()
+
+			assert.Nil(t, err)
+			assert.Equal(t, &Bounds{MinX: -2, MinY: -1, MinZ: 0, MaxX: 4, MaxY: 3, MaxZ: 20}, b)
+
+			levels, err := inf.ZLevels()
+
+			assert.Nil(t, err)
+			assert.Equal(t, []int{0, 20}, levels)
+
+			st, err := inf.Stats()
+
+			assert.Nil(t, err)
+			assert.Equal(t, 3, st.Tiles)
+			assert.Equal(t, map[int]int{0: 2, 20: 1}, st.TilesByZ)
+			assert.Equal(t, map[string]int{"grass.png": 2, "mushroom.png": 1}, st.TilesBySrc)
+			assert.Equal(t, map[string]map[string]int{"biome": {"forest": 2}}, st.Properties)
+		})
+	}
+}
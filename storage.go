@@ -0,0 +1,261 @@
+/* file defines where an InfiniteMap keeps it's data.
+ */
+package tile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Cell is a single tile src set at (x,y,z)
+type Cell struct {
+	X   int    `db:"x"`
+	Y   int    `db:"y"`
+	Z   int    `db:"z"`
+	Src string `db:"src"`
+}
+
+// ChangeKind is what a Change altered
+type ChangeKind int
+
+const (
+	// ChangeTile is a tile set (or removed) at (x,y,z)
+	ChangeTile ChangeKind = iota
+
+	// ChangeProperties is the properties of a src being overwritten
+	ChangeProperties
+
+	// ChangeGap marks history being turned off, changes made until it was
+	// turned back on weren't logged
+	ChangeGap
+)
+
+// Change is a single logged write to an InfiniteMap (see InfiniteMap.EnableHistory).
+type Change struct {
+	// Seq is set by the store & increases with each logged change
+	Seq  int64
+	Time time.Time
+	Tag  string
+	Kind ChangeKind
+
+	// where a tile changed (ChangeTile only)
+	X, Y, Z int
+
+	// the src whose properties changed (ChangeProperties only)
+	Src string
+
+	// Old & New are tile srcs ("" for no tile) for ChangeTile, or
+	// encoded properties ("" for none set) for ChangeProperties
+	Old, New string
+}
+
+// Store holds tiles & properties for an InfiniteMap.
+type Store interface {
+	// Tile returns the src at (x,y,z) or "" if unset
+	Tile(x, y, z int) (string, error)
+
+	// Tiles returns all set tiles in the rectangle (x0,y0,x1,y1)
+	// ordered by (x,y,z)
+	Tiles(x0, y0, x1, y1 int) ([]Cell, error)
+
+	// SetTiles writes the given tiles. Tiles with src "" are removed.
+	SetTiles(cells ...Cell) error
+
+	// Properties returns set properties by their src name.
+	// Srcs with no properties are not included.
+	Properties(srcs ...string) (map[string]*Properties, error)
+
+	// SetProperties overwrites the properties of the given srcs
+	SetProperties(props map[string]*Properties) error
+
+	// Bounds returns the smallest box that includes every set tile or
+	// nil if no tiles are set
+	Bounds() (*Bounds, error)
+
+	// Counts returns the number of set tiles by z-level & by src
+	Counts() (byZ map[int]int, bySrc map[string]int, err error)
+
+	// ZLevels returns all z-levels with at least one tile set, sorted low -> high
+	ZLevels() ([]int, error)
+
+	// Metadata returns all map level metadata with keys starting with `prefix`
+	Metadata(prefix string) (map[string]string, error)
+
+	// SetMetadata writes the given metadata. Keys with value "" are removed.
+	SetMetadata(meta map[string]string) error
+
+	// LogChanges appends changes to the change log, each is given the next Seq
+	LogChanges(changes ...Change) error
+
+	// Changes returns logged changes with from < Seq <= to, ordered by Seq
+	Changes(from, to int64) ([]Change, error)
+
+	// LastChange returns the Seq of the latest logged change, or 0 if there are none
+	LastChange() (int64, error)
+}
+
+// Storage is a Store that supports transactions.
+type Storage interface {
+	Store
+
+	// Begin starts a new transaction
+	Begin() (StorageTx, error)
+
+	// Close releases any resources (open files etc) held by the storage
+	Close() error
+}
+
+// StorageTx is a Store where changes are written together on Commit
+// (or not at all on Rollback).
+type StorageTx interface {
+	Store
+
+	// Commit writes all changes made in the transaction
+	Commit() error
+
+	// Rollback discards all changes made in the transaction
+	Rollback() error
+}
+
+// prepareFile checks the database file `fname` against the open config,
+// creating it if CreateOnly is set.
+func prepareFile(fname string, cfg *OpenConfig) error {
+	if cfg.CreateOnly {
+		if cfg.ReadOnly || cfg.MustExist {
+			return fmt.Errorf("create only can't be used with read only or must exist")
+		}
+		f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	if cfg.ReadOnly || cfg.MustExist {
+		info, err := os.Stat(fname)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", fname)
+		}
+	}
+
+	return nil
+}
+
+// propertiesBlock is how properties are encoded by storage implementations
+type propertiesBlock struct {
+	I map[string]int
+	S map[string]string
+	B map[string]bool
+}
+
+// encodeProperties into JSON for storage
+func encodeProperties(props *Properties) []byte {
+	data, _ := json.Marshal(propertiesBlock{
+		props.ints,
+		props.strings,
+		props.bools,
+	})
+	return data
+}
+
+// decodeProperties from JSON written by encodeProperties
+func decodeProperties(data []byte) (*Properties, error) {
+	block := propertiesBlock{}
+	err := json.Unmarshal(data, &block)
+	if err != nil {
+		return nil, err
+	}
+
+	props := NewProperties()
+	if block.I != nil {
+		props.ints = block.I
+	}
+	if block.S != nil {
+		props.strings = block.S
+	}
+	if block.B != nil {
+		props.bools = block.B
+	}
+	return props, nil
+}
+
+// sortCells orders cells by (x,y,z)
+func sortCells(in []Cell) {
+	sort.Slice(in, func(i, j int) bool {
+		if in[i].X != in[j].X {
+			return in[i].X < in[j].X
+		}
+		if in[i].Y != in[j].Y {
+			return in[i].Y < in[j].Y
+		}
+		return in[i].Z < in[j].Z
+	})
+}
+
+// zLevels returns the distinct z-levels of the cells `each` calls it's
+// function with, sorted low -> high
+func zLevels(each func(fn func(c Cell))) []int {
+	seen := map[int]bool{}
+	levels := []int{}
+	each(func(c Cell) {
+		if !seen[c.Z] {
+			seen[c.Z] = true
+			levels = append(levels, c.Z)
+		}
+	})
+	sort.Ints(levels)
+	return levels
+}
+
+// boundsBuilder works out the Bounds of a set of cells
+type boundsBuilder struct {
+	b   Bounds
+	any bool
+}
+
+// newBoundsBuilder returns a builder with no cells added
+func newBoundsBuilder() *boundsBuilder {
+	return &boundsBuilder{}
+}
+
+// add a cell, growing the bounds if needed
+func (b *boundsBuilder) add(c Cell) {
+	if !b.any {
+		b.b = Bounds{MinX: c.X, MinY: c.Y, MinZ: c.Z, MaxX: c.X, MaxY: c.Y, MaxZ: c.Z}
+		b.any = true
+		return
+	}
+	if c.X < b.b.MinX {
+		b.b.MinX = c.X
+	}
+	if c.Y < b.b.MinY {
+		b.b.MinY = c.Y
+	}
+	if c.Z < b.b.MinZ {
+		b.b.MinZ = c.Z
+	}
+	if c.X > b.b.MaxX {
+		b.b.MaxX = c.X
+	}
+	if c.Y > b.b.MaxY {
+		b.b.MaxY = c.Y
+	}
+	if c.Z > b.b.MaxZ {
+		b.b.MaxZ = c.Z
+	}
+}
+
+// result returns the final bounds or nil if no cells were added
+func (b *boundsBuilder) result() *Bounds {
+	if !b.any {
+		return nil
+	}
+	out := b.b
+	return &out
+}
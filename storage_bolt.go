@@ -0,0 +1,398 @@
+package tile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltTiles = []byte("tiles")
+	boltProps = []byte("properties")
+	boltMeta  = []byte("metadata")
+	boltLog   = []byte("changes")
+)
+
+// BoltStorage keeps infinite map data in a bolt (bbolt) key value database file.
+// Unlike SQLiteStorage this is pure Go & doesn't require cgo.
+//
+// Tiles are keyed by (x,y,z) so reading a rectangle of tiles is fast, but
+// Bounds, Counts & ZLevels need to read every tile.
+type BoltStorage struct {
+	boltStore
+	filename string
+	db       *bolt.DB
+}
+
+// OpenBoltStorage given it's filename (database file) on disk.
+// Will create if it doesn't exist.
+func OpenBoltStorage(fname string) (*BoltStorage, error) {
+	return OpenBoltStorageWithConfig(fname, nil)
+}
+
+// OpenBoltStorageWithConfig opens the database file with the given settings.
+// Bolt allows only one process to have a file open for writing; others wait
+// up to cfg.BusyTimeout.
+func OpenBoltStorageWithConfig(fname string, cfg *OpenConfig) (*BoltStorage, error) {
+	if cfg == nil {
+		cfg = DefaultOpenConfig()
+	}
+
+	err := prepareFile(fname, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(fname, 0644, &bolt.Options{ReadOnly: cfg.ReadOnly, Timeout: cfg.BusyTimeout})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ReadOnly {
+		err = db.View(func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{boltTiles, boltProps, boltMeta, boltLog} {
+				if tx.Bucket(name) == nil {
+					return fmt.Errorf("bucket %s not found, open it writable first", name)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return &BoltStorage{boltStore: boltStore{db: db}, filename: fname, db: db}, nil
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltTiles, boltProps, boltMeta, boltLog} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStorage{boltStore: boltStore{db: db}, filename: fname, db: db}, nil
+}
+
+// Filename returns the path to the database file on disk
+func (s *BoltStorage) Filename() string {
+	return s.filename
+}
+
+// Begin starts a new transaction.
+// Bolt allows only one writable transaction at a time.
+func (s *BoltStorage) Begin() (StorageTx, error) {
+	tx, err := s.db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	return &boltTx{boltStore: boltStore{tx: tx}, tx: tx}, nil
+}
+
+// Close the database
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+// boltTx is a transaction on a BoltStorage
+type boltTx struct {
+	boltStore
+	tx *bolt.Tx
+}
+
+// Commit writes all changes made in the transaction
+func (t *boltTx) Commit() error {
+	return t.tx.Commit()
+}
+
+// Rollback discards all changes made in the transaction
+func (t *boltTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// boltStore implements Store either in an open transaction (tx) or by
+// opening a new transaction for each call (db)
+type boltStore struct {
+	db *bolt.DB
+	tx *bolt.Tx
+}
+
+// view calls `fn` with a transaction we can read from
+func (s *boltStore) view(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.View(fn)
+}
+
+// update calls `fn` with a transaction we can write to
+func (s *boltStore) update(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.Update(fn)
+}
+
+// Tile returns the src at (x,y,z) or "" if unset
+func (s *boltStore) Tile(x, y, z int) (string, error) {
+	src := ""
+	err := s.view(func(tx *bolt.Tx) error {
+		src = string(tx.Bucket(boltTiles).Get(boltKey(x, y, z)))
+		return nil
+	})
+	return src, err
+}
+
+// Tiles returns all set tiles in the rectangle (x0,y0,x1,y1) ordered by (x,y,z)
+func (s *boltStore) Tiles(x0, y0, x1, y1 int) ([]Cell, error) {
+	result := []Cell{}
+	err := s.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltTiles).Cursor()
+
+		k, v := c.Seek(boltKey(x0, y0, math.MinInt))
+		for k != nil {
+			x, y, z := boltKeyDecode(k)
+			if x >= x1 {
+				break
+			} else if y < y0 {
+				k, v = c.Seek(boltKey(x, y0, math.MinInt))
+				continue
+			} else if y >= y1 {
+				if x == math.MaxInt {
+					break
+				}
+				k, v = c.Seek(boltKey(x+1, y0, math.MinInt))
+				continue
+			}
+
+			result = append(result, Cell{X: x, Y: y, Z: z, Src: string(v)})
+			k, v = c.Next()
+		}
+		return nil
+	})
+	return result, err
+}
+
+// SetTiles writes the given tiles. Tiles with src "" are removed.
+func (s *boltStore) SetTiles(cells ...Cell) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltTiles)
+		for _, c := range cells {
+			var err error
+			if c.Src == "" {
+				err = b.Delete(boltKey(c.X, c.Y, c.Z))
+			} else {
+				err = b.Put(boltKey(c.X, c.Y, c.Z), []byte(c.Src))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Properties returns set properties by their src name
+func (s *boltStore) Properties(srcs ...string) (map[string]*Properties, error) {
+	result := map[string]*Properties{}
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltProps)
+		for _, src := range srcs {
+			data := b.Get([]byte(src))
+			if data == nil {
+				continue
+			}
+			props, err := decodeProperties(data)
+			if err != nil {
+				return err
+			}
+			result[src] = props
+		}
+		return nil
+	})
+	return result, err
+}
+
+// SetProperties overwrites the properties of the given srcs
+func (s *boltStore) SetProperties(props map[string]*Properties) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltProps)
+		for src, p := range props {
+			err := b.Put([]byte(src), encodeProperties(p))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Bounds returns the smallest box that includes every set tile.
+// If no tiles are set nil is returned.
+func (s *boltStore) Bounds() (*Bounds, error) {
+	bnds := newBoundsBuilder()
+	err := s.each(bnds.add)
+	return bnds.result(), err
+}
+
+// Counts returns the number of set tiles by z-level & by src
+func (s *boltStore) Counts() (map[int]int, map[string]int, error) {
+	byZ, bySrc := map[int]int{}, map[string]int{}
+	err := s.each(func(c Cell) {
+		byZ[c.Z]++
+		bySrc[c.Src]++
+	})
+	return byZ, bySrc, err
+}
+
+// ZLevels returns all z-levels with at least one tile set, sorted low -> high
+func (s *boltStore) ZLevels() ([]int, error) {
+	var err error
+	levels := zLevels(func(fn func(c Cell)) {
+		err = s.each(fn)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// Metadata returns all map level metadata with keys starting with `prefix`
+func (s *boltStore) Metadata(prefix string) (map[string]string, error) {
+	result := map[string]string{}
+	err := s.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltMeta).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			result[string(k)] = string(v)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// SetMetadata writes the given metadata. Keys with value "" are removed.
+func (s *boltStore) SetMetadata(meta map[string]string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltMeta)
+		for key, value := range meta {
+			var err error
+			if value == "" {
+				err = b.Delete([]byte(key))
+			} else {
+				err = b.Put([]byte(key), []byte(value))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LogChanges appends changes to the change log, each is given the next Seq
+func (s *boltStore) LogChanges(changes ...Change) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltLog)
+		for _, c := range changes {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			c.Seq = int64(seq)
+
+			data, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			err = b.Put(boltSeqKey(c.Seq), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Changes returns logged changes with from < Seq <= to, ordered by Seq
+func (s *boltStore) Changes(from, to int64) ([]Change, error) {
+	result := []Change{}
+	if from < 0 {
+		from = 0
+	}
+	err := s.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltLog).Cursor()
+		for k, v := c.Seek(boltSeqKey(from + 1)); k != nil; k, v = c.Next() {
+			if int64(binary.BigEndian.Uint64(k)) > to {
+				break
+			}
+			change := Change{}
+			err := json.Unmarshal(v, &change)
+			if err != nil {
+				return err
+			}
+			result = append(result, change)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// LastChange returns the Seq of the latest logged change, or 0 if there are none
+func (s *boltStore) LastChange() (int64, error) {
+	var last int64
+	err := s.view(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(boltLog).Cursor().Last()
+		if k != nil {
+			last = int64(binary.BigEndian.Uint64(k))
+		}
+		return nil
+	})
+	return last, err
+}
+
+// each calls `fn` for every set tile
+func (s *boltStore) each(fn func(c Cell)) error {
+	return s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltTiles).ForEach(func(k, v []byte) error {
+			x, y, z := boltKeyDecode(k)
+			fn(Cell{X: x, Y: y, Z: z, Src: string(v)})
+			return nil
+		})
+	})
+}
+
+// boltKey encodes (x,y,z) so that keys sort by x, then y, then z.
+// We flip the sign bit of each value so negative numbers sort before positive.
+func boltKey(x, y, z int) []byte {
+	k := make([]byte, 24)
+	binary.BigEndian.PutUint64(k[0:8], uint64(int64(x))^(1<<63))
+	binary.BigEndian.PutUint64(k[8:16], uint64(int64(y))^(1<<63))
+	binary.BigEndian.PutUint64(k[16:24], uint64(int64(z))^(1<<63))
+	return k
+}
+
+// boltSeqKey encodes a change Seq so that keys sort by Seq
+func boltSeqKey(seq int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(seq))
+	return k
+}
+
+// boltKeyDecode is the reverse of boltKey
+func boltKeyDecode(k []byte) (x, y, z int) {
+	x = int(int64(binary.BigEndian.Uint64(k[0:8]) ^ (1 << 63)))
+	y = int(int64(binary.BigEndian.Uint64(k[8:16]) ^ (1 << 63)))
+	z = int(int64(binary.BigEndian.Uint64(k[16:24]) ^ (1 << 63)))
+	return x, y, z
+}
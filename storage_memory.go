@@ -0,0 +1,484 @@
+package tile
+
+import (
+	"strings"
+	"sync"
+)
+
+// MemoryStorage keeps infinite map data in memory. It's intended for tests
+// & short lived map generation; nothing is written to disk.
+type MemoryStorage struct {
+	// writer is held for each write & for the whole of a transaction,
+	// so writers are one at a time.
+	writer sync.Mutex
+
+	lock  sync.RWMutex
+	tiles map[[2]int]map[int]string // (x,y) => z => src
+	props map[string]*Properties
+	meta  map[string]string
+	log   []Change // ordered by Seq, starting at 1
+}
+
+// NewMemoryStorage returns a new empty in memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		tiles: map[[2]int]map[int]string{},
+		props: map[string]*Properties{},
+		meta:  map[string]string{},
+	}
+}
+
+// Tile returns the src at (x,y,z) or "" if unset
+func (s *MemoryStorage) Tile(x, y, z int) (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.tiles[[2]int{x, y}][z], nil
+}
+
+// Tiles returns all set tiles in the rectangle (x0,y0,x1,y1) ordered by (x,y,z)
+func (s *MemoryStorage) Tiles(x0, y0, x1, y1 int) ([]Cell, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	result := []Cell{}
+	add := func(x, y int, column map[int]string) {
+		for z, src := range column {
+			result = append(result, Cell{X: x, Y: y, Z: z, Src: src})
+		}
+	}
+
+	w, h := x1-x0, y1-y0
+	if w > 0 && h > 0 && w <= len(s.tiles) && h <= len(s.tiles)/w {
+		// the area is small, so look up each (x,y)
+		for x := x0; x < x1; x++ {
+			for y := y0; y < y1; y++ {
+				add(x, y, s.tiles[[2]int{x, y}])
+			}
+		}
+	} else {
+		for xy, column := range s.tiles {
+			if xy[0] >= x0 && xy[0] < x1 && xy[1] >= y0 && xy[1] < y1 {
+				add(xy[0], xy[1], column)
+			}
+		}
+	}
+
+	sortCells(result)
+	return result, nil
+}
+
+// SetTiles writes the given tiles. Tiles with src "" are removed.
+func (s *MemoryStorage) SetTiles(cells ...Cell) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.setTiles(cells...)
+	return nil
+}
+
+// setTiles writes tiles, the caller must hold the write lock
+func (s *MemoryStorage) setTiles(cells ...Cell) {
+	for _, c := range cells {
+		xy := [2]int{c.X, c.Y}
+		column, ok := s.tiles[xy]
+
+		if c.Src == "" {
+			if !ok {
+				continue
+			}
+			delete(column, c.Z)
+			if len(column) == 0 {
+				delete(s.tiles, xy)
+			}
+			continue
+		}
+
+		if !ok {
+			column = map[int]string{}
+			s.tiles[xy] = column
+		}
+		column[c.Z] = c.Src
+	}
+}
+
+// Properties returns set properties by their src name
+func (s *MemoryStorage) Properties(srcs ...string) (map[string]*Properties, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	result := map[string]*Properties{}
+	for _, src := range srcs {
+		p, ok := s.props[src]
+		if ok {
+			result[src] = NewProperties().Merge(p)
+		}
+	}
+	return result, nil
+}
+
+// SetProperties overwrites the properties of the given srcs
+func (s *MemoryStorage) SetProperties(props map[string]*Properties) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.setProperties(props)
+	return nil
+}
+
+// setProperties writes properties, the caller must hold the write lock
+func (s *MemoryStorage) setProperties(props map[string]*Properties) {
+	for src, p := range props {
+		// we take a copy so later changes by the caller aren't reflected here
+		s.props[src] = NewProperties().Merge(p)
+	}
+}
+
+// Metadata returns all map level metadata with keys starting with `prefix`
+func (s *MemoryStorage) Metadata(prefix string) (map[string]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	result := map[string]string{}
+	for key, value := range s.meta {
+		if strings.HasPrefix(key, prefix) {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+// SetMetadata writes the given metadata. Keys with value "" are removed.
+func (s *MemoryStorage) SetMetadata(meta map[string]string) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.setMetadata(meta)
+	return nil
+}
+
+// setMetadata writes metadata, the caller must hold the write lock
+func (s *MemoryStorage) setMetadata(meta map[string]string) {
+	for key, value := range meta {
+		if value == "" {
+			delete(s.meta, key)
+		} else {
+			s.meta[key] = value
+		}
+	}
+}
+
+// LogChanges appends changes to the change log, each is given the next Seq
+func (s *MemoryStorage) LogChanges(changes ...Change) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.logChanges(changes...)
+	return nil
+}
+
+// logChanges appends to the change log, the caller must hold the write lock
+func (s *MemoryStorage) logChanges(changes ...Change) {
+	for _, c := range changes {
+		c.Seq = int64(len(s.log)) + 1
+		s.log = append(s.log, c)
+	}
+}
+
+// Changes returns logged changes with from < Seq <= to, ordered by Seq
+func (s *MemoryStorage) Changes(from, to int64) ([]Change, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return changesBetween(s.log, from, to), nil
+}
+
+// LastChange returns the Seq of the latest logged change, or 0 if there are none
+func (s *MemoryStorage) LastChange() (int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return int64(len(s.log)), nil
+}
+
+// changesBetween returns a copy of changes with from < Seq <= to
+// where `log` is ordered by Seq & starts at 1
+func changesBetween(log []Change, from, to int64) []Change {
+	if from < 0 {
+		from = 0
+	}
+	if to > int64(len(log)) {
+		to = int64(len(log))
+	}
+	if from >= to {
+		return []Change{}
+	}
+	return append([]Change{}, log[from:to]...)
+}
+
+// Bounds returns the smallest box that includes every set tile.
+// If no tiles are set nil is returned.
+func (s *MemoryStorage) Bounds() (*Bounds, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	bnds := newBoundsBuilder()
+	s.each(bnds.add)
+	return bnds.result(), nil
+}
+
+// Counts returns the number of set tiles by z-level & by src
+func (s *MemoryStorage) Counts() (map[int]int, map[string]int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	byZ, bySrc := map[int]int{}, map[string]int{}
+	s.each(func(c Cell) {
+		byZ[c.Z]++
+		bySrc[c.Src]++
+	})
+	return byZ, bySrc, nil
+}
+
+// ZLevels returns all z-levels with at least one tile set, sorted low -> high
+func (s *MemoryStorage) ZLevels() ([]int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return zLevels(s.each), nil
+}
+
+// each calls `fn` for every set tile, the caller must hold the read lock
+func (s *MemoryStorage) each(fn func(c Cell)) {
+	for xy, column := range s.tiles {
+		for z, src := range column {
+			fn(Cell{X: xy[0], Y: xy[1], Z: z, Src: src})
+		}
+	}
+}
+
+// Begin starts a new transaction.
+// Changes are held in the transaction & applied all at once on Commit.
+// Only one transaction can be open at a time, so this waits for any other to finish.
+func (s *MemoryStorage) Begin() (StorageTx, error) {
+	s.writer.Lock()
+	return &memoryTx{
+		s:     s,
+		tiles: map[[3]int]string{},
+		props: map[string]*Properties{},
+		meta:  map[string]string{},
+		log:   []Change{},
+	}, nil
+}
+
+// Close does nothing, but is here to satisfy Storage
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// memoryTx is a transaction on a MemoryStorage
+type memoryTx struct {
+	s     *MemoryStorage
+	tiles map[[3]int]string // (x,y,z) => src ("" implies removed)
+	props map[string]*Properties
+	meta  map[string]string // key => value ("" implies removed)
+	log   []Change          // changes logged in the transaction, in order
+	once  sync.Once         // releases the storage writer lock
+}
+
+// done ends the transaction, releasing the storage writer lock
+func (t *memoryTx) done() {
+	t.once.Do(t.s.writer.Unlock)
+}
+
+// Tile returns the src at (x,y,z) or "" if unset
+func (t *memoryTx) Tile(x, y, z int) (string, error) {
+	src, ok := t.tiles[[3]int{x, y, z}]
+	if ok {
+		return src, nil
+	}
+	return t.s.Tile(x, y, z)
+}
+
+// Tiles returns all set tiles in the rectangle (x0,y0,x1,y1) ordered by (x,y,z)
+func (t *memoryTx) Tiles(x0, y0, x1, y1 int) ([]Cell, error) {
+	saved, err := t.s.Tiles(x0, y0, x1, y1)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Cell{}
+	for _, c := range saved {
+		_, changed := t.tiles[[3]int{c.X, c.Y, c.Z}]
+		if !changed {
+			result = append(result, c)
+		}
+	}
+	for xyz, src := range t.tiles {
+		if src == "" || xyz[0] < x0 || xyz[0] >= x1 || xyz[1] < y0 || xyz[1] >= y1 {
+			continue
+		}
+		result = append(result, Cell{X: xyz[0], Y: xyz[1], Z: xyz[2], Src: src})
+	}
+
+	sortCells(result)
+	return result, nil
+}
+
+// SetTiles writes the given tiles. Tiles with src "" are removed.
+func (t *memoryTx) SetTiles(cells ...Cell) error {
+	for _, c := range cells {
+		t.tiles[[3]int{c.X, c.Y, c.Z}] = c.Src
+	}
+	return nil
+}
+
+// Properties returns set properties by their src name
+func (t *memoryTx) Properties(srcs ...string) (map[string]*Properties, error) {
+	result, err := t.s.Properties(srcs...)
+	if err != nil {
+		return nil, err
+	}
+	for _, src := range srcs {
+		p, ok := t.props[src]
+		if ok {
+			result[src] = NewProperties().Merge(p)
+		}
+	}
+	return result, nil
+}
+
+// SetProperties overwrites the properties of the given srcs
+func (t *memoryTx) SetProperties(props map[string]*Properties) error {
+	for src, p := range props {
+		t.props[src] = NewProperties().Merge(p)
+	}
+	return nil
+}
+
+// Metadata returns all map level metadata with keys starting with `prefix`
+func (t *memoryTx) Metadata(prefix string) (map[string]string, error) {
+	result, err := t.s.Metadata(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range t.meta {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if value == "" {
+			delete(result, key)
+		} else {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+// SetMetadata writes the given metadata. Keys with value "" are removed.
+func (t *memoryTx) SetMetadata(meta map[string]string) error {
+	for key, value := range meta {
+		t.meta[key] = value
+	}
+	return nil
+}
+
+// LogChanges appends changes to the change log, each is given the next Seq
+func (t *memoryTx) LogChanges(changes ...Change) error {
+	t.log = append(t.log, changes...)
+	return nil
+}
+
+// Changes returns logged changes with from < Seq <= to, ordered by Seq.
+// Changes logged in the transaction are numbered as if committed now.
+func (t *memoryTx) Changes(from, to int64) ([]Change, error) {
+	t.s.lock.RLock()
+	log := append(append([]Change{}, t.s.log...), t.log...)
+	t.s.lock.RUnlock()
+
+	for index := range log {
+		log[index].Seq = int64(index) + 1
+	}
+	return changesBetween(log, from, to), nil
+}
+
+// LastChange returns the Seq of the latest logged change, or 0 if there are none
+func (t *memoryTx) LastChange() (int64, error) {
+	last, err := t.s.LastChange()
+	return last + int64(len(t.log)), err
+}
+
+// Bounds returns the smallest box that includes every set tile.
+// If no tiles are set nil is returned.
+func (t *memoryTx) Bounds() (*Bounds, error) {
+	bnds := newBoundsBuilder()
+	t.each(bnds.add)
+	return bnds.result(), nil
+}
+
+// Counts returns the number of set tiles by z-level & by src
+func (t *memoryTx) Counts() (map[int]int, map[string]int, error) {
+	byZ, bySrc := map[int]int{}, map[string]int{}
+	t.each(func(c Cell) {
+		byZ[c.Z]++
+		bySrc[c.Src]++
+	})
+	return byZ, bySrc, nil
+}
+
+// ZLevels returns all z-levels with at least one tile set, sorted low -> high
+func (t *memoryTx) ZLevels() ([]int, error) {
+	return zLevels(t.each), nil
+}
+
+// each calls `fn` for every set tile, as seen from inside the transaction
+func (t *memoryTx) each(fn func(c Cell)) {
+	t.s.lock.RLock()
+	t.s.each(func(c Cell) {
+		_, changed := t.tiles[[3]int{c.X, c.Y, c.Z}]
+		if !changed {
+			fn(c)
+		}
+	})
+	t.s.lock.RUnlock()
+
+	for xyz, src := range t.tiles {
+		if src != "" {
+			fn(Cell{X: xyz[0], Y: xyz[1], Z: xyz[2], Src: src})
+		}
+	}
+}
+
+// Commit writes all changes made in the transaction
+func (t *memoryTx) Commit() error {
+	defer t.done()
+
+	cells := make([]Cell, 0, len(t.tiles))
+	for xyz, src := range t.tiles {
+		cells = append(cells, Cell{X: xyz[0], Y: xyz[1], Z: xyz[2], Src: src})
+	}
+
+	t.s.lock.Lock()
+	defer t.s.lock.Unlock()
+	t.s.setTiles(cells...)
+	t.s.setProperties(t.props)
+	t.s.setMetadata(t.meta)
+	t.s.logChanges(t.log...)
+
+	t.tiles = map[[3]int]string{}
+	t.props = map[string]*Properties{}
+	t.meta = map[string]string{}
+	t.log = []Change{}
+	return nil
+}
+
+// Rollback discards all changes made in the transaction
+func (t *memoryTx) Rollback() error {
+	defer t.done()
+
+	t.tiles = map[[3]int]string{}
+	t.props = map[string]*Properties{}
+	t.meta = map[string]string{}
+	t.log = []Change{}
+	return nil
+}
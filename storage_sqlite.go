@@ -0,0 +1,493 @@
+//go:build cgo
+// +build cgo
+
+package tile
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	sqlDeleteTile  = `DELETE FROM tiles WHERE x=:x AND y=:y AND z=:z;`
+	sqlUpdateTiles = `INSERT INTO tiles (x, y, z, src) VALUES (:x, :y, :z, :src) ON CONFLICT (x, y, z) DO UPDATE SET src=EXCLUDED.src;`
+	sqlGetProps    = `SELECT src,data FROM properties WHERE `
+	sqlUpdateProps = `INSERT INTO properties (src, data) VALUES (:src, :data) ON CONFLICT (src) DO UPDATE SET data=EXCLUDED.data;`
+	sqlDeleteMeta  = `DELETE FROM metadata WHERE key=:key;`
+	sqlUpdateMeta  = `INSERT INTO metadata (key, value) VALUES (:key, :value) ON CONFLICT (key) DO UPDATE SET value=EXCLUDED.value;`
+	sqlLogChange   = `INSERT INTO changes (time, tag, kind, x, y, z, src, old, new) VALUES (:time, :tag, :kind, :x, :y, :z, :src, :old, :new);`
+
+	// maxBulkTiles is the most tiles we'll insert in one statement, sqlite
+	// limits the number of variables in a single query (see SQLITE_MAX_VARIABLE_NUMBER)
+	maxBulkTiles = 5000
+)
+
+// dbHandle is satisfied by both *sqlx.DB and *sqlx.Tx so that we can run
+// the same queries in & out of transactions.
+type dbHandle interface {
+	NamedExec(string, interface{}) (sql.Result, error)
+	NamedQuery(string, interface{}) (*sqlx.Rows, error)
+	Select(interface{}, string, ...interface{}) error
+	QueryRowx(string, ...interface{}) *sqlx.Row
+}
+
+// SQLiteStorage keeps infinite map data in a sqlite database file.
+//
+// The database is opened in WAL mode so many goroutines (or processes) can
+// read while another writes. Writes in this process are made one at a time,
+// writes from other processes are waited on (up to a timeout).
+type SQLiteStorage struct {
+	sqliteStore
+	filename string
+	db       *sqlx.DB
+
+	// writer is held for each write & for the whole of a transaction
+	writer sync.Mutex
+}
+
+// OpenSQLiteStorage given it's filename (database file) on disk.
+// Will create if it doesn't exist & migrate the schema if it is out of date.
+func OpenSQLiteStorage(fname string) (*SQLiteStorage, error) {
+	return OpenSQLiteStorageWithConfig(fname, nil)
+}
+
+// OpenSQLiteStorageWithConfig opens the database file with the given settings.
+// A read only database must already have an up to date schema.
+func OpenSQLiteStorageWithConfig(fname string, cfg *OpenConfig) (*SQLiteStorage, error) {
+	if cfg == nil {
+		cfg = DefaultOpenConfig()
+	}
+
+	err := prepareFile(fname, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sqlx.Open("sqlite3", sqliteDSN(fname, cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SQLiteStorage{sqliteStore: sqliteStore{db: db}, filename: fname, db: db}
+	if cfg.ReadOnly {
+		err = s.checkSchema()
+	} else {
+		err = s.migrate()
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// sqliteDSN returns the connection string for the database file.
+// Transactions take the write lock when they begin (rather than on their first
+// write) so that two transactions can't both read then deadlock trying to write.
+func sqliteDSN(fname string, cfg *OpenConfig) string {
+	params := []string{
+		fmt.Sprintf("_busy_timeout=%d", cfg.BusyTimeout.Milliseconds()),
+		"_txlock=immediate",
+	}
+	if cfg.ReadOnly {
+		// the journal mode is kept in the file, so can't be changed read only
+		params = append(params, "mode=ro")
+	} else if cfg.JournalMode != "" {
+		params = append(params, "_journal_mode="+cfg.JournalMode)
+	}
+
+	if !strings.HasPrefix(fname, "file:") {
+		fname = "file:" + fname
+	}
+	sep := "?"
+	if strings.Contains(fname, "?") {
+		sep = "&"
+	}
+	return fname + sep + strings.Join(params, "&")
+}
+
+// Filename returns the path to the database file on disk
+func (s *SQLiteStorage) Filename() string {
+	return s.filename
+}
+
+// Begin starts a new transaction.
+// Only one transaction can be open at a time, so this waits for any other to finish.
+func (s *SQLiteStorage) Begin() (StorageTx, error) {
+	s.writer.Lock()
+	tx, err := s.db.Beginx()
+	if err != nil {
+		s.writer.Unlock()
+		return nil, err
+	}
+	return &sqliteTx{sqliteStore: sqliteStore{db: tx}, tx: tx, done: s.writer.Unlock}, nil
+}
+
+// SetTiles writes the given tiles. Tiles with src "" are removed.
+func (s *SQLiteStorage) SetTiles(cells ...Cell) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+	return s.sqliteStore.SetTiles(cells...)
+}
+
+// SetProperties overwrites the properties of the given srcs
+func (s *SQLiteStorage) SetProperties(props map[string]*Properties) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+	return s.sqliteStore.SetProperties(props)
+}
+
+// SetMetadata writes the given metadata. Keys with value "" are removed.
+func (s *SQLiteStorage) SetMetadata(meta map[string]string) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+	return s.sqliteStore.SetMetadata(meta)
+}
+
+// LogChanges appends changes to the change log, each is given the next Seq
+func (s *SQLiteStorage) LogChanges(changes ...Change) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+	return s.sqliteStore.LogChanges(changes...)
+}
+
+// Close the database
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// sqliteTx is a transaction on a SQLiteStorage
+type sqliteTx struct {
+	sqliteStore
+	tx   *sqlx.Tx
+	done func() // releases the storage writer lock
+	once sync.Once
+}
+
+// Commit writes all changes made in the transaction
+func (t *sqliteTx) Commit() error {
+	defer t.once.Do(t.done)
+	return t.tx.Commit()
+}
+
+// Rollback discards all changes made in the transaction
+func (t *sqliteTx) Rollback() error {
+	defer t.once.Do(t.done)
+	return t.tx.Rollback()
+}
+
+// sqliteStore implements Store using either a database handle or transaction
+type sqliteStore struct {
+	db dbHandle
+}
+
+// Tile returns the src at (x,y,z) or "" if unset
+func (s *sqliteStore) Tile(x, y, z int) (string, error) {
+	rows, err := s.db.NamedQuery(
+		"SELECT x,y,z,src FROM tiles WHERE x=:x0 AND y=:y0 AND z=:z0 LIMIT 1;",
+		map[string]interface{}{
+			"x0": x,
+			"y0": y,
+			"z0": z,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	tile := Cell{}
+	for rows.Next() { // there's at most one due to LIMIT 1
+		err = rows.StructScan(&tile)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return tile.Src, rows.Err()
+}
+
+// Tiles returns all tiles in the rectangle (x0,y0,x1,y1) ordered by (x,y,z)
+func (s *sqliteStore) Tiles(x0, y0, x1, y1 int) ([]Cell, error) {
+	rows, err := s.db.NamedQuery(
+		// a range scan of the (x,y,z) primary key, which is also the order we return
+		"SELECT x,y,z,src FROM tiles WHERE x>=:x0 AND x<:x1 AND y>=:y0 AND y<:y1 ORDER BY x,y,z;",
+		map[string]interface{}{
+			"x0": x0, "x1": x1,
+			"y0": y0, "y1": y1,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []Cell{}
+	for rows.Next() {
+		tile := Cell{}
+		err = rows.StructScan(&tile)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, tile)
+	}
+
+	return result, rows.Err()
+}
+
+// SetTiles writes the given tiles. Tiles with src "" are removed.
+func (s *sqliteStore) SetTiles(cells ...Cell) error {
+	update := []Cell{}
+	for _, c := range cells {
+		if c.Src != "" {
+			update = append(update, c)
+			continue
+		}
+		_, err := s.db.NamedExec(sqlDeleteTile, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(update); start += maxBulkTiles {
+		end := start + maxBulkTiles
+		if end > len(update) {
+			end = len(update)
+		}
+		_, err := s.db.NamedExec(sqlUpdateTiles, update[start:end])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Properties returns set properties by their src name
+func (s *sqliteStore) Properties(in ...string) (map[string]*Properties, error) {
+	result := map[string]*Properties{}
+	if len(in) == 0 {
+		return result, nil
+	}
+
+	args := map[string]interface{}{}
+	or := []string{}
+
+	for i, src := range in {
+		name := fmt.Sprintf("prop_%d", i)
+
+		args[name] = src
+		or = append(or, fmt.Sprintf("src=:%s", name))
+	}
+
+	qstr := fmt.Sprintf("%s %s LIMIT %d;", sqlGetProps, strings.Join(or, " OR "), len(in))
+
+	rows, err := s.db.NamedQuery(qstr, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := dbProp{}
+	for rows.Next() {
+		err = rows.StructScan(&r)
+		if err != nil {
+			return nil, err
+		}
+
+		props, err := decodeProperties([]byte(r.Data))
+		if err != nil {
+			return nil, err
+		}
+		result[r.Src] = props
+	}
+
+	return result, rows.Err()
+}
+
+// SetProperties overwrites the properties of the given srcs
+func (s *sqliteStore) SetProperties(props map[string]*Properties) error {
+	if len(props) == 0 {
+		return nil
+	}
+
+	update := []dbProp{}
+	for src, p := range props {
+		update = append(update, dbProp{Src: src, Data: string(encodeProperties(p))})
+	}
+
+	_, err := s.db.NamedExec(sqlUpdateProps, update)
+	return err
+}
+
+// Bounds returns the smallest box that includes every set tile.
+// If no tiles are set nil is returned.
+func (s *sqliteStore) Bounds() (*Bounds, error) {
+	row := s.db.QueryRowx(`SELECT count(*),
+		IFNULL(MIN(x),0), IFNULL(MIN(y),0), IFNULL(MIN(z),0),
+		IFNULL(MAX(x),0), IFNULL(MAX(y),0), IFNULL(MAX(z),0) FROM tiles;`)
+
+	var num int64
+	b := &Bounds{}
+	err := row.Scan(&num, &b.MinX, &b.MinY, &b.MinZ, &b.MaxX, &b.MaxY, &b.MaxZ)
+	if err != nil || num == 0 {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ZLevels returns all z-levels with at least one tile set, sorted low -> high
+func (s *sqliteStore) ZLevels() ([]int, error) {
+	levels := []int{}
+	err := s.db.Select(&levels, "SELECT DISTINCT z FROM tiles ORDER BY z;")
+	return levels, err
+}
+
+// Counts returns the number of set tiles by z-level & by src
+func (s *sqliteStore) Counts() (map[int]int, map[string]int, error) {
+	byZ := []struct {
+		Z   int `db:"z"`
+		Num int `db:"num"`
+	}{}
+	err := s.db.Select(&byZ, "SELECT z, count(*) AS num FROM tiles GROUP BY z;")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bySrc := []struct {
+		Src string `db:"src"`
+		Num int    `db:"num"`
+	}{}
+	err = s.db.Select(&bySrc, "SELECT src, count(*) AS num FROM tiles GROUP BY src;")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	zs := map[int]int{}
+	for _, r := range byZ {
+		zs[r.Z] = r.Num
+	}
+	srcs := map[string]int{}
+	for _, r := range bySrc {
+		srcs[r.Src] = r.Num
+	}
+	return zs, srcs, nil
+}
+
+// Metadata returns all map level metadata with keys starting with `prefix`
+func (s *sqliteStore) Metadata(prefix string) (map[string]string, error) {
+	rows := []dbMeta{}
+	err := s.db.Select(&rows, "SELECT key, value FROM metadata WHERE substr(key, 1, length(?))=?;", prefix, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]string{}
+	for _, r := range rows {
+		result[r.Key] = r.Value
+	}
+	return result, nil
+}
+
+// SetMetadata writes the given metadata. Keys with value "" are removed.
+func (s *sqliteStore) SetMetadata(meta map[string]string) error {
+	for key, value := range meta {
+		var err error
+		if value == "" {
+			_, err = s.db.NamedExec(sqlDeleteMeta, dbMeta{Key: key})
+		} else {
+			_, err = s.db.NamedExec(sqlUpdateMeta, dbMeta{Key: key, Value: value})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogChanges appends changes to the change log, each is given the next Seq
+func (s *sqliteStore) LogChanges(changes ...Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	rows := make([]dbChange, 0, len(changes))
+	for _, c := range changes {
+		rows = append(rows, dbChange{
+			Time: c.Time.UnixNano(), Tag: c.Tag, Kind: int(c.Kind),
+			X: c.X, Y: c.Y, Z: c.Z,
+			Src: c.Src, Old: c.Old, New: c.New,
+		})
+	}
+
+	// each change has 9 variables
+	for start := 0; start < len(rows); start += maxBulkTiles / 2 {
+		end := start + maxBulkTiles/2
+		if end > len(rows) {
+			end = len(rows)
+		}
+		_, err := s.db.NamedExec(sqlLogChange, rows[start:end])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Changes returns logged changes with from < Seq <= to, ordered by Seq
+func (s *sqliteStore) Changes(from, to int64) ([]Change, error) {
+	rows := []dbChange{}
+	err := s.db.Select(&rows, "SELECT * FROM changes WHERE seq>? AND seq<=? ORDER BY seq;", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Change, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, Change{
+			Seq: r.Seq, Time: time.Unix(0, r.Time), Tag: r.Tag, Kind: ChangeKind(r.Kind),
+			X: r.X, Y: r.Y, Z: r.Z,
+			Src: r.Src, Old: r.Old, New: r.New,
+		})
+	}
+	return result, nil
+}
+
+// LastChange returns the Seq of the latest logged change, or 0 if there are none
+func (s *sqliteStore) LastChange() (int64, error) {
+	var last int64
+	err := s.db.QueryRowx("SELECT IFNULL(MAX(seq),0) FROM changes;").Scan(&last)
+	return last, err
+}
+
+// dbChange object encodes a single logged change
+type dbChange struct {
+	Seq  int64  `db:"seq"`
+	Time int64  `db:"time"`
+	Tag  string `db:"tag"`
+	Kind int    `db:"kind"`
+	X    int    `db:"x"`
+	Y    int    `db:"y"`
+	Z    int    `db:"z"`
+	Src  string `db:"src"`
+	Old  string `db:"old"`
+	New  string `db:"new"`
+}
+
+// dbMeta object encodes a single metadata key / value
+type dbMeta struct {
+	Key   string `db:"key"`
+	Value string `db:"value"`
+}
+
+// dbProp object encodes properties for a single src.
+type dbProp struct {
+	Src  string `db:"src"`
+	Data string `db:"data"`
+}
@@ -0,0 +1,25 @@
+//go:build !cgo
+// +build !cgo
+
+package tile
+
+import (
+	"fmt"
+)
+
+// SQLiteStorage keeps infinite map data in a sqlite database file.
+// It requires cgo, so in this build it is not available.
+type SQLiteStorage struct {
+	Storage
+}
+
+// OpenSQLiteStorage always fails as this binary was built without cgo.
+// Use another storage (eg. OpenBoltStorage or NewMemoryStorage).
+func OpenSQLiteStorage(fname string) (*SQLiteStorage, error) {
+	return OpenSQLiteStorageWithConfig(fname, nil)
+}
+
+// OpenSQLiteStorageWithConfig always fails as this binary was built without cgo.
+func OpenSQLiteStorageWithConfig(fname string, cfg *OpenConfig) (*SQLiteStorage, error) {
+	return nil, fmt.Errorf("sqlite storage requires cgo, unable to open %s", fname)
+}
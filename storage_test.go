@@ -0,0 +1,193 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"path/filepath"
+	"testing"
+)
+
+// testStorages returns a new empty storage of each backend by name.
+// Backends that can't be opened (eg. sqlite without cgo) are left out.
+func testStorages(t *testing.T) map[string]Storage {
+	stores := map[string]Storage{"memory": NewMemoryStorage()}
+
+	sq, err := OpenSQLiteStorage(filepath.Join(t.TempDir(), "test.sqlite"))
+	if err == nil {
+		stores["sqlite"] = sq
+	}
+
+	bt, err := OpenBoltStorage(filepath.Join(t.TempDir(), "test.bolt"))
+	require.NoError(t, err)
+	stores["bolt"] = bt
+
+	for _, s := range stores {
+		s := s
+		t.Cleanup(func() { s.Close() })
+	}
+
+	return stores
+}
+
+func TestStorage(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, s.SetTiles(
+				Cell{X: 2, Y: 0, Z: 0, Src: "grass.png"},
+				Cell{X: -3, Y: 1, Z: 1, Src: "tree.png"},
+				Cell{X: -3, Y: 1, Z: -1, Src: "dirt.png"},
+				Cell{X: 0, Y: -5, Z: 0, Src: "grass.png"},
+				Cell{X: 0, Y: 5, Z: 0, Src: "rock.png"},
+			))
+			assert.Nil(t, s.SetTiles(Cell{X: 0, Y: 5, Z: 0}))
+
+			src, err := s.Tile(-3, 1, 1)
+			assert.Nil(t, err)
+			assert.Equal(t, "tree.png", src)
+			src, _ = s.Tile(0, 5, 0)
+			assert.Equal(t, "", src)
+
+			tiles, err := s.Tiles(-3, -5, 3, 5)
+			assert.Nil(t, err)
+			assert.Equal(t, []Cell{
+				{X: -3, Y: 1, Z: -1, Src: "dirt.png"},
+				{X: -3, Y: 1, Z: 1, Src: "tree.png"},
+				{X: 0, Y: -5, Z: 0, Src: "grass.png"},
+				{X: 2, Y: 0, Z: 0, Src: "grass.png"},
+			}, tiles)
+
+			tiles, _ = s.Tiles(-2, -5, 3, 0)
+			assert.Equal(t, []Cell{{X: 0, Y: -5, Z: 0, Src: "grass.png"}}, tiles)
+
+			// a wide, mostly empty, range
+			tiles, _ = s.Tiles(-1000000000, 0, 1000000000, 1)
+			assert.Equal(t, []Cell{{X: 2, Y: 0, Z: 0, Src: "grass.png"}}, tiles)
+
+			b, err := s.Bounds()
+			assert.Nil(t, err)
+			assert.Equal(t, &Bounds{MinX: -3, MinY: -5, MinZ: -1, MaxX: 2, MaxY: 1, MaxZ: 1}, b)
+
+			byZ, bySrc, err := s.Counts()
+			assert.Nil(t, err)
+			assert.Equal(t, map[int]int{-1: 1, 0: 2, 1: 1}, byZ)
+			assert.Equal(t, map[string]int{"grass.png": 2, "tree.png": 1, "dirt.png": 1}, bySrc)
+
+			levels, err := s.ZLevels()
+			assert.Nil(t, err)
+			assert.Equal(t, []int{-1, 0, 1}, levels)
+
+			props := NewProperties()
+			props.SetInt("height", 3)
+			assert.Nil(t, s.SetProperties(map[string]*Properties{"tree.png": props}))
+
+			saved, err := s.Properties("tree.png", "grass.png")
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(saved))
+			height, _ := saved["tree.png"].Int("height")
+			assert.Equal(t, 3, height)
+		})
+	}
+}
+
+func TestStorageTx(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, s.SetTiles(Cell{X: 0, Y: 0, Z: 0, Src: "grass.png"}))
+
+			tx, err := s.Begin()
+			assert.Nil(t, err)
+			assert.Nil(t, tx.SetTiles(Cell{X: 0, Y: 0, Z: 0}, Cell{X: 1, Y: 0, Z: 0, Src: "rock.png"}))
+
+			// changes are visible inside the transaction
+			tiles, err := tx.Tiles(0, 0, 2, 1)
+			assert.Nil(t, err)
+			assert.Equal(t, []Cell{{X: 1, Y: 0, Z: 0, Src: "rock.png"}}, tiles)
+			assert.Nil(t, tx.Rollback())
+
+			src, _ := s.Tile(0, 0, 0)
+			assert.Equal(t, "grass.png", src)
+			src, _ = s.Tile(1, 0, 0)
+			assert.Equal(t, "", src)
+
+			tx, err = s.Begin()
+			assert.Nil(t, err)
+			assert.Nil(t, tx.SetTiles(Cell{X: 1, Y: 0, Z: 0, Src: "rock.png"}))
+			assert.Nil(t, tx.Commit())
+
+			tiles, _ = s.Tiles(0, 0, 2, 1)
+			assert.Equal(t, 2, len(tiles))
+		})
+	}
+}
+
+func TestInfiniteMapWithStorage(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+
+			obj := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 2, MapHeight: 2})
+			obj.Set(0, 0, 0, "grass.png")
+			obj.Set(1, 1, 1, "tree.png")
+			assert.Nil(t, inf.Add(10, 10, 0, obj))
+
+			fits, err := inf.Fits(10, 10, 2, obj)
+			assert.Nil(t, err)
+			assert.True(t, fits)
+			fits, _ = inf.Fits(10, 10, 0, obj)
+			assert.False(t, fits)
+
+			m, err := inf.Map(10, 10, 12, 12)
+			assert.Nil(t, err)
+			src, _ := m.At(1, 1, 1)
+			assert.Equal(t, "tree.png", src)
+
+			lvls, err := inf.ZLevels()
+			assert.Nil(t, err)
+			assert.Equal(t, []int{0, 1}, lvls)
+		})
+	}
+}
+
+func TestOpenConfig(t *testing.T) {
+	openers := map[string]func(fname string, cfg *OpenConfig) (Storage, error){
+		"sqlite": func(fname string, cfg *OpenConfig) (Storage, error) { return OpenSQLiteStorageWithConfig(fname, cfg) },
+		"bolt":   func(fname string, cfg *OpenConfig) (Storage, error) { return OpenBoltStorageWithConfig(fname, cfg) },
+	}
+
+	if _, ok := testStorages(t)["sqlite"]; !ok {
+		delete(openers, "sqlite") // eg. without cgo
+	}
+
+	for name, open := range openers {
+		t.Run(name, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), "test."+name)
+
+			cfg := DefaultOpenConfig()
+			cfg.MustExist = true
+			_, err := open(fname, cfg)
+			assert.NotNil(t, err)
+
+			cfg = DefaultOpenConfig()
+			cfg.CreateOnly = true
+			s, err := open(fname, cfg)
+			require.NoError(t, err)
+			assert.Nil(t, s.SetTiles(Cell{X: 1, Y: 2, Z: 3, Src: "grass.png"}))
+			assert.Nil(t, s.Close())
+
+			// it exists now
+			_, err = open(fname, cfg)
+			assert.NotNil(t, err)
+
+			cfg = DefaultOpenConfig()
+			cfg.ReadOnly = true
+			s, err = open(fname, cfg)
+			require.NoError(t, err)
+			src, err := s.Tile(1, 2, 3)
+			assert.Nil(t, err)
+			assert.Equal(t, "grass.png", src)
+			assert.NotNil(t, s.SetTiles(Cell{X: 1, Y: 2, Z: 3, Src: "rock.png"}))
+			assert.Nil(t, s.Close())
+		})
+	}
+}
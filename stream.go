@@ -0,0 +1,191 @@
+/* file adds reading maps a tile layer at a time, for maps too large to hold at once. */
+package tile
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// DecodeLayers reads a map a tile layer at a time, calling `fn` with each
+// layer as it's read rather than keeping every layer in memory.
+// During the call `m` holds only that tile layer, along with the tilesets,
+// properties & image layers read so far, so eg. m.At, m.Crop or m.Render work
+// on the one layer. The map returned holds everything but the tile layers.
+// External (.tsx) tilesets can't be loaded from a reader.
+func DecodeLayers(r io.Reader, cfg *DecodeConfig, fn func(m *Map, tl *TileLayer) error) (*Map, error) {
+	return decodeStream(r, nil, "", cfg, func(m *Map, tl *TileLayer) error {
+		m.TileLayers = []*TileLayer{tl}
+		m.indexLayers()
+		err := fn(m, tl)
+		m.TileLayers = nil
+		return err
+	})
+}
+
+// DecodeRegion reads the tiles within `rect` (in tiles) of a map, as Crop
+// would, without holding the whole map in memory.
+func DecodeRegion(r io.Reader, rect image.Rectangle, cfg *DecodeConfig) (*Map, error) {
+	var out *Map
+	gids := map[uint]uint{}
+	m, err := decodeStream(r, nil, "", cfg, func(m *Map, tl *TileLayer) error {
+		if out == nil {
+			err := m.checkRect(rect)
+			if err != nil {
+				return err
+			}
+			out = m.emptyRegion(rect)
+		}
+		m.copyLayer(out, tl, tl.Name, rect, gids)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if out == nil {
+		// no tile layers
+		err = m.checkRect(rect)
+		if err != nil {
+			return nil, err
+		}
+		out = m.emptyRegion(rect)
+	}
+	for _, l := range m.ImageLayers {
+		il := m.moveImageLayer(l, out.Width, out.Height, -rect.Min.X, -rect.Min.Y)
+		if il != nil {
+			out.ImageLayers = append(out.ImageLayers, il)
+		}
+	}
+
+	return out, nil
+}
+
+// streamedMap is a Map whose tile layers are passed to a layerSink as
+// they're decoded, rather than being kept
+type streamedMap struct {
+	*Map
+	Layers layerSink `xml:"layer"` // takes the place of Map.TileLayers
+	Extra  extraSink `xml:",any"`  // takes the place of Map.Extra
+}
+
+// layerSink decodes tile layers one at a time & hands them to `fn`
+type layerSink struct {
+	m    *Map
+	fsys fs.FS
+	dir  string
+	cfg  *DecodeConfig
+	fn   func(m *Map, tl *TileLayer) error
+
+	tilesets int // number of tilesets loaded & indexed
+	layers   int // number of tile layers read
+}
+
+// UnmarshalXML implements xml.Unmarshaler, it's called for each tile layer
+func (s *layerSink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	tl := &TileLayer{}
+	err := d.DecodeElement(tl, &start)
+	if err != nil {
+		return err
+	}
+
+	err = s.prepare()
+	if err != nil {
+		return err
+	}
+	err = s.m.decodeLayer(tl, s.cfg)
+	if err != nil {
+		return err
+	}
+	s.layers++
+	return s.fn(s.m, tl)
+}
+
+// extraSink decodes elements we don't use into Map.Extra, noting how many
+// layers were read before each so it's written back in place (see layerWriter)
+type extraSink struct {
+	layers *layerSink
+}
+
+// UnmarshalXML implements xml.Unmarshaler, it's called for each element we don't use
+func (s *extraSink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	raw := &RawXML{}
+	err := d.DecodeElement(raw, &start)
+	if err != nil {
+		return err
+	}
+	m := s.layers.m
+	raw.imageLayers, raw.tileLayers = len(m.ImageLayers), s.layers.layers
+	m.Extra = append(m.Extra, raw)
+	return nil
+}
+
+// prepare checks the map's size & loads (& indexes) tilesets read since it
+// was last called, so tile layers can be checked against them
+func (s *layerSink) prepare() error {
+	err := s.m.checkSize(s.cfg.Strict)
+	if err != nil {
+		return err
+	}
+	if s.tilesets == len(s.m.Tilesets) {
+		return nil
+	}
+
+	for _, ts := range s.m.Tilesets[s.tilesets:] {
+		if ts.Source == "" {
+			continue
+		}
+		if s.fsys == nil {
+			return fmt.Errorf("unable to load external tileset %s without the map's directory", ts.Source)
+		}
+		err := ts.load(s.fsys, path.Join(s.dir, ts.Source))
+		if err != nil {
+			return err
+		}
+	}
+	s.tilesets = len(s.m.Tilesets)
+	s.m.index()
+	return nil
+}
+
+// decodeStream decodes a TMX map XML, passing each tile layer to `fn` as
+// it's read. External tilesets & images are loaded from `dir` of fsys, if
+// it's nil images are loaded from the working directory.
+func decodeStream(r io.Reader, fsys fs.FS, dir string, cfg *DecodeConfig, fn func(m *Map, tl *TileLayer) error) (*Map, error) {
+	if cfg == nil {
+		cfg = DefaultDecodeConfig()
+	}
+
+	m := &Map{images: newImageLoader(osFS{}, ".")}
+	if fsys != nil {
+		m.images = newImageLoader(fsys, dir)
+	}
+	s := &streamedMap{Map: m, Layers: layerSink{m: m, fsys: fsys, dir: dir, cfg: cfg, fn: fn}}
+	s.Extra.layers = &s.Layers
+	err := xml.NewDecoder(r).Decode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	// tilesets after the last tile layer (or maps without any)
+	err = s.Layers.prepare()
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Tilesets) == 0 {
+		m.Tilesets = []*Tileset{newTileset("default", 1)}
+	}
+	m.index()
+
+	if cfg.Strict {
+		err = m.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
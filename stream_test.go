@@ -0,0 +1,166 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"bytes"
+	"errors"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodeLayers(t *testing.T) {
+	house := testHouse()
+	buf := &bytes.Buffer{}
+	assert.Nil(t, house.Encode(buf))
+
+	found := map[string]int{}
+	m, err := DecodeLayers(bytes.NewReader(buf.Bytes()), nil, func(m *Map, tl *TileLayer) error {
+		assert.Equal(t, 1, len(m.TileLayers))
+		found[tl.Name] = len(m.cells())
+
+		z, _ := layerZ(tl.Name)
+		src, err := m.At(5, 4, z)
+		assert.Nil(t, err)
+		expect, _ := house.At(5, 4, z)
+		assert.Equal(t, expect, src)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"0": 10, "2": 9, "3": 3}, found)
+	assert.Equal(t, 0, len(m.TileLayers))
+	assert.Equal(t, 1, len(m.ImageLayers))
+
+	// stopping part way
+	stop := errors.New("stop")
+	_, err = DecodeLayers(bytes.NewReader(buf.Bytes()), nil, func(m *Map, tl *TileLayer) error {
+		return stop
+	})
+	assert.Equal(t, stop, err)
+
+	// errors are those of Decode
+	_, err = DecodeLayers(bytes.NewBufferString(csvdata), &DecodeConfig{Strict: true}, func(m *Map, tl *TileLayer) error {
+		return nil
+	})
+	assert.True(t, errors.Is(err, ErrInvalidTileData))
+}
+
+func TestDecodeRegion(t *testing.T) {
+	house := testHouse()
+	buf := &bytes.Buffer{}
+	assert.Nil(t, house.Encode(buf))
+
+	for _, r := range []image.Rectangle{
+		image.Rect(4, 3, 8, 10),
+		image.Rect(0, 0, 10, 10),
+		image.Rect(0, 0, 1, 1),
+	} {
+		expect, err := house.Crop(r)
+		assert.Nil(t, err)
+
+		out, err := DecodeRegion(bytes.NewReader(buf.Bytes()), r, nil)
+		assert.Nil(t, err)
+		assert.True(t, expect.Equal(out), expect.Diff(out))
+	}
+
+	_, err := DecodeRegion(bytes.NewReader(buf.Bytes()), image.Rect(5, 5, 11, 6), nil)
+	assert.NotNil(t, err)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "house.tmx")
+
+	house := testHouse()
+	assert.Nil(t, house.WriteFile(fname))
+
+	// a smaller map fully replaces a larger one
+	small := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 1, MapHeight: 1})
+	assert.Nil(t, small.WriteFile(fname))
+
+	m, err := Open(fname)
+	assert.Nil(t, err)
+	assert.True(t, small.Equal(m))
+
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, "house.tmx", files[0].Name())
+
+	err = small.WriteFile(filepath.Join(dir, "missing", "house.tmx"))
+	assert.NotNil(t, err)
+
+	// the mode of an existing file is kept
+	assert.Nil(t, os.Chmod(fname, 0600))
+	assert.Nil(t, house.WriteFile(fname))
+	info, err := os.Stat(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// symlinks are kept, the file they point to is replaced
+	link := filepath.Join(dir, "link.tmx")
+	if os.Symlink("house.tmx", link) != nil {
+		t.Skip("symlinks not supported")
+	}
+	assert.Nil(t, small.WriteFile(link))
+	info, err = os.Lstat(link)
+	assert.Nil(t, err)
+	assert.True(t, info.Mode()&os.ModeSymlink != 0)
+	m, err = Open(fname)
+	assert.Nil(t, err)
+	assert.True(t, small.Equal(m))
+
+	files, err = ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(files))
+
+	// a dangling symlink is kept & the file it points to is created
+	dangling := filepath.Join(dir, "dangling.tmx")
+	assert.Nil(t, os.Symlink(filepath.Join("new", "town.tmx"), dangling))
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "new"), 0755))
+	assert.Nil(t, small.WriteFile(dangling))
+	info, err = os.Lstat(dangling)
+	assert.Nil(t, err)
+	assert.True(t, info.Mode()&os.ModeSymlink != 0)
+	m, err = Open(filepath.Join(dir, "new", "town.tmx"))
+	assert.Nil(t, err)
+	assert.True(t, small.Equal(m))
+}
+
+func TestWriteFileAtomicMode(t *testing.T) {
+	dir := t.TempDir()
+
+	// new files get the same mode as any other new file (ie. the umask applies)
+	f, err := os.Create(filepath.Join(dir, "other"))
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	want, err := os.Stat(filepath.Join(dir, "other"))
+	assert.Nil(t, err)
+
+	fname := filepath.Join(dir, "house.tmx")
+	assert.Nil(t, testHouse().WriteFile(fname))
+	info, err := os.Stat(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, want.Mode().Perm(), info.Mode().Perm())
+}
+
+func BenchmarkEncodeLarge(b *testing.B) {
+	m := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 1024, MapHeight: 1024})
+	for z := 0; z < 4; z++ {
+		for i := 0; i < 1024*1024; i += 7 {
+			m.Set(i%1024, i/1024, z, "grass.png")
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := m.Encode(ioutil.Discard)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
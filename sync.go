@@ -0,0 +1,144 @@
+/* file adds a Map wrapper that's safe to use from many goroutines. */
+package tile
+
+import (
+	"image"
+	"io"
+	"sync"
+)
+
+// SyncMap wraps a Map so it can be used from many goroutines at once.
+// Reads (At, Properties, Fits etc) run together while writes (Set, Add,
+// Encode etc) are made one at a time.
+//
+// A Map itself is not safe for concurrent use; once wrapped the Map should
+// only be used via the SyncMap (or View / Update).
+type SyncMap struct {
+	lock sync.RWMutex
+	m    *Map
+}
+
+// NewSyncMap wraps the given map
+func NewSyncMap(m *Map) *SyncMap {
+	// build the layer index now, so reads never need to
+	m.indexLayers()
+	return &SyncMap{m: m}
+}
+
+// View calls `fn` with the map held for reading. `fn` must not change the map.
+func (s *SyncMap) View(fn func(m *Map) error) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return fn(s.m)
+}
+
+// Update calls `fn` with the map held for writing, eg. to make several
+// changes that other goroutines should see all at once.
+func (s *SyncMap) Update(fn func(m *Map) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// `fn` may add, remove or rename layers directly, rebuild the layer
+	// index now so reads never need to
+	defer s.m.indexLayers()
+	return fn(s.m)
+}
+
+// At returns the src at the given location (see Map.At)
+func (s *SyncMap) At(x, y, z int) (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.m.At(x, y, z)
+}
+
+// Set the tile src at (x,y,z) (see Map.Set)
+func (s *SyncMap) Set(x, y, z int, src string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.m.Set(x, y, z, src)
+}
+
+// Add an object `o` beginning at (x,y,z) (see Map.Add)
+func (s *SyncMap) Add(x, y, zoffset int, o *Map) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.m.Add(x, y, zoffset, o)
+}
+
+// Fits returns if `o` can be added at (x,y,z) without overwriting anything (see Map.Fits)
+func (s *SyncMap) Fits(x, y, zoffset int, o *Map) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.m.Fits(x, y, zoffset, o)
+}
+
+// DropZ returns the zoffset that rests `o` on whatever is below it (see Map.DropZ)
+func (s *SyncMap) DropZ(x, y int, o *Map) (int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.m.DropZ(x, y, o)
+}
+
+// Drop adds `o` at (x,y) resting on whatever is below it (see Map.Drop)
+func (s *SyncMap) Drop(x, y int, o *Map) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.m.Drop(x, y, o)
+}
+
+// Properties returns properties for the given src (see Map.Properties)
+func (s *SyncMap) Properties(src string) (*Properties, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.m.Properties(src)
+}
+
+// SetProperties for the given src (see Map.SetProperties)
+func (s *SyncMap) SetProperties(src string, props *Properties) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.m.SetProperties(src, props)
+}
+
+// ZLevels returns all z-levels in use (see Map.ZLevels)
+func (s *SyncMap) ZLevels() []int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.m.ZLevels()
+}
+
+// Render the map to an image (see Map.Render)
+func (s *SyncMap) Render(cfg *RenderConfig) (image.Image, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.m.Render(cfg)
+}
+
+// Encode the map as XML (see Map.Encode).
+// Encoding updates the map's layer data so this waits for other writes.
+func (s *SyncMap) Encode(w io.Writer) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.m.Encode(w)
+}
+
+// WriteFile writes the map as a .tmx file (see Map.WriteFile)
+func (s *SyncMap) WriteFile(fname string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.m.WriteFile(fname)
+}
+
+// Subscribe to changes of the map (see Map.Subscribe).
+// `fn` is called while the map is held for writing, so must not call the SyncMap.
+func (s *SyncMap) Subscribe(fn func(e Event), filter *EventFilter) func() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.m.Subscribe(fn, filter)
+}
+
+// Watch is Subscribe but events are sent over a channel (see Map.Watch)
+func (s *SyncMap) Watch(filter *EventFilter, buffer int) (<-chan Event, func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.m.Watch(filter, buffer)
+}
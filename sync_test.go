@@ -0,0 +1,115 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSyncMap(t *testing.T) {
+	m := NewSyncMap(New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 16, MapHeight: 16}))
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for x := 0; x < 16; x++ {
+				src := fmt.Sprintf("%d.png", w)
+				assert.Nil(t, m.Set(x, w, w%3, src))
+				got, _ := m.At(x, w, w%3)
+				assert.Equal(t, src, got)
+				m.Fits(x, 0, 0, testTree())
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// each z-level has at least one set tile
+	assert.Equal(t, []int{0, 1, 2}, m.ZLevels())
+	err := m.View(func(m *Map) error {
+		assert.Equal(t, 8, len(m.Tilesets[0].Tiles))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestSyncMapUpdateLayers(t *testing.T) {
+	m := NewSyncMap(New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 4, MapHeight: 4}))
+	assert.Nil(t, m.Set(0, 0, 0, "grass.png"))
+
+	cloud := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 4, MapHeight: 4})
+	cloud.Set(1, 1, 5, "grass.png")
+
+	// layers added directly are indexed before readers see them
+	err := m.Update(func(m *Map) error {
+		m.TileLayers = append(m.TileLayers, cloud.TileLayers...)
+		return nil
+	})
+	assert.Nil(t, err)
+	err = m.View(func(m *Map) error {
+		assert.Equal(t, len(m.TileLayers), m.indexedLayers)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src, _ := m.At(1, 1, 5)
+			assert.Equal(t, "grass.png", src)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestInfiniteMapConcurrentWriters(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+			assert.Nil(t, inf.Set(0, 0, 0, "0"))
+
+			// each worker increments the counter at (0,0,0) in a transaction
+			// while others read; no increment should be lost
+			wg := sync.WaitGroup{}
+			for w := 0; w < 4; w++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					for n := 0; n < 10; n++ {
+						err := inf.Batch(func(tx *InfiniteTx) error {
+							src, err := tx.At(0, 0, 0)
+							if err != nil {
+								return err
+							}
+							count, err := strconv.Atoi(src)
+							if err != nil {
+								return err
+							}
+							return tx.Set(0, 0, 0, strconv.Itoa(count+1))
+						})
+						assert.Nil(t, err)
+					}
+				}()
+				go func(w int) {
+					defer wg.Done()
+					for n := 0; n < 10; n++ {
+						_, err := inf.At(0, 0, 0)
+						assert.Nil(t, err)
+						assert.Nil(t, inf.Set(w+1, n, 0, "grass.png"))
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			src, err := inf.At(0, 0, 0)
+			assert.Nil(t, err)
+			assert.Equal(t, "40", src)
+		})
+	}
+}
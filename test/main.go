@@ -1,19 +1,41 @@
 package main
 
 import (
+	"embed"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/voidshard/tile"
 )
 
+// assets are embedded, so the tests can be run from anywhere
+//
+//go:embed tree.large.01.tmx *.png
+var assets embed.FS
+
+// out is where maps are written, a new temporary directory unless one is given
+var out string
+
 const grass = "grass.png"
 const mushroom = "mushroom.png"
 const treepiece = "tree.large.01.1.3.0.png"
 
 func main() {
-	/*Designed to be run from the repo root
-	 */
-	err := testOne()
+	var err error
+	if len(os.Args) > 1 {
+		out = os.Args[1]
+		err = os.MkdirAll(out, 0755)
+	} else {
+		out, err = os.MkdirTemp("", "tile-test-")
+	}
+	if err != nil {
+		fmt.Println("output dir:", err)
+		os.Exit(1)
+	}
+	fmt.Println("writing maps to", out)
+
+	err = testOne()
 	if err != nil {
 		fmt.Println("test one:", err)
 	}
@@ -43,17 +65,23 @@ func testThree() error {
 		return err
 	}
 
+	err = inf.Close()
+	if err != nil {
+		return err
+	}
+
 	newinf, err := tile.OpenInfiniteMap(inf.Filename())
 	if err != nil {
 		return err
 	}
+	defer newinf.Destroy()
 
-	m, err := newinf.Map(32, 32, 0, 0, 10, 10)
+	m, err := newinf.Map(0, 0, 10, 10)
 	if err != nil {
 		return err
 	}
 
-	err = m.WriteFile("test/three.tmx")
+	err = m.WriteFile(filepath.Join(out, "three.tmx"))
 	if err != nil {
 		return err
 	}
@@ -67,18 +95,19 @@ func testTwo() error {
 	if err != nil {
 		return err
 	}
+	defer inf.Destroy()
 
 	err = decorate(inf)
 	if err != nil {
 		return err
 	}
 
-	m, err := inf.Map(32, 32, 0, 0, 10, 10)
+	m, err := inf.Map(0, 0, 10, 10)
 	if err != nil {
 		return err
 	}
 
-	err = m.WriteFile("test/two.tmx")
+	err = m.WriteFile(filepath.Join(out, "two.tmx"))
 	if err != nil {
 		return err
 	}
@@ -119,7 +148,11 @@ func decorate(m tile.Tileable) error {
 		return err
 	}
 
-	tree, err := tile.Open("test/tree.large.01.tmx")
+	tree, err := tile.OpenFS(assets, "tree.large.01.tmx")
+	if err != nil {
+		return err
+	}
+	_, err = tree.Image(treepiece)
 	if err != nil {
 		return err
 	}
@@ -161,10 +194,14 @@ func testOne() error {
 		return err
 	}
 
-	err = m.WriteFile("test/one.tmx")
+	err = m.WriteFile(filepath.Join(out, "one.tmx"))
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// every tile's image can be found
+	cfg := tile.DefaultRenderConfig()
+	cfg.FS = assets
+	_, err = m.Render(cfg)
+	return err
 }
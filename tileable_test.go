@@ -0,0 +1,157 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+// testTileables returns a new empty Tileable of each implementation by name.
+// Maps are large enough for all of the conformance tests below.
+func testTileables(t *testing.T) map[string]Tileable {
+	impls := map[string]Tileable{
+		"map": New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 20, MapHeight: 20}),
+	}
+	for name, s := range testStorages(t) {
+		impls["infinite/"+name] = NewInfiniteMapWithStorage(s)
+	}
+	return impls
+}
+
+// testTree returns a 3x3 tob with a trunk in the middle of z-level 0 & a
+// canopy in the shape of a plus on z-level 1 (so the corners are empty).
+func testTree() *Map {
+	tree := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 3, MapHeight: 3})
+	tree.Set(1, 1, 0, "trunk.png")
+	tree.Set(1, 0, 1, "leaves.png")
+	tree.Set(0, 1, 1, "leaves.png")
+	tree.Set(1, 1, 1, "leaves.png")
+	tree.Set(2, 1, 1, "leaves.png")
+	tree.Set(1, 2, 1, "leaves.png")
+
+	props := NewProperties()
+	props.SetBool("blocking", true)
+	tree.SetProperties("trunk.png", props)
+
+	return tree
+}
+
+func TestTileableSetAt(t *testing.T) {
+	for name, impl := range testTileables(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, impl.Set(3, 4, 0, "grass.png"))
+			assert.Nil(t, impl.Set(3, 4, 2, "bird.png"))
+
+			src, err := impl.At(3, 4, 0)
+			assert.Nil(t, err)
+			assert.Equal(t, "grass.png", src)
+			src, _ = impl.At(3, 4, 2)
+			assert.Equal(t, "bird.png", src)
+			src, _ = impl.At(3, 4, 1)
+			assert.Equal(t, "", src)
+
+			assert.Nil(t, impl.Set(3, 4, 0, ""))
+			src, _ = impl.At(3, 4, 0)
+			assert.Equal(t, "", src)
+		})
+	}
+}
+
+func TestTileableProperties(t *testing.T) {
+	for name, impl := range testTileables(t) {
+		t.Run(name, func(t *testing.T) {
+			props, err := impl.Properties("")
+			assert.Nil(t, err)
+			assert.Nil(t, props)
+
+			props, err = impl.Properties("unknown.png")
+			assert.Nil(t, err)
+			assert.NotNil(t, props)
+
+			props = NewProperties()
+			props.SetString("kind", "grass")
+			assert.Nil(t, impl.SetProperties("grass.png", props))
+
+			props, _ = impl.Properties("grass.png")
+			kind, _ := props.String("kind")
+			assert.Equal(t, "grass", kind)
+		})
+	}
+}
+
+func TestTileableAdd(t *testing.T) {
+	for name, impl := range testTileables(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, impl.Add(5, 5, 1, testTree()))
+
+			src, _ := impl.At(6, 6, 1)
+			assert.Equal(t, "trunk.png", src)
+			src, _ = impl.At(6, 5, 2)
+			assert.Equal(t, "leaves.png", src)
+			src, _ = impl.At(5, 5, 2)
+			assert.Equal(t, "", src)
+
+			props, _ := impl.Properties("trunk.png")
+			blocking, _ := props.Bool("blocking")
+			assert.True(t, blocking)
+		})
+	}
+}
+
+func TestTileableFits(t *testing.T) {
+	for name, impl := range testTileables(t) {
+		t.Run(name, func(t *testing.T) {
+			tree := testTree()
+			assert.Nil(t, impl.Add(5, 5, 0, tree))
+
+			fits, err := impl.Fits(5, 5, 0, tree)
+			assert.Nil(t, err)
+			assert.False(t, fits)
+
+			// above the tree is free
+			fits, _ = impl.Fits(5, 5, 2, tree)
+			assert.True(t, fits)
+
+			// diagonally adjacent, the bounding boxes overlap but only on empty corners
+			fits, _ = impl.Fits(7, 7, 0, tree)
+			assert.True(t, fits)
+
+			// directly adjacent, the canopies overlap
+			fits, _ = impl.Fits(7, 5, 0, tree)
+			assert.False(t, fits)
+
+			// a tile in one of our empty corners doesn't block us
+			assert.Nil(t, impl.Set(10, 10, 1, "rock.png"))
+			fits, _ = impl.Fits(10, 10, 0, tree)
+			assert.True(t, fits)
+		})
+	}
+}
+
+func TestTileableStack(t *testing.T) {
+	for name, impl := range testTileables(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, impl.Set(2, 2, 0, "grass.png"))
+			assert.Nil(t, impl.Set(2, 2, 1, "dirt.png"))
+
+			flower := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 1, MapHeight: 1})
+			flower.Set(0, 0, 1, "flower.png")
+
+			// a negative zoffset stacks on the highest tile at (x,y)
+			fits, err := impl.Fits(2, 2, -1, flower)
+			assert.Nil(t, err)
+			assert.True(t, fits)
+			assert.Nil(t, impl.Add(2, 2, -1, flower))
+
+			src, _ := impl.At(2, 2, 2)
+			assert.Equal(t, "flower.png", src)
+			src, _ = impl.At(2, 2, 1)
+			assert.Equal(t, "dirt.png", src)
+
+			// with nothing at (x,y) we start from 0
+			assert.Nil(t, impl.Add(4, 4, -1, flower))
+			src, _ = impl.At(4, 4, 1)
+			assert.Equal(t, "flower.png", src)
+		})
+	}
+}
@@ -1,4 +1,5 @@
-/* this file is a simplified set of structs for reading & writing TMX files.
+/*
+this file is a simplified set of structs for reading & writing TMX files.
 
 Much of this code was lifted from github.com/bcvery1/tilepix including
 the encode / decode functions (all credit to authors).
@@ -9,9 +10,13 @@ so we only bother to parse / write those things.
 package tile
 
 import (
+	"bytes"
 	"encoding/xml"
+	"fmt"
+	"image"
+	"math"
+	"sort"
 	"strconv"
-	"strings"
 )
 
 // Map is a TMX file structure representing the map as a whole.
@@ -26,11 +31,23 @@ type Map struct {
 	Height         int           `xml:"height,attr"`      // in tiles
 	TileWidth      int           `xml:"tilewidth,attr"`   // in pixels
 	TileHeight     int           `xml:"tileheight,attr"`  // in pixels
+	ExtraAttrs     []xml.Attr    `xml:",any,attr"`        // attributes we don't use (eg. version), kept as is
 	RootProperties []*Property   `xml:"properties>property"`
 	Tilesets       []*Tileset    `xml:"tileset"`
 	ImageLayers    []*ImageLayer `xml:"imagelayer"`
 	TileLayers     []*TileLayer  `xml:"layer"`
+	Extra          []*RawXML     `xml:",any"` // elements we don't use (eg. editorsettings), kept as is
 	nextID         uint
+	events         *notifier // nil until someone subscribes
+
+	// layerByZ finds tile layers by the z-level they're named after,
+	// built from the first `indexedLayers` of TileLayers (see layer())
+	layerByZ      map[int]*TileLayer
+	indexedLayers int
+
+	layers LayerMode // how new tile layers are held
+
+	images *imageLoader // tile images, relative to where the map was read from
 }
 
 // newTilelayer creates a new tilelayer with the given name &
@@ -46,17 +63,85 @@ func (m *Map) newTilelayer(name string) *TileLayer {
 			Compression: "",
 			RawData:     []byte{},
 		},
-		decodedTiles: make([]uint, m.Width*m.Height),
+		decodedTiles: newLayerTiles(m.Width, m.Height, m.layers.sparse(m.Width, m.Height)),
 	}
 	m.TileLayers = append(m.TileLayers, l)
+
+	// keep the z-level index up to date, if it was before
+	if m.layerByZ != nil && m.indexedLayers == len(m.TileLayers)-1 {
+		m.indexLayer(l)
+		m.indexedLayers++
+	}
 	return l
 }
 
-// newTile registers a new tile by it's image.
+// layer returns the tile layer named after z-level `z` (or nil).
+// This rebuilds the index if it's out of date, a SyncMap keeps it up to date
+// so this doesn't write on it's read paths.
+func (m *Map) layer(z int) *TileLayer {
+	if m.layerByZ == nil || m.indexedLayers != len(m.TileLayers) {
+		// layers were added without newTilelayer
+		m.indexLayers()
+	}
+	return m.layerByZ[z]
+}
+
+// indexLayers rebuilds the z-level => layer index
+func (m *Map) indexLayers() {
+	m.layerByZ = make(map[int]*TileLayer, len(m.TileLayers))
+	for _, tl := range m.TileLayers {
+		m.indexLayer(tl)
+	}
+	m.indexedLayers = len(m.TileLayers)
+}
+
+// indexLayer adds a layer to the z-level index if it's named after a z-level.
+// If two layers have the same name the first is used.
+func (m *Map) indexLayer(tl *TileLayer) {
+	z, ok := layerZ(tl.Name)
+	if !ok {
+		return
+	}
+	if _, dup := m.layerByZ[z]; !dup {
+		m.layerByZ[z] = tl
+	}
+}
+
+// layerZ returns the z-level a layer name is for; names must be written
+// as strconv.Itoa would (eg. "-1" or "12" but not "+1" or "012").
+func layerZ(name string) (int, bool) {
+	digits := name
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 || len(digits) > 18 || (digits[0] == '0' && len(name) > 1) {
+		return 0, false
+	}
+
+	z := 0
+	for i := 0; i < len(digits); i++ {
+		c := digits[i]
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		z = z*10 + int(c-'0')
+	}
+	if len(digits) < len(name) {
+		z = -z
+	}
+	return z, true
+}
+
+// newTile registers a new tile by it's image & returns it with it's gid.
 // We also
-// - add the tile to the last tileset
+// - add the tile to the last tileset (or a new one if we can't add to the last)
 // - set internal caches for finding the tile
-func (m *Map) newTile(source string) *Tile {
+func (m *Map) newTile(source string) (*Tile, uint) {
+	if len(m.Tilesets) == 0 || !m.Tilesets[len(m.Tilesets)-1].isCollection() {
+		m.Tilesets = append(m.Tilesets, newTileset("default", m.nextFirstGID()))
+		m.nextID = 1
+	}
+
 	t := &Tile{
 		ID:         m.nextID,
 		Image:      &Image{Source: source, Width: m.TileWidth, Height: m.TileHeight},
@@ -64,10 +149,73 @@ func (m *Map) newTile(source string) *Tile {
 	}
 	ts := m.Tilesets[len(m.Tilesets)-1]
 	ts.Tiles = append(ts.Tiles, t)
-	ts.tileByID[t.ID] = t
+	ts.tileByID[ts.FirstGID+t.ID] = t
 	ts.tileBySrc[source] = t
 	m.nextID++
-	return t
+	return t, ts.FirstGID + t.ID
+}
+
+// nextFirstGID returns the first gid after all of our tilesets
+func (m *Map) nextFirstGID() uint {
+	next := uint(1)
+	for _, ts := range m.Tilesets {
+		// a tileset takes at least it's first gid, even if it's empty
+		last := ts.FirstGID + 1
+		if ts.TileCount > 0 {
+			last = ts.FirstGID + uint(ts.TileCount)
+		}
+		for _, t := range ts.Tiles {
+			if ts.FirstGID+t.ID+1 > last {
+				last = ts.FirstGID + t.ID + 1
+			}
+		}
+		if last > next {
+			next = last
+		}
+	}
+	return next
+}
+
+// tileByGID returns the tile with the given gid (or nil).
+// Tiled's flip flags are ignored.
+func (m *Map) tileByGID(gid uint) *Tile {
+	gid &^= gidFlipFlags
+	for _, ts := range m.Tilesets {
+		t, ok := ts.tileByID[gid]
+		if ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// tileBySrc returns the tile with the given image src & it's gid (or nil, 0).
+func (m *Map) tileBySrc(source string) (*Tile, uint) {
+	for _, ts := range m.Tilesets {
+		t, ok := ts.tileBySrc[source]
+		if ok {
+			return t, ts.FirstGID + t.ID
+		}
+	}
+	return nil, 0
+}
+
+// index builds the internal caches for finding tiles in each tileset
+func (m *Map) index() {
+	for _, ts := range m.Tilesets {
+		ts.index()
+	}
+
+	m.indexLayers()
+
+	m.nextID = 1
+	if len(m.Tilesets) > 0 {
+		for _, t := range m.Tilesets[len(m.Tilesets)-1].Tiles {
+			if t.ID >= m.nextID {
+				m.nextID = t.ID + 1
+			}
+		}
+	}
 }
 
 // newTileset makes a new tileset starting at `first`
@@ -84,29 +232,114 @@ func newTileset(name string, first uint) *Tileset {
 
 // ImageLayer is a TMX file structure which references an image layer, with associated properties.
 type ImageLayer struct {
-	ID    uint   `xml:"id,attr"`
-	Name  string `xml:"name,attr"`
-	Image *Image `xml:"image"`
+	ID      uint   `xml:"id,attr"`
+	Name    string `xml:"name,attr"`
+	OffsetX int    `xml:"offsetx,attr,omitempty"` // in pixels
+	OffsetY int    `xml:"offsety,attr,omitempty"` // in pixels
+	Image   *Image `xml:"image"`
+
+	ExtraAttrs []xml.Attr `xml:",any,attr"`
+	Extra      []*RawXML  `xml:",any"`
 }
 
-// Tileset is a TMX file structure which represents a Tiled Tileset
+// gidFlipFlags are the top bits of a gid Tiled uses to flip / rotate tiles
+const gidFlipFlags = 0xF0000000
+
+// Tileset is a TMX file structure which represents a Tiled Tileset.
+// We read & write tilesets that are a "collection of images" (each tile has it's
+// own image). Tilesets made from a single image (Image is set) are kept &
+// drawn from, but their tiles have no image source so we can't refer to them
+// by src (except in an InfiniteMap, see sheetSrc).
 type Tileset struct {
-	FirstGID   uint        `xml:"firstgid,attr"`
-	Name       string      `xml:"name,attr"`
-	TileWidth  int         `xml:"tilewidth,attr"`
-	TileHeight int         `xml:"tileheight,attr"`
-	Properties []*Property `xml:"properties>property"`
-	Tiles      []*Tile     `xml:"tile"`
-	Image      *Image      `xml:"image"`
-	tileByID   map[uint]*Tile
+	FirstGID   uint           `xml:"firstgid,attr"`
+	Source     string         `xml:"source,attr,omitempty"` // external (.tsx) tileset, only set until loaded
+	Name       string         `xml:"name,attr"`
+	TileWidth  int            `xml:"tilewidth,attr"`
+	TileHeight int            `xml:"tileheight,attr"`
+	Properties []*Property    `xml:"properties>property"`
+	Tiles      []*Tile        `xml:"tile"`
+	Image      *Image         `xml:"image"`
+	TileCount  int            `xml:"tilecount,attr,omitempty"`
+	ExtraAttrs []xml.Attr     `xml:",any,attr"`
+	Extra      []*RawXML      `xml:",any"`
+	tileByID   map[uint]*Tile // by gid
 	tileBySrc  map[string]*Tile
 }
 
+// index builds the internal caches for finding tiles in the tileset
+func (ts *Tileset) index() {
+	ts.tileByID = map[uint]*Tile{}
+	ts.tileBySrc = map[string]*Tile{}
+
+	for _, t := range ts.Tiles {
+		ts.tileByID[ts.FirstGID+t.ID] = t
+		if t.Image != nil && t.Image.Source != "" {
+			ts.tileBySrc[t.Image.Source] = t
+		}
+	}
+}
+
+// tileProperties returns the properties of tile `id`, empty if it has none
+func (ts *Tileset) tileProperties(id uint) *Properties {
+	t, ok := ts.tileByID[ts.FirstGID+id]
+	if !ok {
+		return NewProperties()
+	}
+	return newPropertiesFromList(t.Properties)
+}
+
+// setTileProperties sets the properties of tile `id`, adding the tile if the
+// tileset doesn't list it (eg. tiles of single image tilesets)
+func (ts *Tileset) setTileProperties(id uint, props *Properties) {
+	t, ok := ts.tileByID[ts.FirstGID+id]
+	if !ok {
+		t = &Tile{ID: id}
+		ts.Tiles = append(ts.Tiles, t)
+		ts.tileByID[ts.FirstGID+id] = t
+	}
+	t.Properties = props.toList()
+}
+
+// intAttr returns the value of an int attribute we don't use (eg. "spacing"), or 0
+func (ts *Tileset) intAttr(name string) int {
+	for _, a := range ts.ExtraAttrs {
+		if a.Name.Local == name {
+			value, _ := strconv.Atoi(a.Value)
+			return value
+		}
+	}
+	return 0
+}
+
+// sheetRect returns where tile `id` of a single image tileset is in it's
+// image, whose bounds are `bounds`
+func (ts *Tileset) sheetRect(id uint, bounds image.Rectangle) image.Rectangle {
+	spacing, margin := ts.intAttr("spacing"), ts.intAttr("margin")
+	columns := ts.intAttr("columns")
+	if columns <= 0 && ts.TileWidth+spacing > 0 {
+		columns = (bounds.Dx() - 2*margin + spacing) / (ts.TileWidth + spacing)
+	}
+	if columns <= 0 {
+		columns = 1
+	}
+
+	x := margin + int(id)%columns*(ts.TileWidth+spacing)
+	y := margin + int(id)/columns*(ts.TileHeight+spacing)
+	return image.Rect(x, y, x+ts.TileWidth, y+ts.TileHeight).Add(bounds.Min)
+}
+
+// isCollection returns if the tileset is a "collection of images" that we can add tiles to
+func (ts *Tileset) isCollection() bool {
+	return ts.Source == "" && ts.Image == nil
+}
+
 // Property is a TMX file structure which holds a Tiled property.
 type Property struct {
 	Name  string `xml:"name,attr"`
 	Value string `xml:"value,attr"`
 	Type  string `xml:"type,attr"` // string (default), int, bool + other (we don't use)
+
+	ExtraAttrs []xml.Attr `xml:",any,attr"`
 }
 
 // Image is an image file in TMX
@@ -114,6 +347,8 @@ type Image struct {
 	Source string `xml:"source,attr"`
 	Width  int    `xml:"width,attr"`
 	Height int    `xml:"height,attr"`
+
+	ExtraAttrs []xml.Attr `xml:",any,attr"`
 }
 
 // Tile is a TMX tile (from a tileset)
@@ -121,6 +356,8 @@ type Tile struct {
 	ID         uint        `xml:"id,attr"`
 	Image      *Image      `xml:"image"`
 	Properties []*Property `xml:"properties>property"`
+	ExtraAttrs []xml.Attr  `xml:",any,attr"`
+	Extra      []*RawXML   `xml:",any"` // eg. animation, collision shapes
 }
 
 // TileLayer is a TMX file structure which can hold any type of Tiled layer.
@@ -129,53 +366,281 @@ type TileLayer struct {
 	Width        int         `xml:"width,attr"`
 	Height       int         `xml:"height,attr"`
 	Name         string      `xml:"name,attr"`
-	Properties   []*Property `xml:"properties>property"` // we support CSV & Base64
-	Data         Data        `xml:"data"`
-	decodedTiles []uint
+	Properties   []*Property `xml:"properties>property"`
+	Data         Data        `xml:"data"` // we support (uncompressed) CSV
+	ExtraAttrs   []xml.Attr  `xml:",any,attr"`
+	Extra        []*RawXML   `xml:",any"`
+	decodedTiles *layerTiles
 }
 
 // Data is a TMX file structure holding data.
 type Data struct {
-	Encoding    string `xml:"encoding,attr"`
-	Compression string `xml:"compression,attr"`
-	RawData     []byte `xml:",innerxml"`
+	Encoding    string     `xml:"encoding,attr"`
+	Compression string     `xml:"compression,attr"`
+	ExtraAttrs  []xml.Attr `xml:",any,attr"`
+	RawData     []byte     `xml:",innerxml"`
+
+	// tiles are written in place of RawData when set (see MarshalXML)
+	tiles         *layerTiles
+	width, height int
+}
+
+// rawData is Data without it's MarshalXML
+type rawData Data
+
+// MarshalXML writes the data. If the layer's tiles are set they're written
+// as csv a row at a time, rather than first being turned into RawData.
+func (d *Data) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if d.tiles == nil {
+		return e.EncodeElement((*rawData)(d), start)
+	}
+
+	start.Attr = append([]xml.Attr{
+		{Name: xml.Name{Local: "encoding"}, Value: d.Encoding},
+		{Name: xml.Name{Local: "compression"}, Value: d.Compression},
+	}, d.ExtraAttrs...)
+	err := e.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+	err = writeCSV(d.width, d.height, d.tiles, func(row []byte) error {
+		return e.EncodeToken(xml.CharData(row))
+	})
+	if err != nil {
+		return err
+	}
+	return e.EncodeToken(start.End())
 }
 
-// encodeCSV turns our list of tile ids back into csv format
-func (d *Data) encodeCSV(width, height int, in []uint) ([]byte, error) {
-	values := make([]string, height)
+// RawXML is an element we don't use, kept as it was read so it's written back
+// unchanged (eg. Tiled's editor settings or object groups)
+type RawXML struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   []byte     `xml:",innerxml"`
+
+	// the number of image & tile layers read before a map's element, so
+	// it's written back in the same place among them
+	imageLayers, tileLayers int
+}
+
+// encodedMap is a Map written with the elements we don't use (eg. object
+// groups) in place among it's layers, rather than after them all
+type encodedMap struct {
+	*Map
+	ImageLayers []struct{}  `xml:"imagelayer"` // written by Layers
+	Layers      layerWriter `xml:"layer"`      // takes the place of Map.TileLayers
+	Extra       []struct{}  `xml:",any"`       // written by Layers
+}
 
-	for row := 0; row < height; row++ {
-		csvrow := make([]string, width)
-		for col := 0; col < width; col++ {
-			csvrow[col] = strconv.Itoa(int(in[row*width+col]))
+// layerWriter writes a map's image & tile layers, with the elements we don't
+// use after the layer they were read after
+type layerWriter struct {
+	m *Map
+}
+
+// position returns the number of layers written before `raw`, image layers
+// are written before tile layers
+func (w *layerWriter) position(raw *RawXML) int {
+	if raw.tileLayers == 0 {
+		return raw.imageLayers
+	}
+	return len(w.m.ImageLayers) + raw.tileLayers
+}
+
+// MarshalXML implements xml.Marshaler, writing every layer & unused element
+func (w *layerWriter) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	extra := append([]*RawXML{}, w.m.Extra...)
+	sort.SliceStable(extra, func(i, j int) bool {
+		return w.position(extra[i]) < w.position(extra[j])
+	})
+
+	written := 0
+	writeExtra := func() error {
+		for len(extra) > 0 && w.position(extra[0]) <= written {
+			err := e.Encode(extra[0])
+			if err != nil {
+				return err
+			}
+			extra = extra[1:]
+		}
+		return nil
+	}
+
+	for _, l := range w.m.ImageLayers {
+		err := writeExtra()
+		if err != nil {
+			return err
+		}
+		err = e.EncodeElement(l, xml.StartElement{Name: xml.Name{Local: "imagelayer"}})
+		if err != nil {
+			return err
+		}
+		written++
+	}
+	for _, l := range w.m.TileLayers {
+		err := writeExtra()
+		if err != nil {
+			return err
+		}
+		err = e.EncodeElement(l, start)
+		if err != nil {
+			return err
 		}
-		values[row] = strings.Join(csvrow, ",")
+		written++
 	}
 
-	return []byte("\n" + strings.Join(values, ",\n") + "\n"), nil
+	// elements after the last layer (or read after more layers than we have)
+	for _, raw := range extra {
+		err := e.Encode(raw)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCSV turns our list of tile ids back into csv format, passing it to
+// `fn` a row at a time (the row is only valid until fn returns)
+func writeCSV(width, height int, in *layerTiles, fn func(row []byte) error) error {
+	row := []byte("\n")
+	if height < 1 {
+		return fn(append(row, '\n'))
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			gid := uint(0)
+			if index := y*width + x; index < in.len() {
+				gid = in.get(index)
+			}
+			if x > 0 {
+				row = append(row, ',')
+			}
+			row = strconv.AppendUint(row, uint64(gid), 10)
+		}
+		if y < height-1 {
+			row = append(row, ',')
+		}
+		row = append(row, '\n')
+
+		err := fn(row)
+		if err != nil {
+			return err
+		}
+		row = row[:0]
+	}
+	return nil
+}
+
+// readCSV reads csv encoded tile data, passing each gid to `fn` in order,
+// & returns the number read. If strict each value must be a number (with
+// optional whitespace & trailing comma), otherwise anything but digits &
+// commas is ignored.
+func (d *Data) readCSV(strict bool, fn func(index int, gid uint)) (int, error) {
+	raw := d.RawData
+	index := 0
+	for start := 0; start <= len(raw); index++ {
+		end := bytes.IndexByte(raw[start:], ',')
+		if end < 0 {
+			end = len(raw)
+		} else {
+			end += start
+		}
+		value := raw[start:end]
+		start = end + 1
+
+		if strict {
+			value = bytes.TrimSpace(value)
+			if len(value) == 0 && start > len(raw) && index > 0 {
+				// trailing comma
+				break
+			}
+		}
+
+		gid, ok := parseGID(value, !strict)
+		if !ok && strict {
+			return index, fmt.Errorf("value %d %q is not a gid", index, value)
+		} else if !ok {
+			_, err := strconv.ParseUint(string(digits(value)), 10, 32)
+			return index, err
+		}
+		fn(index, gid)
+	}
+	return index, nil
+}
+
+// parseGID parses a gid, ignoring anything that isn't a digit if lenient
+func parseGID(value []byte, lenient bool) (uint, bool) {
+	gid := uint64(0)
+	found := false
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			if lenient {
+				continue
+			}
+			return 0, false
+		}
+		gid = gid*10 + uint64(c-'0')
+		if gid > math.MaxUint32 {
+			return 0, false
+		}
+		found = true
+	}
+	return uint(gid), found
 }
 
-// decodeCSV reads csv encoded tile data
-func (d *Data) decodeCSV() ([]uint, error) {
-	cleaner := func(r rune) rune {
-		if (r >= '0' && r <= '9') || r == ',' {
+// digits returns only the digits of `value`
+func digits(value []byte) []byte {
+	return bytes.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
 			return r
 		}
 		return -1
-	}
+	}, value)
+}
 
-	rawDataClean := strings.Map(cleaner, string(d.RawData))
+// renumberExtra gives layers we don't use (eg. object groups) whose ID is
+// taken by one of our layers (or an earlier element) a new ID after all
+// others, & raises the map's "nextlayerid" (if set) past every ID so that
+// layer IDs stay unique
+func (m *Map) renumberExtra() {
+	used := map[int]bool{}
+	max := len(m.ImageLayers) + len(m.TileLayers)
+	for id := 1; id <= max; id++ {
+		used[id] = true
+	}
 
-	str := strings.Split(string(rawDataClean), ",")
+	clashes := []*xml.Attr{}
+	for _, e := range m.Extra {
+		for i, a := range e.Attrs {
+			if a.Name.Local != "id" {
+				continue
+			}
+			id, err := strconv.Atoi(a.Value)
+			if err != nil || used[id] {
+				clashes = append(clashes, &e.Attrs[i])
+				break
+			}
+			used[id] = true
+			if id > max {
+				max = id
+			}
+			break
+		}
+	}
+	for _, a := range clashes {
+		max++
+		a.Value = strconv.Itoa(max)
+	}
 
-	gids := make([]uint, len(str))
-	for i, s := range str {
-		d, err := strconv.ParseUint(s, 10, 32)
-		if err != nil {
-			return nil, err
+	for i, a := range m.ExtraAttrs {
+		if a.Name.Local != "nextlayerid" {
+			continue
+		}
+		next, err := strconv.Atoi(a.Value)
+		if err != nil || next <= max {
+			m.ExtraAttrs[i].Value = strconv.Itoa(max + 1)
 		}
-		gids[i] = uint(d)
 	}
-	return gids, nil
 }
@@ -63,4 +63,29 @@ var csvdata = `<?xml version="1.0" encoding="UTF-8"?>
  </imagelayer>
 </map>`
 
-var csvReEncoded = `<Map orientation="orthogonal" width="10" height="10" tilewidth="32" tileheight="32"><properties></properties><tileset firstgid="1" name="mytiles" tilewidth="32" tileheight="32"><properties></properties><image source="singleWhite.png" width="32" height="32"></image></tileset><layer name="Tile Layer 1"><properties></properties><data encoding="csv" compression="">1,2,3,4,5,6,7,8,9,10,11,12,13,14,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,15,16,17,18,19,20,21,22,23,24,25,26,27,28,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,7,8,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22,21,22</data></layer><imagelayer name="Image Layer 1"><image source="testdata/logo_small.png" width="0" height="0"></image></imagelayer></Map>`
+var csvReEncoded = `<map orientation="orthogonal" width="10" height="10" tilewidth="32" tileheight="32" version="1.0" tiledversion="1.1.6" renderorder="right-down" infinite="1" nextobjectid="7"><properties></properties><tileset firstgid="1" name="mytiles" tilewidth="32" tileheight="32" columns="1"><properties></properties><image source="singleWhite.png" width="32" height="32"></image></tileset><imagelayer id="1" name="Image Layer 1"><image source="testdata/logo_small.png" width="0" height="0"></image></imagelayer><layer id="2" width="10" height="10" name="Tile Layer 1"><properties></properties><data encoding="csv" compression="">
+1,2,3,4,5,6,7,8,9,10,
+11,12,13,14,7,8,7,8,7,8,
+7,8,7,8,7,8,7,8,7,8,
+7,8,15,16,17,18,19,20,21,22,
+23,24,25,26,27,28,21,22,21,22,
+21,22,21,22,21,22,21,22,21,22,
+21,22,21,22,7,8,7,8,7,8,
+7,8,7,8,7,8,7,8,7,8,
+7,8,7,8,7,8,7,8,7,8,
+7,8,7,8,7,8,21,22,21,22
+</data></layer><objectgroup name="Object Layer 1">
+  <object id="1" name="Polygon" x="125" y="51">
+   <polygon points="0,0 66,8 61,52 14,47"/>
+  </object>
+  <object id="2" name="Point" x="202" y="198">
+   <point/>
+  </object>
+  <object id="3" name="Rectangle" x="75" y="199" width="63" height="42"/>
+  <object id="4" name="Ellipse" x="10" y="52" width="100" height="50">
+   <ellipse/>
+  </object>
+  <object id="6" name="Polyline" x="279" y="50">
+   <polyline points="0,0 -28,27 13,49 -25,86"/>
+  </object>
+ </objectgroup></map>`
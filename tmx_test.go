@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -18,7 +19,7 @@ func TestDecode(t *testing.T) {
 	assert.Equal(t, m.Height, 10)
 	assert.Equal(t, m.TileWidth, 32)
 	assert.Equal(t, m.TileHeight, 32)
-	assert.Equal(t, len(m.TileLayers[0].decodedTiles), 1024)
+	assert.Equal(t, m.TileLayers[0].decodedTiles.len(), 1024)
 }
 
 func TestEncode(t *testing.T) {
@@ -35,3 +36,166 @@ func TestEncode(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, csvReEncoded, string(buf.Bytes()))
 }
+
+func TestEncodeCanonical(t *testing.T) {
+	build := func() *Map {
+		m := New(&Config{TileWidth: 32, TileHeight: 32, MapWidth: 4, MapHeight: 4})
+		m.Set(0, 0, 1, "b.png")
+		m.Set(1, 1, 0, "a.png")
+		m.SetBackground("bg.png")
+
+		props := NewProperties()
+		for _, k := range []string{"z", "y", "x", "w", "v", "u"} {
+			props.SetString(k, k)
+			props.SetInt(k+"1", 1)
+			props.SetBool(k+"2", true)
+		}
+		m.SetProperties("a.png", props)
+		m.SetMapProperties(props)
+		return m
+	}
+
+	// the same map is always written the same, as is the map read back
+	first := &bytes.Buffer{}
+	assert.Nil(t, build().Encode(first))
+	for i := 0; i < 5; i++ {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, build().Encode(buf))
+		assert.Equal(t, first.String(), buf.String())
+	}
+	decoded, err := Decode(bytes.NewBuffer(first.Bytes()))
+	assert.Nil(t, err)
+	again := &bytes.Buffer{}
+	assert.Nil(t, decoded.Encode(again))
+	assert.Equal(t, first.String(), again.String())
+	assert.Contains(t, first.String(), `<property name="u" value="u" type="string"></property><property name="u1" value="1" type="int"></property>`)
+
+	// indented output is the same map, one element per line
+	indented := &bytes.Buffer{}
+	assert.Nil(t, decoded.EncodeWithConfig(indented, &EncodeConfig{Indent: "  "}))
+	assert.Contains(t, indented.String(), "\n  <tileset firstgid=\"1\"")
+	assert.True(t, bytes.HasSuffix(indented.Bytes(), []byte("</map>\n")))
+	reread, err := Decode(indented)
+	assert.Nil(t, err)
+	assert.True(t, decoded.Equal(reread), decoded.Diff(reread))
+}
+
+// tiledMap is a map as written by a recent Tiled, with content we don't use
+const tiledMap = `<?xml version="1.0" encoding="UTF-8"?>
+<map version="1.10" tiledversion="1.10.2" orientation="orthogonal" renderorder="right-down" width="2" height="2" tilewidth="32" tileheight="32" infinite="0" backgroundcolor="#203040" nextlayerid="3" nextobjectid="2">
+ <editorsettings>
+  <export target="out.json" format="json"/>
+ </editorsettings>
+ <tileset firstgid="1" name="things" tilewidth="32" tileheight="32" tilecount="1" columns="0">
+  <grid orientation="orthogonal" width="1" height="1"/>
+  <tile id="0" type="Prop" probability="0.5">
+   <image source="lamp.png" width="32" height="32" trans="ff00ff"/>
+   <animation>
+    <frame tileid="0" duration="100"/>
+   </animation>
+  </tile>
+ </tileset>
+ <layer id="1" name="0" width="2" height="2" opacity="0.5" tintcolor="#ff0000">
+  <data encoding="csv">
+1,0,
+0,1
+</data>
+ </layer>
+ <objectgroup id="2" name="Spawns">
+  <object id="1" name="start" x="16" y="16"/>
+ </objectgroup>
+</map>`
+
+func TestDecodeKeepsUnknown(t *testing.T) {
+	m, err := Decode(bytes.NewBufferString(tiledMap))
+	assert.Nil(t, err)
+
+	// add a tile & a layer, so ids change
+	assert.Nil(t, m.Set(1, 0, 4, "chair.png"))
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, m.Clone().Encode(buf))
+	out := buf.String()
+	for _, expect := range []string{
+		`version="1.10" tiledversion="1.10.2"`,
+		`renderorder="right-down"`,
+		`backgroundcolor="#203040"`,
+		`nextobjectid="2"`,
+		`<editorsettings>`,
+		`<export target="out.json" format="json"/>`,
+		`tilecount="2" columns="0"`,
+		`<grid orientation="orthogonal" width="1" height="1"></grid>`,
+		`<tile id="0" type="Prop" probability="0.5">`,
+		`trans="ff00ff"`,
+		`<frame tileid="0" duration="100"/>`,
+		`opacity="0.5" tintcolor="#ff0000"`,
+		`<object id="1" name="start" x="16" y="16"/>`,
+		// layer ids stay unique
+		`<objectgroup id="3" name="Spawns">`,
+		`nextlayerid="4"`,
+	} {
+		assert.Contains(t, out, expect)
+	}
+
+	again, err := Decode(bytes.NewBufferString(out))
+	assert.Nil(t, err)
+	assert.True(t, m.Equal(again), m.Diff(again))
+	assert.Equal(t, 2, len(again.Extra)) // editorsettings & objectgroup
+}
+
+// layeredMap has object groups between it's tile layers
+const layeredMap = `<?xml version="1.0" encoding="UTF-8"?>
+<map orientation="orthogonal" width="1" height="1" tilewidth="32" tileheight="32" nextlayerid="6">
+ <layer id="1" name="0" width="1" height="1">
+  <data encoding="csv">
+0
+</data>
+ </layer>
+ <objectgroup id="5" name="Spawns"/>
+ <layer id="2" name="1" width="1" height="1">
+  <data encoding="csv">
+0
+</data>
+ </layer>
+ <objectgroup id="4" name="Roofs"/>
+</map>`
+
+func TestEncodeKeepsUnknownOrder(t *testing.T) {
+	m, err := Decode(bytes.NewBufferString(layeredMap))
+	assert.Nil(t, err)
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, m.Clone().Encode(buf))
+	out := buf.String()
+
+	// unknown elements stay between the layers they were read between,
+	// keeping their ids as they don't clash
+	order := []string{`<layer id="1" width="1" height="1" name="0"`, `<objectgroup id="5" name="Spawns">`, `<layer id="2" width="1" height="1" name="1"`, `<objectgroup id="4" name="Roofs">`}
+	last := -1
+	for _, expect := range order {
+		at := strings.Index(out, expect)
+		assert.Greater(t, at, last, expect)
+		last = at
+	}
+	assert.Contains(t, out, `nextlayerid="6"`)
+
+	// new layers are written after them, ids that clash are changed
+	assert.Nil(t, m.Set(0, 0, 2, "grass.png"))
+	assert.Nil(t, m.Set(0, 0, 3, "grass.png"))
+	buf.Reset()
+	assert.Nil(t, m.Encode(buf))
+	out = buf.String()
+
+	order = []string{`<layer id="1" width="1" height="1" name="0"`, `<objectgroup id="5" name="Spawns">`, `<layer id="2" width="1" height="1" name="1"`, `<objectgroup id="6" name="Roofs">`, `<layer id="3" width="1" height="1" name="2"`, `<layer id="4" width="1" height="1" name="3"`}
+	last = -1
+	for _, expect := range order {
+		at := strings.Index(out, expect)
+		assert.Greater(t, at, last, expect)
+		last = at
+	}
+	assert.Contains(t, out, `nextlayerid="7"`)
+
+	again, err := Decode(bytes.NewBufferString(out))
+	assert.Nil(t, err)
+	assert.True(t, m.Equal(again), m.Diff(again))
+}
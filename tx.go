@@ -0,0 +1,117 @@
+package tile
+
+// InfiniteTx is a transaction on an InfiniteMap. Changes made via the
+// transaction are written together on Commit (or not at all on Rollback).
+//
+// While a transaction is open all reads & writes should go through it
+// rather than the InfiniteMap. Writes wait for the open transaction to
+// finish, so writing through the InfiniteMap (or starting another
+// transaction) from inside one never returns.
+type InfiniteTx struct {
+	inf *InfiniteMap
+	tx  StorageTx
+	tag string // for logged changes
+
+	// changes made in the transaction, sent to subscribers on commit
+	changes []Change
+}
+
+// Begin starts a new transaction.
+func (i *InfiniteMap) Begin() (*InfiniteTx, error) {
+	tx, err := i.storage.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &InfiniteTx{inf: i, tx: tx}, nil
+}
+
+// Batch runs `fn` inside a transaction. If `fn` returns an error the
+// transaction is rolled back, otherwise it is committed.
+//
+// `fn` must write through `tx` only, calling the InfiniteMap's write methods
+// (Set, Batch, EnableHistory ..) inside it isn't allowed & waits forever.
+func (i *InfiniteMap) Batch(fn func(tx *InfiniteTx) error) error {
+	tx, err := i.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Commit writes all changes made in the transaction
+func (t *InfiniteTx) Commit() error {
+	err := t.tx.Commit()
+	if err != nil {
+		return err
+	}
+	changes := t.changes
+	t.changes = nil
+	t.inf.events.publish(changes...)
+	return nil
+}
+
+// Rollback discards all changes made in the transaction
+func (t *InfiniteTx) Rollback() error {
+	t.changes = nil
+	return t.tx.Rollback()
+}
+
+// SetTag sets the tag of changes logged by the transaction from now on
+// (if history is enabled, see InfiniteMap.EnableHistory).
+func (t *InfiniteTx) SetTag(tag string) {
+	t.tag = tag
+}
+
+// writer returns the store writes in the transaction should go through.
+// If history is enabled or there are subscribers changes are recorded.
+func (t *InfiniteTx) writer() Store {
+	history := t.inf.HistoryEnabled()
+	if !history && !t.inf.events.active() {
+		return t.tx
+	}
+	return &historyStore{Store: t.tx, tag: t.tag, log: history, seen: &t.changes}
+}
+
+// At returns the tile that exists at the given location (or "" if unset)
+func (t *InfiniteTx) At(x, y, z int) (string, error) {
+	return t.inf.at(t.tx, x, y, z)
+}
+
+// Set the given image src at (x,y,z).
+// If "" is passed for src the tile is removed.
+func (t *InfiniteTx) Set(x, y, z int, src string) error {
+	return t.inf.set(t.writer(), x, y, z, src)
+}
+
+// Remove the tile at (x,y,z) (if any)
+func (t *InfiniteTx) Remove(x, y, z int) error {
+	return t.inf.remove(t.writer(), x, y, z)
+}
+
+// Add the given tile object map `o` beginning at (x,y,z)
+func (t *InfiniteTx) Add(x, y, zoffset int, o *Map) error {
+	return t.inf.add(t.writer(), x, y, zoffset, o)
+}
+
+// Fits returns if writing the given tilemap `o` starting at (x,y,z) would require
+// overwriting an already set tile.
+func (t *InfiniteTx) Fits(x, y, z int, o *Map) (bool, error) {
+	return t.inf.fits(t.tx, x, y, z, o)
+}
+
+// Properties returns properties for a given src
+func (t *InfiniteTx) Properties(src string) (*Properties, error) {
+	return t.inf.srcProperties(t.tx, src)
+}
+
+// SetProperties for the given src. This doesn't do an update / merge just overwrites.
+func (t *InfiniteTx) SetProperties(src string, props *Properties) error {
+	return t.inf.setProperties(t.writer(), src, props)
+}
@@ -0,0 +1,66 @@
+package tile
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"fmt"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+
+			err := inf.Batch(func(tx *InfiniteTx) error {
+				for x := 0; x < 10; x++ {
+					tx.Set(x, 0, 0, "grass.png")
+				}
+				tx.Remove(0, 0, 0)
+
+				src, err := tx.At(1, 0, 0)
+				assert.Equal(t, "grass.png", src)
+				return err
+			})
+
+			assert.Nil(t, err)
+			src, _ := inf.At(0, 0, 0)
+			assert.Equal(t, "", src)
+			src, _ = inf.At(9, 0, 0)
+			assert.Equal(t, "grass.png", src)
+
+			// failed batches leave no trace
+			err = inf.Batch(func(tx *InfiniteTx) error {
+				tx.Set(0, 5, 0, "mushroom.png")
+				return fmt.Errorf("oh no")
+			})
+
+			assert.NotNil(t, err)
+			src, _ = inf.At(0, 5, 0)
+			assert.Equal(t, "", src)
+		})
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			inf := NewInfiniteMapWithStorage(s)
+
+			tx, err := inf.Begin()
+			assert.Nil(t, err)
+
+			props := NewProperties()
+			props.SetBool("edible", true)
+			tx.SetProperties("mushroom.png", props)
+			tx.Set(1, 1, 0, "mushroom.png")
+
+			assert.Nil(t, tx.Rollback())
+			src, _ := inf.At(1, 1, 0)
+			assert.Equal(t, "", src)
+			found, _ := inf.Properties("mushroom.png")
+			_, ok := found.Bool("edible")
+			assert.False(t, ok)
+		})
+	}
+}